@@ -1 +1,3 @@
 module go.spiff.io/rusalka
+
+go 1.21
@@ -0,0 +1,47 @@
+package rvm
+
+import "testing"
+
+// TestInstructionAllocs checks the number of allocations made by executing single instructions against a budget. Most
+// allocations in the interpreter come from boxing values in interfaces, so a budget that is exceeded usually means a
+// Value or Index escapes where it did not before. Lower a budget when an optimization reduces its allocations.
+func TestInstructionAllocs(t *testing.T) {
+	tests := []struct {
+		name   string
+		instr  uint32
+		budget float64
+	}{
+		// Boxing the result as a Value.
+		{"add int registers", mkBinaryInstr(OpAdd, RegisterIndex(3), RegisterIndex(4), RegisterIndex(5)), 1},
+		{"add int const", mkBinaryInstr(OpAdd, RegisterIndex(3), RegisterIndex(4), ConstIndex(0)), 1},
+		{"sub int immediate", mkBinaryInstr(OpSub, RegisterIndex(3), RegisterIndex(4), ImmediateIndex(1)), 1},
+		{"load register", mkLoadInstr(RegisterIndex(6), RegisterIndex(4)), 0},
+		{"load const", mkLoadInstr(RegisterIndex(6), ConstIndex(0)), 0},
+		// Boxing the decoded StackIndex as an Index. Only indices in 0..255 avoid this.
+		{"load stack", mkLoadInstr(StackIndex(-1), RegisterIndex(4)), 1},
+		// Boxing the loaded Int as a Value.
+		{"load immediate", mkLoadInstr(RegisterIndex(6), ImmediateIndex(1000)), 1},
+		{"jump", mkJumpInstr(0, nil), 0},
+		{"test int registers", mkTestInstr(CmpLess, true, RegisterIndex(4), RegisterIndex(5)), 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			th := NewThread()
+			th.Push(Int(0))
+			th.pushFrame(0, funcData{consts: []Value{Int(1000)}})
+			th.local[RegisterIndex(4)-specialRegisters] = Int(5000)
+			th.local[RegisterIndex(5)-specialRegisters] = Int(7000)
+
+			instr := Instruction(test.instr)
+			fn := instr.execer()
+			got := testing.AllocsPerRun(100, func() {
+				th.pc = 0
+				fn(instr, th)
+			})
+			if got > test.budget {
+				t.Errorf("%v: %v allocs per run; budget is %v", instr, got, test.budget)
+			}
+		})
+	}
+}
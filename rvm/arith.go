@@ -3,12 +3,13 @@ package rvm
 import (
 	"fmt"
 	"math"
+	"strconv"
 )
 
 type InvalidRoundingMode RoundingMode
 
 func (i InvalidRoundingMode) Error() string {
-	return fmt.Sprintf("invalid rounding mode: %x", i)
+	return fmt.Sprintf("invalid rounding mode: %x", uint(i))
 }
 
 type RoundingMode uint
@@ -20,9 +21,24 @@ const (
 	RoundCeil
 )
 
+func (m RoundingMode) String() string {
+	switch m {
+	case RoundTruncate:
+		return "trunc"
+	case RoundNearest:
+		return "nearest"
+	case RoundFloor:
+		return "floor"
+	case RoundCeil:
+		return "ceil"
+	default:
+		return "{bad-rounding-mode: " + strconv.FormatUint(uint64(m), 10) + "}"
+	}
+}
+
 func round(v Value, mode RoundingMode) Value {
 	if mode > RoundCeil {
-		panic("invalid rounding mode")
+		panic(InvalidRoundingMode(mode))
 	}
 
 loop:
@@ -101,6 +117,10 @@ var (
 	_ Rounder = Float(0)
 	_ Rounder = Int(0)
 	_ Rounder = Uint(0)
+
+	_ Comparable = Float(0)
+	_ Comparable = Int(0)
+	_ Comparable = Uint(0)
 )
 
 // Float64
@@ -118,13 +138,13 @@ func (lhs Float) Sqrt() Arith         { return Float(math.Sqrt(float64(lhs))) }
 func (lhs Float) Round(mode RoundingMode) Value {
 	switch x := float64(lhs); mode {
 	case RoundTruncate:
-		return math.Trunc(x)
+		return Float(math.Trunc(x))
 	case RoundNearest:
-		return math.Trunc(x + math.Copysign(0.5, x))
+		return Float(math.Round(x))
 	case RoundFloor:
-		return math.Floor(x)
+		return Float(math.Floor(x))
 	case RoundCeil:
-		return math.Ceil(x)
+		return Float(math.Ceil(x))
 	}
 	panic("unreachable")
 }
@@ -203,8 +223,10 @@ func (lhs Int) Mul(rhs Arith) Arith {
 func (lhs Int) Div(rhs Arith) Arith {
 	switch rhs := toarith(rhs).(type) {
 	case Int:
+		checkDivisor(uint64(rhs))
 		return Int(int64(lhs) / int64(rhs))
 	case Uint:
+		checkDivisor(uint64(rhs))
 		return Int(int64(lhs) / int64(rhs))
 	case Float:
 		return Float(float64(lhs) / float64(rhs))
@@ -215,8 +237,10 @@ func (lhs Int) Div(rhs Arith) Arith {
 func (lhs Int) Mod(rhs Arith) Arith {
 	switch rhs := toarith(rhs).(type) {
 	case Int:
+		checkDivisor(uint64(rhs))
 		return Int(int64(lhs) % int64(rhs))
 	case Uint:
+		checkDivisor(uint64(rhs))
 		return Int(int64(lhs) % int64(rhs))
 	case Float:
 		return Float(math.Mod(float64(lhs), float64(rhs)))
@@ -323,8 +347,10 @@ func (lhs Uint) Mul(rhs Arith) Arith {
 func (lhs Uint) Div(rhs Arith) Arith {
 	switch rhs := toarith(rhs).(type) {
 	case Uint:
+		checkDivisor(uint64(rhs))
 		return Int(uint64(lhs) / uint64(rhs))
 	case Int:
+		checkDivisor(uint64(rhs))
 		return Uint(int64(lhs) / int64(rhs))
 	case Float:
 		return Float(float64(lhs) / float64(rhs))
@@ -335,8 +361,10 @@ func (lhs Uint) Div(rhs Arith) Arith {
 func (lhs Uint) Mod(rhs Arith) Arith {
 	switch rhs := toarith(rhs).(type) {
 	case Uint:
+		checkDivisor(uint64(rhs))
 		return Int(uint64(lhs) % uint64(rhs))
 	case Int:
+		checkDivisor(uint64(rhs))
 		return Uint(int64(lhs) % int64(rhs))
 	case Float:
 		return Float(math.Mod(float64(lhs), float64(rhs)))
@@ -377,41 +405,151 @@ func (lhs Uint) And(rhs Bitwise) Bitwise { return lhs & touint(rhs) }
 func (lhs Uint) Or(rhs Bitwise) Bitwise  { return lhs | touint(rhs) }
 func (lhs Uint) Not() Bitwise            { return ^lhs }
 
-func toarith(v Value) (r Arith) {
+// Comparison
+
+func (lhs Float) LessThan(rhs Value) bool  { c, ok := compareArith(lhs, rhs); return ok && c < 0 }
+func (lhs Float) LessEqual(rhs Value) bool { c, ok := compareArith(lhs, rhs); return ok && c <= 0 }
+func (lhs Float) EqualTo(rhs Value) bool   { c, ok := compareArith(lhs, rhs); return ok && c == 0 }
+
+func (lhs Int) LessThan(rhs Value) bool  { c, ok := compareArith(lhs, rhs); return ok && c < 0 }
+func (lhs Int) LessEqual(rhs Value) bool { c, ok := compareArith(lhs, rhs); return ok && c <= 0 }
+func (lhs Int) EqualTo(rhs Value) bool   { c, ok := compareArith(lhs, rhs); return ok && c == 0 }
+
+func (lhs Uint) LessThan(rhs Value) bool  { c, ok := compareArith(lhs, rhs); return ok && c < 0 }
+func (lhs Uint) LessEqual(rhs Value) bool { c, ok := compareArith(lhs, rhs); return ok && c <= 0 }
+func (lhs Uint) EqualTo(rhs Value) bool   { c, ok := compareArith(lhs, rhs); return ok && c == 0 }
+
+// compareArith compares two numbers, returning -1, 0, or 1 if lhs is less than, equal to, or greater than rhs. If
+// either operand is a Float, both are compared as floats; otherwise, signed and unsigned integers compare by value. It
+// returns false if either operand is not a Float, Int, or Uint after conversion, or if either is NaN.
+func compareArith(lhs, rhs Value) (int, bool) {
+	l, lok := arithOf(lhs)
+	r, rok := arithOf(rhs)
+	if !lok || !rok {
+		return 0, false
+	}
+
+	switch l := l.(type) {
+	case Float:
+		return compareFloat(l, r)
+	case Int:
+		switch r := r.(type) {
+		case Float:
+			return compareFloat(Float(l), r)
+		case Int:
+			return compareInt(int64(l), int64(r)), true
+		case Uint:
+			if l < 0 {
+				return -1, true
+			}
+			return compareUint(uint64(l), uint64(r)), true
+		}
+	case Uint:
+		switch r := r.(type) {
+		case Float:
+			return compareFloat(Float(l), r)
+		case Uint:
+			return compareUint(uint64(l), uint64(r)), true
+		case Int:
+			if r < 0 {
+				return 1, true
+			}
+			return compareUint(uint64(l), uint64(r)), true
+		}
+	}
+	return 0, false
+}
+
+func compareFloat(lhs Float, rhs Arith) (int, bool) {
+	var r Float
+	switch rhs := rhs.(type) {
+	case Float:
+		r = rhs
+	case Int:
+		r = Float(rhs)
+	case Uint:
+		r = Float(rhs)
+	default:
+		return 0, false
+	}
+	switch {
+	case lhs < r:
+		return -1, true
+	case lhs > r:
+		return 1, true
+	case lhs == r:
+		return 0, true
+	default: // NaN
+		return 0, false
+	}
+}
+
+func compareInt(lhs, rhs int64) int {
+	switch {
+	case lhs < rhs:
+		return -1
+	case lhs > rhs:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func compareUint(lhs, rhs uint64) int {
+	switch {
+	case lhs < rhs:
+		return -1
+	case lhs > rhs:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func toarith(v Value) Arith {
+	if r, ok := arithOf(v); ok {
+		return r
+	}
+	panic(&Fault{Class: FaultConversion, Err: fmt.Errorf("unable to convert %T to arithmetic type", v)})
+}
+
+// arithOf returns v converted to an arithmetic type. It returns false if v has no arithmetic form.
+func arithOf(v Value) (r Arith, ok bool) {
 	switch v := v.(type) {
 	case Arith:
-		return v
+		r = v
 	case FloatValuer:
-		return Float(v.Float64())
+		r = Float(v.Float64())
 	case IntValuer:
-		return Int(v.Int64())
+		r = Int(v.Int64())
 	case UintValuer:
-		return Uint(v.Uint64())
+		r = Uint(v.Uint64())
 	case int:
-		return Int(v)
+		r = Int(v)
 	case int64:
-		return Int(v)
+		r = Int(v)
 	case int32:
-		return Int(v)
+		r = Int(v)
 	case int16:
-		return Int(v)
+		r = Int(v)
 	case float64:
-		return Float(v)
+		r = Float(v)
 	case float32:
-		return Float(v)
+		r = Float(v)
 	case uint:
-		return Uint(v)
+		r = Uint(v)
 	case uint64:
-		return Uint(v)
+		r = Uint(v)
 	case uint32:
-		return Uint(v)
+		r = Uint(v)
 	case uint16:
-		return Uint(v)
+		r = Uint(v)
 	case uint8:
-		return Uint(v)
+		r = Uint(v)
 	default:
-		panic(fmt.Errorf("unable to convert %T to arithmetic type", v))
+		return nil, false
 	}
+	return r, true
 }
 
 func tobitwise(v Value) (r Bitwise) {
@@ -445,7 +583,14 @@ func tobitwise(v Value) (r Bitwise) {
 	case uint8:
 		return Uint(v)
 	default:
-		panic(fmt.Errorf("unable to convert %T to bitwise type", v))
+		panic(&Fault{Class: FaultConversion, Err: fmt.Errorf("unable to convert %T to bitwise type", v)})
+	}
+}
+
+// checkDivisor panics with a divide by zero fault if an integer divisor is zero.
+func checkDivisor(rhs uint64) {
+	if rhs == 0 {
+		panic(&Fault{Class: FaultDivideByZero, Err: errDivideByZero})
 	}
 }
 
@@ -485,6 +630,91 @@ func touint(v Value) Uint {
 	panic("unreachable")
 }
 
+// Fast paths for binary arithmetic on two operands of the same concrete type. These skip toarith and the Arith method
+// call, and return false for any other operands so the caller can fall back to them. Results must match the Arith
+// methods exactly.
+
+func fastAdd(lhs, rhs Value) (Value, bool) {
+	switch l := lhs.(type) {
+	case Int:
+		if r, ok := rhs.(Int); ok {
+			return l + r, true
+		}
+	case Uint:
+		if r, ok := rhs.(Uint); ok {
+			return l + r, true
+		}
+	case Float:
+		if r, ok := rhs.(Float); ok {
+			return l + r, true
+		}
+	}
+	return nil, false
+}
+
+func fastSub(lhs, rhs Value) (Value, bool) {
+	switch l := lhs.(type) {
+	case Int:
+		if r, ok := rhs.(Int); ok {
+			return l - r, true
+		}
+	case Uint:
+		if r, ok := rhs.(Uint); ok {
+			return l - r, true
+		}
+	case Float:
+		if r, ok := rhs.(Float); ok {
+			return l - r, true
+		}
+	}
+	return nil, false
+}
+
+// fastMul has no Uint case: Uint.Mul returns an Int for two Uints, which the slow path preserves.
+func fastMul(lhs, rhs Value) (Value, bool) {
+	switch l := lhs.(type) {
+	case Int:
+		if r, ok := rhs.(Int); ok {
+			return l * r, true
+		}
+	case Float:
+		if r, ok := rhs.(Float); ok {
+			return l * r, true
+		}
+	}
+	return nil, false
+}
+
+// Binary operations on Values, shared by the scalar and vector forms of each binary instruction.
+
+func addValues(lhs, rhs Value) Value {
+	if v, ok := fastAdd(lhs, rhs); ok {
+		return v
+	}
+	return toarith(lhs).Add(toarith(rhs))
+}
+
+func subValues(lhs, rhs Value) Value {
+	if v, ok := fastSub(lhs, rhs); ok {
+		return v
+	}
+	return toarith(lhs).Sub(toarith(rhs))
+}
+
+func mulValues(lhs, rhs Value) Value {
+	if v, ok := fastMul(lhs, rhs); ok {
+		return v
+	}
+	return toarith(lhs).Mul(toarith(rhs))
+}
+
+func divValues(lhs, rhs Value) Value { return toarith(lhs).Div(toarith(rhs)) }
+func powValues(lhs, rhs Value) Value { return toarith(lhs).Pow(toarith(rhs)) }
+func modValues(lhs, rhs Value) Value { return toarith(lhs).Mod(toarith(rhs)) }
+func orValues(lhs, rhs Value) Value  { return tobitwise(lhs).Or(tobitwise(rhs)) }
+func andValues(lhs, rhs Value) Value { return tobitwise(lhs).And(tobitwise(rhs)) }
+func xorValues(lhs, rhs Value) Value { return tobitwise(lhs).Xor(tobitwise(rhs)) }
+
 func arithShift(v, bits Value) Value {
 	var (
 		ov  = v
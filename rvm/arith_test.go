@@ -0,0 +1,47 @@
+package rvm
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestFastArith(t *testing.T) {
+	values := []Value{
+		Int(0), Int(-3), Int(7), Int(math.MaxInt64), Int(math.MinInt64),
+		Uint(0), Uint(3), Uint(math.MaxUint64),
+		Float(0), Float(-2.5), Float(math.Inf(1)), Float(math.NaN()),
+	}
+	ops := []struct {
+		name string
+		fast func(lhs, rhs Value) (Value, bool)
+		slow func(lhs, rhs Arith) Arith
+	}{
+		{"add", fastAdd, Arith.Add},
+		{"sub", fastSub, Arith.Sub},
+		{"mul", fastMul, Arith.Mul},
+	}
+
+	for _, op := range ops {
+		for _, lhs := range values {
+			for _, rhs := range values {
+				got, ok := op.fast(lhs, rhs)
+				if !ok {
+					continue
+				}
+				// Compare formatted values so NaN results match.
+				want := op.slow(toarith(lhs), toarith(rhs))
+				if fmt.Sprintf("%#v", got) != fmt.Sprintf("%#v", want) {
+					t.Errorf("%s(%#v, %#v) = %#v; want %#v", op.name, lhs, rhs, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestInvalidRoundingMode(t *testing.T) {
+	if got, want := InvalidRoundingMode(RoundCeil+1).Error(), "invalid rounding mode: 4"; got != want {
+		t.Errorf("Error() = %q; want %q", got, want)
+	}
+	testPanics(t, "round", func() { round(Float(1), RoundCeil+1) })
+}
@@ -0,0 +1,76 @@
+package rvm
+
+import "fmt"
+
+// AssertionError is the panic value raised by builds with the rvmdebug tag when an instruction about to execute has an
+// operand that is invalid for the current thread and frame. It usually means the instruction was encoded or decoded
+// incorrectly.
+type AssertionError struct {
+	// PC is the code index of the instruction.
+	PC int64
+	// Instr is the instruction.
+	Instr Instruction
+	// Operand is the operand that failed the assertion.
+	Operand Index
+	// Reason describes how the operand is invalid.
+	Reason string
+}
+
+func (e *AssertionError) Error() string {
+	return fmt.Sprintf("assertion failed at code index %d (%v): %v: %s", e.PC, e.Instr, e.Operand, e.Reason)
+}
+
+// assertInstr panics with an *AssertionError if any operand of instr, the instruction just read from the current
+// frame's code, is out of range for the thread: registers outside the function's registers, constants outside its
+// constant table, stack slots outside its frame, TLS slots outside TLSRange, and frame depths deeper than the call
+// stack. It also checks that the frame's ebp lies within the stack. Only builds with the rvmdebug tag call it.
+func (th *Thread) assertInstr(instr Instruction) {
+	pc := th.pc - 1
+	if instr.isExt() {
+		pc--
+	}
+	fail := func(ix Index, format string, args ...interface{}) {
+		panic(&AssertionError{PC: pc, Instr: instr, Operand: ix, Reason: fmt.Sprintf(format, args...)})
+	}
+
+	if th.ebp < th.base || th.ebp > len(th.stack) {
+		fail(RegisterIndex(1), "ebp %d outside frame [%d, %d]", th.ebp, th.base, len(th.stack))
+	}
+
+	reads, writes := instr.accesses()
+	switch op := instr.Opcode(); {
+	case op == OpPush && len(reads) > 0:
+		// Each value pushed from the stack is read after the one before it is pushed, so only the first is known to
+		// exist yet.
+		reads = reads[:1]
+	case op == OpPop || op == OpDup || op == OpAlloc:
+		// Stack destinations of these are resolved after the stack changes size.
+		writes = nil
+	}
+	for _, ix := range append(reads, writes...) {
+		switch ix := ix.(type) {
+		case RegisterIndex:
+			if ix < 0 || ix >= registerCount {
+				fail(ix, "register outside [0, %d)", registerCount)
+			} else if ri := int(ix - specialRegisters); ri >= 0 && ri < callRegisters && ri >= th.localRegisters() {
+				fail(ix, "call register outside the function's %d registers", th.localRegisters())
+			}
+		case ConstIndex:
+			if ix < 0 || int(ix) >= len(th.consts) {
+				fail(ix, "constant outside the function's %d constants", len(th.consts))
+			}
+		case StackIndex:
+			if abs := ix.abs(th); abs < th.ebp || abs >= len(th.stack) {
+				fail(ix, "stack[%d] outside frame [%d, %d)", abs, th.ebp, len(th.stack))
+			}
+		case TLSIndex:
+			if !TLSRange.Contains(int64(ix)) {
+				fail(ix, "TLS slot outside %v", TLSRange)
+			}
+		case FrameIndex:
+			if ix.Depth < 1 || ix.Depth > len(th.frames) {
+				fail(ix, "frame depth outside [1, %d]", len(th.frames))
+			}
+		}
+	}
+}
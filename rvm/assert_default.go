@@ -0,0 +1,7 @@
+//go:build !rvmdebug
+// +build !rvmdebug
+
+package rvm
+
+// instrAssertions is false unless built with the rvmdebug tag.
+const instrAssertions = false
@@ -0,0 +1,9 @@
+//go:build rvmdebug
+// +build rvmdebug
+
+package rvm
+
+// instrAssertions enables checking the operands of every instruction before it executes. A failed check panics with an
+// *AssertionError naming the operand and what is wrong with it, instead of whatever the instruction would have done
+// with it. This makes every instruction slower and is meant for tracking down encoding and decoding bugs.
+const instrAssertions = true
@@ -0,0 +1,47 @@
+//go:build rvmdebug
+// +build rvmdebug
+
+package rvm
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestInstrAssertions(t *testing.T) {
+	cases := []struct {
+		name    string
+		code    []uint32
+		operand Index
+	}{
+		{"const", codeTable(nil).
+			load(RegisterIndex(20), ImmediateIndex(1)).
+			load(RegisterIndex(21), ConstIndex(1)).
+			v(), ConstIndex(1)},
+		{"stack", codeTable(nil).
+			load(StackIndex(1), ImmediateIndex(1)).
+			v(), StackIndex(1)},
+		{"register", codeTable(nil).
+			load(RegisterIndex(5), ImmediateIndex(1)).
+			v(), RegisterIndex(5)},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			th := NewThread()
+			th.Push(Int(1))
+			th.pushFrame(-1, funcData{code: c.code, consts: []Value{Int(0)}, info: FuncInfo{Registers: 2}})
+
+			var rp *RuntimePanic
+			if err := th.RunProtected(); !errors.As(err, &rp) {
+				t.Fatalf("RunProtected() = %v; want *RuntimePanic", err)
+			}
+			if ae, ok := rp.Value.(*AssertionError); !ok {
+				t.Fatalf("panic value = %#v; want *AssertionError", rp.Value)
+			} else if ae.Operand != c.operand || ae.PC != int64(len(c.code)-1) {
+				t.Fatalf("assertion %v for operand %v at %d; want %v at %d", ae, ae.Operand, ae.PC, c.operand,
+					len(c.code)-1)
+			}
+		})
+	}
+}
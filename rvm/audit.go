@@ -0,0 +1,16 @@
+package rvm
+
+import "fmt"
+
+// ConcurrentUseError is the panic value raised by builds with the rvmaudit tag when a Thread is used by a goroutine
+// while another goroutine is still using it. Threads are not safe for concurrent use.
+type ConcurrentUseError struct {
+	// Owner is the stack trace of the goroutine that was using the thread.
+	Owner []byte
+	// Stack is the stack trace of the goroutine that attempted to use the thread.
+	Stack []byte
+}
+
+func (e *ConcurrentUseError) Error() string {
+	return fmt.Sprintf("concurrent use of *Thread\n\nowner:\n%s\ncurrent:\n%s", e.Owner, e.Stack)
+}
@@ -0,0 +1,14 @@
+//go:build !rvmaudit
+// +build !rvmaudit
+
+package rvm
+
+// auditing is false unless built with the rvmaudit tag. Thread methods only call their audit hooks if it is set, so
+// default builds compile the hooks and their defers out.
+const auditing = false
+
+// threadAudit is a no-op unless built with the rvmaudit tag.
+type threadAudit struct{}
+
+func (*threadAudit) enter() {}
+func (*threadAudit) exit()  {}
@@ -0,0 +1,59 @@
+//go:build rvmaudit
+// +build rvmaudit
+
+package rvm
+
+import (
+	"bytes"
+	"runtime"
+	"strconv"
+	"sync"
+)
+
+// auditing enables checking Threads for concurrent use. Every exported Thread method except Pause and Resume, which are
+// meant to be called from other goroutines, enters the thread's audit for its duration.
+const auditing = true
+
+// threadAudit tracks which goroutine is using a Thread and panics with a *ConcurrentUseError if a second goroutine uses
+// it at the same time.
+type threadAudit struct {
+	mu    sync.Mutex
+	owner uint64
+	depth int
+	stack []byte
+}
+
+func (a *threadAudit) enter() {
+	id, stack := goroutineStack()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.depth > 0 && a.owner != id {
+		panic(&ConcurrentUseError{Owner: a.stack, Stack: stack})
+	} else if a.depth == 0 {
+		a.owner, a.stack = id, stack
+	}
+	a.depth++
+}
+
+func (a *threadAudit) exit() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.depth--; a.depth == 0 {
+		a.owner, a.stack = 0, nil
+	}
+}
+
+// goroutineStack returns the current goroutine's ID and stack trace. The ID is parsed from the trace's header, which is
+// only acceptable because this is a debugging aid.
+func goroutineStack() (id uint64, stack []byte) {
+	stack = make([]byte, 4096)
+	stack = stack[:runtime.Stack(stack, false)]
+
+	// goroutine 123 [running]:
+	hdr := bytes.TrimPrefix(stack, []byte("goroutine "))
+	if i := bytes.IndexByte(hdr, ' '); i > 0 {
+		id, _ = strconv.ParseUint(string(hdr[:i]), 10, 64)
+	}
+	return id, stack
+}
@@ -0,0 +1,58 @@
+//go:build rvmaudit
+// +build rvmaudit
+
+package rvm
+
+import "testing"
+
+func TestThreadConcurrentUse(t *testing.T) {
+	th := NewThread()
+	th.audit.enter()
+	defer th.audit.exit()
+
+	done := make(chan interface{})
+	go func() {
+		defer func() { done <- recover() }()
+		th.Push(Int(1))
+	}()
+
+	if rc := <-done; rc == nil {
+		t.Error("Push from second goroutine did not panic")
+	} else if _, ok := rc.(*ConcurrentUseError); !ok {
+		t.Errorf("Push from second goroutine panicked with %v; want *ConcurrentUseError", rc)
+	}
+
+	// Same goroutine may re-enter
+	th.Push(Int(1))
+	if got := th.Pop(); got != Int(1) {
+		t.Errorf("Pop() = %v; want 1", got)
+	}
+}
+
+func TestThreadAuditedMethods(t *testing.T) {
+	th := NewThread()
+	th.Push(Int(1))
+	th.audit.enter()
+	defer th.audit.exit()
+
+	methods := map[string]func(){
+		"SetZeroPolicy": func() { th.SetZeroPolicy(ZeroOnRelease) },
+		"Stats":         func() { th.Stats() },
+		"ResetStats":    func() { th.ResetStats() },
+		"Backtrace":     func() { th.Backtrace() },
+		"RunProtected":  func() { th.RunProtected() },
+		"Slice":         func() { th.Slice(0, 1) },
+		"Roots":         func() { th.Roots(func(Value) bool { return true }) },
+		"Reserve":       func() { th.Reserve(1) },
+	}
+	for name, fn := range methods {
+		done := make(chan interface{})
+		go func() {
+			defer func() { done <- recover() }()
+			fn()
+		}()
+		if _, ok := (<-done).(*ConcurrentUseError); !ok {
+			t.Errorf("%s from second goroutine did not panic with *ConcurrentUseError", name)
+		}
+	}
+}
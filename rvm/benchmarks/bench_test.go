@@ -0,0 +1,223 @@
+package benchmarks
+
+import (
+	"fmt"
+	"testing"
+
+	"go.spiff.io/rusalka/rvm"
+)
+
+type reg = rvm.RegisterIndex
+
+// loops is the iteration count of each looping workload.
+const loops = 1000
+
+func function(consts []rvm.Value, code ...rvm.Instruction) rvm.Function {
+	var words []uint32
+	for _, instr := range code {
+		words = instr.AppendTo(words)
+	}
+	return rvm.Function{Code: words, Consts: consts}
+}
+
+// must returns instr, panicking if err is not nil.
+func must(instr rvm.Instruction, err error) rvm.Instruction {
+	if err != nil {
+		panic(err)
+	}
+	return instr
+}
+
+// benchFunction runs fn on a new thread b.N times and checks that it leaves want in %3.
+func benchFunction(b *testing.B, fn rvm.Function, want rvm.Value) {
+	b.Helper()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		th := rvm.NewThread()
+		th.Enter(fn, 0)
+		th.Run()
+		if got := th.At(reg(3)); got != want {
+			b.Fatalf("%%3 = %#v; want %#v", got, want)
+		}
+	}
+}
+
+// BenchmarkDispatch measures the cost of the interpreter loop with the cheapest useful instruction, a register load.
+func BenchmarkDispatch(b *testing.B) {
+	code := make([]rvm.Instruction, loops)
+	for i := range code {
+		code[i] = must(rvm.EncodeLoad(rvm.Reg(3), rvm.Imm(i%2)))
+	}
+	benchFunction(b, function(nil, code...), rvm.Int((loops-1)%2))
+}
+
+// BenchmarkLoad runs straight-line loads into a register from each kind of source.
+func BenchmarkLoad(b *testing.B) {
+	srcs := []struct {
+		name string
+		src  rvm.Operand
+	}{
+		{"Register", rvm.Reg(4)},
+		{"Const", rvm.Const(0)},
+		{"Stack", rvm.Stack(-1)},
+		{"Immediate", rvm.Imm(1000)},
+	}
+
+	for _, s := range srcs {
+		b.Run(s.name, func(b *testing.B) {
+			code := []rvm.Instruction{must(rvm.EncodeLoad(rvm.Reg(4), rvm.Const(0))), must(rvm.EncodePushPop(rvm.OpPush, 1, rvm.Const(0)))}
+			for i := 0; i < loops; i++ {
+				code = append(code, must(rvm.EncodeLoad(rvm.Reg(3+i%2*2), s.src)))
+			}
+			fn := function([]rvm.Value{rvm.Int(1000)}, code...)
+			benchFunction(b, fn, rvm.Int(1000))
+		})
+	}
+}
+
+// BenchmarkVector adds 1024 pairs of stack values, either one element per instruction or 64 per vector instruction.
+func BenchmarkVector(b *testing.B) {
+	const width = 64
+	prologue := []rvm.Instruction{must(rvm.EncodePushPop(rvm.OpPush, width, rvm.Imm(1)))}
+
+	b.Run("Scalar", func(b *testing.B) {
+		code := prologue
+		for i := 0; i < 1024; i++ {
+			code = append(code, must(rvm.EncodeBinary(rvm.OpAdd, rvm.Stack(i%32), rvm.Stack(i%32), rvm.Stack(-1-i%32))))
+		}
+		benchFunction(b, function(nil, code...), nil)
+	})
+	b.Run("Vector", func(b *testing.B) {
+		code := prologue
+		for i := 0; i < 1024/width; i++ {
+			code = append(code, must(rvm.EncodeVector(rvm.OpAdd, width, rvm.Stack(0), rvm.Stack(0), rvm.Stack(-width))))
+		}
+		benchFunction(b, function(nil, code...), nil)
+	})
+}
+
+// BenchmarkArithLoop counts down from loops, summing each counter value.
+func BenchmarkArithLoop(b *testing.B) {
+	b.Run("Int", func(b *testing.B) {
+		benchFunction(b, arithLoop(rvm.Int(loops), rvm.Int(0), rvm.Int(1)), rvm.Int(loops*(loops+1)/2))
+	})
+	b.Run("Float", func(b *testing.B) {
+		benchFunction(b, arithLoop(rvm.Float(loops), rvm.Float(0), rvm.Float(1)), rvm.Float(loops*(loops+1)/2))
+	})
+}
+
+func arithLoop(n, zero, one rvm.Value) rvm.Function {
+	return function([]rvm.Value{n, zero, one},
+		must(rvm.EncodeLoad(rvm.Reg(4), rvm.Const(0))),
+		must(rvm.EncodeLoad(rvm.Reg(3), rvm.Const(1))),
+		// loop:
+		must(rvm.EncodeBinary(rvm.OpAdd, rvm.Reg(3), rvm.Reg(3), rvm.Reg(4))),
+		must(rvm.EncodeBinary(rvm.OpSub, rvm.Reg(4), rvm.Reg(4), rvm.Const(2))),
+		must(rvm.EncodeTest(rvm.CmpLess, true, rvm.Const(1), rvm.Reg(4))),
+		must(rvm.EncodeJump(-4, rvm.Operand{})),
+	)
+}
+
+// BenchmarkBinaryOps runs straight-line add, sub, and mul instructions over each pair of operand types. Same-type
+// pairs take the interpreter's fast path; mixed pairs go through the Arith interface.
+func BenchmarkBinaryOps(b *testing.B) {
+	pairs := []struct {
+		name     string
+		lhs, rhs rvm.Value
+	}{
+		{"Int", rvm.Int(3), rvm.Int(2)},
+		{"Uint", rvm.Uint(3), rvm.Uint(2)},
+		{"Float", rvm.Float(3), rvm.Float(2)},
+		{"Int+Float", rvm.Int(3), rvm.Float(2)},
+		{"Uint+Int", rvm.Uint(3), rvm.Int(2)},
+	}
+	ops := []rvm.Opcode{rvm.OpAdd, rvm.OpSub, rvm.OpMul}
+
+	for _, p := range pairs {
+		b.Run(p.name, func(b *testing.B) {
+			code := []rvm.Instruction{must(rvm.EncodeLoad(rvm.Reg(4), rvm.Const(0)))}
+			for i := 0; i < loops; i++ {
+				code = append(code, must(rvm.EncodeBinary(ops[i%len(ops)], rvm.Reg(3), rvm.Reg(4), rvm.Const(1))))
+			}
+			fn := function([]rvm.Value{p.lhs, p.rhs}, code...)
+
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				th := rvm.NewThread()
+				th.Enter(fn, 0)
+				th.Run()
+			}
+		})
+	}
+}
+
+// BenchmarkFib computes Fibonacci numbers iteratively. Calls are not implemented by the interpreter yet, so this is
+// the loop-and-register form of the usual recursive benchmark.
+func BenchmarkFib(b *testing.B) {
+	const n = 90
+	fn := function([]rvm.Value{rvm.Int(n)},
+		must(rvm.EncodeLoad(rvm.Reg(3), rvm.Imm(0))),
+		must(rvm.EncodeLoad(rvm.Reg(4), rvm.Imm(1))),
+		must(rvm.EncodeLoad(rvm.Reg(5), rvm.Imm(0))),
+		// loop:
+		must(rvm.EncodeBinary(rvm.OpAdd, rvm.Reg(6), rvm.Reg(3), rvm.Reg(4))),
+		must(rvm.EncodeLoad(rvm.Reg(3), rvm.Reg(4))),
+		must(rvm.EncodeLoad(rvm.Reg(4), rvm.Reg(6))),
+		must(rvm.EncodeBinary(rvm.OpAdd, rvm.Reg(5), rvm.Reg(5), rvm.Imm(1))),
+		must(rvm.EncodeTest(rvm.CmpLess, true, rvm.Reg(5), rvm.Const(0))),
+		must(rvm.EncodeJump(-6, rvm.Operand{})),
+	)
+	benchFunction(b, fn, rvm.Int(2880067194370816120))
+}
+
+// BenchmarkPushPop pushes and pops values through registers and the stack.
+func BenchmarkPushPop(b *testing.B) {
+	fn := function([]rvm.Value{rvm.Int(loops)},
+		must(rvm.EncodeLoad(rvm.Reg(3), rvm.Imm(0))),
+		must(rvm.EncodeLoad(rvm.Reg(4), rvm.Imm(1))),
+		must(rvm.EncodeLoad(rvm.Reg(5), rvm.Imm(2))),
+		// loop:
+		must(rvm.EncodePushPop(rvm.OpPush, 3, rvm.Reg(3))),
+		must(rvm.EncodePushPop(rvm.OpPush, 8, rvm.Imm(0))),
+		must(rvm.EncodeStackOp(rvm.OpDup, 4, 3)),
+		must(rvm.EncodeStackOp(rvm.OpRotate, 6, 2)),
+		must(rvm.EncodePushPop(rvm.OpPop, 12, rvm.Operand{})),
+		must(rvm.EncodePushPop(rvm.OpPop, 3, rvm.Reg(3))),
+		must(rvm.EncodeBinary(rvm.OpAdd, rvm.Reg(3), rvm.Reg(3), rvm.Imm(1))),
+		must(rvm.EncodeTest(rvm.CmpLess, true, rvm.Reg(3), rvm.Const(0))),
+		must(rvm.EncodeJump(-9, rvm.Operand{})),
+	)
+	benchFunction(b, fn, rvm.Int(loops))
+}
+
+// BenchmarkStackChurn grows and releases a large block of the stack, so each iteration reuses released slots.
+func BenchmarkStackChurn(b *testing.B) {
+	fn := function([]rvm.Value{rvm.Int(loops)},
+		must(rvm.EncodeLoad(rvm.Reg(3), rvm.Imm(0))),
+		// loop:
+		must(rvm.EncodeAlloc(rvm.Imm(64))),
+		must(rvm.EncodeLoad(rvm.Stack(-1), rvm.Reg(3))),
+		must(rvm.EncodePushPop(rvm.OpPush, 32, rvm.Stack(-32))),
+		must(rvm.EncodeAlloc(rvm.Imm(-96))),
+		must(rvm.EncodeBinary(rvm.OpAdd, rvm.Reg(3), rvm.Reg(3), rvm.Imm(1))),
+		must(rvm.EncodeTest(rvm.CmpLess, true, rvm.Reg(3), rvm.Const(0))),
+		must(rvm.EncodeJump(-7, rvm.Operand{})),
+	)
+	benchFunction(b, fn, rvm.Int(loops))
+}
+
+// BenchmarkCall enters and returns from a function, for register windows of different sizes. Entering a function saves
+// only the call registers in its window.
+func BenchmarkCall(b *testing.B) {
+	for _, n := range []int{1, 4, 16} {
+		b.Run(fmt.Sprint("Registers=", n), func(b *testing.B) {
+			fn := rvm.Function{Info: rvm.FuncInfo{Registers: n}}
+			th := rvm.NewThread()
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				th.Enter(fn, 0)
+				th.Return(0)
+			}
+		})
+	}
+}
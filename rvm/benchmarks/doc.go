@@ -0,0 +1,8 @@
+// Package benchmarks holds baseline benchmarks for the rvm interpreter. It has no exported API; run it with
+//
+//	go test -run '^$' -bench . -benchmem ./rvm/benchmarks
+//
+// Changes that affect interpreter performance should report before and after results for these benchmarks (e.g.,
+// using benchstat). Each workload is a single function run on a new thread, so results include the cost of entering
+// the function but are dominated by instruction dispatch.
+package benchmarks
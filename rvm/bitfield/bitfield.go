@@ -0,0 +1,53 @@
+// Package bitfield encodes signed and unsigned integers into fixed-width bit fields of 32- and 64-bit words. It is
+// used by the rvm instruction encoders and may be used by external assemblers and extension opcodes to encode
+// operands the same way.
+//
+// A field is described by its position, the offset of its least significant bit, and its length in bits. Encoding a
+// value that does not fit in a field truncates it to the field's low bits; use CanStore or CanStoreUnsigned to check
+// a value first.
+package bitfield
+
+// Signed32 returns the low length bits of i, in two's complement, shifted to pos. Bits outside the field are zero.
+func Signed32(i int32, pos, length uint) uint32 {
+	return uint32(i<<(32-length)) >> (32 - (length + pos)) & ((1<<length - 1) << pos)
+}
+
+// Signed64 returns the low length bits of i, in two's complement, shifted to pos. Bits outside the field are zero.
+func Signed64(i int64, pos, length uint) uint64 {
+	return (uint64(i<<(64-length)) >> (64 - (length + pos))) & ((1<<length - 1) << pos)
+}
+
+// Unsigned32 returns the low length bits of i shifted to pos. Bits outside the field are zero.
+func Unsigned32(i uint32, pos, length uint) uint32 {
+	return uint32(i&(1<<length-1)) << pos
+}
+
+// Unsigned64 returns the low length bits of i shifted to pos. Bits outside the field are zero.
+func Unsigned64(i uint64, pos, length uint) uint64 {
+	return uint64(i&(1<<length-1)) << pos
+}
+
+// CanStore returns whether i can be stored in a two's complement field of the given number of bits without loss.
+func CanStore(i int64, bits uint) bool {
+	bits-- // sign bit
+	var (
+		max int64 = 1<<bits - 1
+		min int64 = -max - 1
+	)
+	return i >= min && i <= max
+}
+
+// CanStoreUnsigned returns whether i can be stored in an unsigned field of the given number of bits without loss.
+func CanStoreUnsigned(i uint64, bits uint) bool {
+	return i <= (^uint64(0) >> (64 - bits))
+}
+
+// ExtractSigned returns the two's complement field of length bits at pos in w, sign extended.
+func ExtractSigned(w uint64, pos, length uint) int64 {
+	return int64(w<<(64-(length+pos))) >> (64 - length)
+}
+
+// ExtractUnsigned returns the unsigned field of length bits at pos in w.
+func ExtractUnsigned(w uint64, pos, length uint) uint64 {
+	return (w >> pos) & (1<<length - 1)
+}
@@ -0,0 +1,94 @@
+package bitfield
+
+import "testing"
+
+func TestSigned(t *testing.T) {
+	tests := []struct {
+		i           int64
+		pos, length uint
+		want        uint64
+	}{
+		{0, 0, 8, 0},
+		{1, 4, 4, 0x10},
+		{-1, 4, 4, 0xF0},
+		{-8, 4, 4, 0x80},
+		{7, 7, 6, 0x380},
+		{-1, 8, 23, 0x7FFFFF00},
+		{-2, 0, 32, 0xFFFFFFFE},
+		// Out of range values are truncated to the field.
+		{8, 4, 4, 0x80},
+		{-9, 4, 4, 0x70},
+	}
+
+	for _, c := range tests {
+		if got := Signed64(c.i, c.pos, c.length); got != c.want {
+			t.Errorf("Signed64(%d, %d, %d) = %#x; want %#x", c.i, c.pos, c.length, got, c.want)
+		}
+		if got := Signed32(int32(c.i), c.pos, c.length); got != uint32(c.want) {
+			t.Errorf("Signed32(%d, %d, %d) = %#x; want %#x", c.i, c.pos, c.length, got, c.want)
+		}
+		if CanStore(c.i, c.length) {
+			if got := ExtractSigned(c.want, c.pos, c.length); got != c.i {
+				t.Errorf("ExtractSigned(%#x, %d, %d) = %d; want %d", c.want, c.pos, c.length, got, c.i)
+			}
+		}
+	}
+
+	if got, want := Signed64(-1, 32, 32), uint64(0xFFFFFFFF00000000); got != want {
+		t.Errorf("Signed64(-1, 32, 32) = %#x; want %#x", got, want)
+	}
+}
+
+func TestUnsigned(t *testing.T) {
+	tests := []struct {
+		i           uint64
+		pos, length uint
+		want        uint64
+	}{
+		{0, 0, 8, 0},
+		{1, 4, 4, 0x10},
+		{0xF, 4, 4, 0xF0},
+		{0x3FF, 22, 10, 0xFFC00000},
+		// Out of range values are truncated to the field.
+		{0x1F, 4, 4, 0xF0},
+	}
+
+	for _, c := range tests {
+		if got := Unsigned64(c.i, c.pos, c.length); got != c.want {
+			t.Errorf("Unsigned64(%d, %d, %d) = %#x; want %#x", c.i, c.pos, c.length, got, c.want)
+		}
+		if got := Unsigned32(uint32(c.i), c.pos, c.length); got != uint32(c.want) {
+			t.Errorf("Unsigned32(%d, %d, %d) = %#x; want %#x", c.i, c.pos, c.length, got, c.want)
+		}
+		if CanStoreUnsigned(c.i, c.length) {
+			if got := ExtractUnsigned(c.want, c.pos, c.length); got != c.i {
+				t.Errorf("ExtractUnsigned(%#x, %d, %d) = %d; want %d", c.want, c.pos, c.length, got, c.i)
+			}
+		}
+	}
+}
+
+func TestCanStore(t *testing.T) {
+	for bits := uint(1); bits <= 63; bits++ {
+		max := int64(1)<<(bits-1) - 1
+		min := -max - 1
+		if !CanStore(max, bits) || !CanStore(min, bits) {
+			t.Errorf("CanStore(%d..%d, %d) = false; want true", min, max, bits)
+		}
+		if CanStore(max+1, bits) || CanStore(min-1, bits) {
+			t.Errorf("CanStore(%d or %d, %d) = true; want false", min-1, max+1, bits)
+		}
+
+		umax := uint64(1)<<bits - 1
+		if !CanStoreUnsigned(umax, bits) {
+			t.Errorf("CanStoreUnsigned(%d, %d) = false; want true", umax, bits)
+		}
+		if CanStoreUnsigned(umax+1, bits) {
+			t.Errorf("CanStoreUnsigned(%d, %d) = true; want false", umax+1, bits)
+		}
+	}
+
+	if !CanStoreUnsigned(^uint64(0), 64) {
+		t.Errorf("CanStoreUnsigned(max, 64) = false; want true")
+	}
+}
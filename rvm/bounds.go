@@ -0,0 +1,99 @@
+package rvm
+
+import "fmt"
+
+// OperandRange is the inclusive range of values that an instruction operand field can encode.
+type OperandRange struct {
+	Min, Max int64
+}
+
+func signedRange(bits uint) OperandRange {
+	max := int64(1)<<(bits-1) - 1
+	return OperandRange{Min: -max - 1, Max: max}
+}
+
+func unsignedRange(bits uint) OperandRange {
+	return OperandRange{Min: 0, Max: int64(1)<<bits - 1}
+}
+
+// Contains returns whether i is in the range.
+func (r OperandRange) Contains(i int64) bool {
+	return i >= r.Min && i <= r.Max
+}
+
+func (r OperandRange) String() string {
+	return fmt.Sprintf("%d..%d", r.Min, r.Max)
+}
+
+// Operand ranges for each instruction field. Encoders validate operands against these ranges, and decoders read
+// fields of the same widths, so an index inside its field's range always round-trips.
+//
+// Register operands of every instruction use RegisterRange. Push, pop, and vector instructions additionally require
+// the whole register range they touch to fit in RegisterRange. Vector instructions use the binary operand ranges. Wide
+// binary and test instructions use the Wide ranges for every operand.
+var (
+	RegisterRange = OperandRange{Min: 0, Max: registerCount - 1}
+
+	BinaryOutStackRange  = signedRange(opBinOutLen)
+	BinaryArgAStackRange = signedRange(opBinArgALen)
+	BinaryArgBStackRange = signedRange(opBinArgBStackLen)
+	BinaryArgBConstRange = unsignedRange(opBinArgBLen)
+	BinaryArgBImmRange   = signedRange(opBinArgBImmLen)
+
+	WideStackRange = signedRange(opWideValLen)
+	WideConstRange = unsignedRange(opWideValLen)
+	WideImmRange   = signedRange(opWideValLen)
+
+	TestArgAStackRange = signedRange(opTestArgAStackLen)
+	TestArgAConstRange = unsignedRange(opTestArgALen)
+	TestArgBStackRange = signedRange(opTestArgBStackLen)
+	TestArgBConstRange = unsignedRange(opTestArgBLen)
+
+	JumpOffsetRange = signedRange(opJumpLitLen)
+	JumpStackRange  = signedRange(opJumpStackLen)
+	JumpConstRange  = unsignedRange(opJumpRelLen)
+
+	LoadDstStackRange  = signedRange(opLoadDstLen)
+	LoadSrcStackRange  = signedRange(opLoadSrcLen)
+	LoadSrcConstRange  = unsignedRange(opLoadSrcLen)
+	LoadSrcImmRange    = signedRange(opLoadSrcLen)
+	XloadDstStackRange = signedRange(opXloadDstLen)
+	XloadSrcStackRange = signedRange(opXloadSrcLen)
+	XloadSrcConstRange = unsignedRange(opXloadSrcLen)
+	XloadSrcImmRange   = signedRange(opXloadSrcLen)
+	RelRegOffsetRange  = signedRange(opXloadRelLen)
+
+	PushPopCountRange  = OperandRange{Min: 1, Max: 1 << opPushPopRangeLen}
+	PushPopStackRange  = signedRange(opPushPopTargetLen)
+	PushConstRange     = unsignedRange(opPushPopTargetLen)
+	PushImmediateRange = signedRange(opPushPopTargetLen)
+	StackOpArgRange    = signedRange(opPushPopTargetLen)
+	XpushListRange     = unsignedRange(opXpushListLen)
+	SizeImmRange       = signedRange(opSizeImmLen)
+	VectorCountRange   = OperandRange{Min: 1, Max: 1<<opVecCountLen - 1}
+	TLSRange           = unsignedRange(opTLSSlotLen)
+	FrameDepthRange    = OperandRange{Min: 1, Max: 1<<opFrameDepthLen - 1}
+	FrameStackRange    = signedRange(opFrameIndexLen)
+	RoundingModeRange  = OperandRange{Min: int64(RoundTruncate), Max: int64(RoundCeil)}
+)
+
+func checkRegisterOperand(o Operand) error {
+	if !RegisterRange.Contains(o.Value) {
+		return InvalidRegister(o.Value)
+	}
+	return nil
+}
+
+func checkStackOperand(o Operand, r OperandRange) error {
+	if !r.Contains(o.Value) {
+		return InvalidStackIndex(o.Value)
+	}
+	return nil
+}
+
+func checkConstOperand(o Operand, r OperandRange) error {
+	if !r.Contains(o.Value) {
+		return InvalidConstIndex(o.Value)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package rvm
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// StackCanaryError is the panic value raised by builds with the rvmcanary tag when the stack element just below a
+// frame's ebp, which belongs to its caller, has been overwritten while the frame was active. It is raised when the frame
+// is popped, or when the frame below it is popped if the element was damaged while returning to it.
+type StackCanaryError struct {
+	// Index is the absolute stack index of the guarded element.
+	Index int
+	// Depth is the depth of the frame the canary guards, where 1 is the first frame pushed onto the thread.
+	Depth int
+	// Func is the function running in the frame the canary guards.
+	Func FuncInfo
+	// Found is the value found in place of the element.
+	Found Value
+}
+
+func (e *StackCanaryError) Error() string {
+	return fmt.Sprintf("stack canary for frame %d (%v): stack[%d] overwritten with %#v", e.Depth, e.Func, e.Index,
+		e.Found)
+}
+
+// frameCanary is the shadow copy of the stack element below a frame's ebp, recorded when the frame is pushed in builds
+// with the rvmcanary tag. Canaries are kept out of band, in Thread.canaries, so they do not change the stack that code
+// and hosts see.
+type frameCanary struct {
+	index int // Absolute stack index of the guarded element, or -1 if the frame's ebp is 0
+	value Value
+}
+
+// pushCanary records the canary for a frame being pushed with the given ebp.
+func (th *Thread) pushCanary(ebp int) {
+	c := frameCanary{index: ebp - 1}
+	if c.index >= 0 {
+		c.value = th.stack[c.index]
+	}
+	th.canaries = append(th.canaries, c)
+}
+
+// updateCanaries refreshes any canary guarding the absolute stack index i after a legitimate store to it, such as a
+// frame instruction storing to a caller's frame.
+func (th *Thread) updateCanaries(i int) {
+	for j := range th.canaries {
+		if th.canaries[j].index == i {
+			th.canaries[j].value = th.stack[i]
+		}
+	}
+}
+
+// checkCanary panics with a *StackCanaryError if the canary of the frame at depth, which must be the current frame, is
+// not intact.
+func (th *Thread) checkCanary(depth int) {
+	c := th.canaries[depth-1]
+	if c.index < 0 || c.index < len(th.stack) && sameValue(th.stack[c.index], c.value) {
+		return
+	}
+	e := &StackCanaryError{Index: c.index, Depth: depth, Func: th.info}
+	if c.index < len(th.stack) {
+		e.Found = th.stack[c.index]
+	}
+	panic(e)
+}
+
+// sameValue returns whether a and b are the same value. Values of types that cannot be compared with == are the same if
+// they refer to the same data, so changes made through a reference are not mistaken for overwrites.
+func sameValue(a, b Value) bool {
+	ta := reflect.TypeOf(a)
+	if ta == nil || ta.Comparable() {
+		return a == b
+	} else if ta != reflect.TypeOf(b) {
+		return false
+	}
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	switch va.Kind() {
+	case reflect.Slice:
+		return va.Pointer() == vb.Pointer() && va.Len() == vb.Len()
+	case reflect.Map, reflect.Func:
+		return va.Pointer() == vb.Pointer()
+	default:
+		return reflect.DeepEqual(a, b)
+	}
+}
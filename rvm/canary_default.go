@@ -0,0 +1,7 @@
+//go:build !rvmcanary
+// +build !rvmcanary
+
+package rvm
+
+// stackCanaries is false unless built with the rvmcanary tag.
+const stackCanaries = false
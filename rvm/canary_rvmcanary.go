@@ -0,0 +1,9 @@
+//go:build rvmcanary
+// +build rvmcanary
+
+package rvm
+
+// stackCanaries enables stack canaries. When a frame is pushed, the stack element below its ebp is copied aside, and
+// the frame panics with a *StackCanaryError when it is popped if that element has changed other than through a frame
+// instruction. The copies are kept outside the stack, so canaries do not change stack layout or program behavior.
+const stackCanaries = true
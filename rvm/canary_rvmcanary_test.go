@@ -0,0 +1,37 @@
+//go:build rvmcanary
+// +build rvmcanary
+
+package rvm
+
+import "testing"
+
+func TestStackCanary(t *testing.T) {
+	th := NewThread()
+	th.Push(Int(1))
+	th.Push(Int(2))
+	th.pushFrame(-1, funcData{})
+	if got := th.At(StackIndex(0)); got != Int(2) {
+		t.Fatalf("stack[0] = %v; want 2", got)
+	}
+	th.Push(Int(3))
+	th.popFrame(1)
+	if want := []Value{Int(1), Int(3)}; len(th.stack) != 2 || th.stack[0] != want[0] || th.stack[1] != want[1] {
+		t.Fatalf("stack = %v; want %v", th.stack, want)
+	}
+
+	// A frame writing below its ebp is caught when it returns.
+	th.pushFrame(0, funcData{info: FuncInfo{Name: "clobber"}})
+	StackIndex(-1).store(th, Int(4))
+	func() {
+		defer func() {
+			e, ok := recover().(*StackCanaryError)
+			if !ok {
+				t.Fatalf("popFrame panicked with %v; want *StackCanaryError", e)
+			}
+			if e.Depth != 1 || e.Index != 1 || e.Func.Name != "clobber" || e.Found != Int(4) {
+				t.Errorf("error = %+v; want depth 1 at stack[1] in clobber, found 4", e)
+			}
+		}()
+		th.popFrame(0)
+	}()
+}
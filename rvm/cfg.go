@@ -0,0 +1,182 @@
+package rvm
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// cfgBlock is a basic block of code: a run of instructions entered only at start and left only after its last
+// instruction.
+type cfgBlock struct {
+	start, end int // Code indices; end is exclusive
+	succs      []cfgEdge
+}
+
+// cfgEdge is an edge to the block starting at to. If to is not the start of an instruction, the edge leads to the end
+// of the code (when to is the code's length) or to an invalid target (-1). If dynamic is set, the target is read at
+// runtime and to is unused.
+type cfgEdge struct {
+	to      int
+	label   string
+	dynamic bool
+}
+
+// WriteCFG writes the control-flow graph of code to w in Graphviz DOT format. Each node is a basic block labeled with
+// its disassembly, formatted using opts. Edges from a test are labeled "pass" and "fail". Jumps whose target is read
+// from a register, stack slot, or constant, and writes to %pc, lead to a "dynamic" node; edges to the end of the code
+// lead to an "end" node.
+func WriteCFG(w io.Writer, code []uint32, opts FormatOptions) error {
+	var b strings.Builder
+	b.WriteString("digraph code {\n\tnode [shape=box fontname=monospace];\n")
+
+	var (
+		blocks                = cfgBlocks(code)
+		codeEnd               int
+		dynamic, end, invalid bool
+	)
+	if len(blocks) > 0 {
+		codeEnd = blocks[len(blocks)-1].end
+	}
+
+	for _, blk := range blocks {
+		var label strings.Builder
+		for pc := blk.start; pc < blk.end; {
+			instr, n, _ := DecodeInstruction(code[pc:])
+			fmt.Fprintf(&label, "%-6d ", pc)
+			instr.format(&label, opts)
+			label.WriteString("\n")
+			pc += n
+		}
+		fmt.Fprintf(&b, "\tb%d [label=%s];\n", blk.start, dotLabel(label.String()))
+
+		for _, e := range blk.succs {
+			to := fmt.Sprintf("b%d", e.to)
+			switch {
+			case e.dynamic:
+				to, dynamic = "dynamic", true
+			case e.to == codeEnd:
+				to, end = "end", true
+			case e.to < 0:
+				to, invalid = "invalid", true
+			}
+			fmt.Fprintf(&b, "\tb%d -> %s", blk.start, to)
+			if e.label != "" {
+				fmt.Fprintf(&b, " [label=%q]", e.label)
+			}
+			b.WriteString(";\n")
+		}
+	}
+
+	if dynamic {
+		b.WriteString("\tdynamic [shape=diamond];\n")
+	}
+	if end {
+		b.WriteString("\tend [shape=oval];\n")
+	}
+	if invalid {
+		b.WriteString("\tinvalid [shape=octagon];\n")
+	}
+	b.WriteString("}\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// dotLabel returns s quoted as a left-justified DOT label.
+func dotLabel(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\l`)
+	return `"` + r.Replace(s) + `"`
+}
+
+// cfgBlocks splits code into basic blocks, in code order. A truncated extended instruction at the end of code is
+// dropped.
+func cfgBlocks(code []uint32) []cfgBlock {
+	type decoded struct {
+		pc    int
+		n     int
+		instr Instruction
+	}
+
+	var (
+		instrs []decoded
+		starts = map[int]bool{}
+	)
+	for pc := 0; pc < len(code); {
+		instr, n, ok := DecodeInstruction(code[pc:])
+		if !ok {
+			break
+		}
+		instrs = append(instrs, decoded{pc, n, instr})
+		starts[pc] = true
+		pc += n
+	}
+	if len(instrs) == 0 {
+		return nil
+	}
+	last := instrs[len(instrs)-1]
+	codeEnd := last.pc + last.n
+
+	// Successors of each instruction that ends a block.
+	succs := map[int][]cfgEdge{}
+	leaders := map[int]bool{0: true}
+	for i, d := range instrs {
+		next := d.pc + d.n
+		switch op := d.instr.Opcode(); {
+		case op == OpJump:
+			if off, ix := d.instr.jumpOffset(); ix == nil {
+				succs[d.pc] = []cfgEdge{{to: next + int(off)}}
+			} else {
+				succs[d.pc] = []cfgEdge{{dynamic: true}}
+			}
+		case op == OpTest:
+			skip := next
+			if i+1 < len(instrs) {
+				skip += instrs[i+1].n
+			}
+			succs[d.pc] = []cfgEdge{{to: next, label: "pass"}, {to: skip, label: "fail"}}
+		case d.instr.writesPC():
+			succs[d.pc] = []cfgEdge{{dynamic: true}}
+		default:
+			continue
+		}
+
+		leaders[next] = true
+		for j, e := range succs[d.pc] {
+			if e.dynamic {
+				continue
+			} else if !starts[e.to] && e.to != codeEnd {
+				succs[d.pc][j].to = -1
+			}
+			leaders[e.to] = true
+		}
+	}
+
+	var blocks []cfgBlock
+	for _, d := range instrs {
+		if leaders[d.pc] {
+			blocks = append(blocks, cfgBlock{start: d.pc})
+		}
+		blk := &blocks[len(blocks)-1]
+		blk.end = d.pc + d.n
+
+		if s, ok := succs[d.pc]; ok {
+			blk.succs = s
+		} else if next := d.pc + d.n; leaders[next] || next == codeEnd {
+			blk.succs = []cfgEdge{{to: next}}
+		}
+	}
+
+	return blocks
+}
+
+// writesPC returns whether the instruction stores to %pc, other than by a jump.
+func (i Instruction) writesPC() bool {
+	_, writes := i.accesses()
+	for _, ix := range writes {
+		if ix == RegisterIndex(0) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,51 @@
+package rvm
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWriteCFG(t *testing.T) {
+	code := codeTable(nil).
+		load(RegisterIndex(3), ImmediateIndex(0)).                              // 0
+		test(CmpLess, true, RegisterIndex(3), ConstIndex(0)).                   // 1
+		jump(3, nil).                                                           // 2 -> 6
+		jump(0, RegisterIndex(4)).                                              // 3
+		xload(RegisterIndex(5), ConstIndex(1)).                                 // 4, 5
+		binaryOp(OpAdd, RegisterIndex(3), RegisterIndex(3), ImmediateIndex(1)). // 6
+		jump(-8, nil).                                                          // 7 -> 0
+		jump(1, nil).                                                           // 8 -> 10 (end)
+		jump(5, nil).                                                           // 9 -> 15
+		v()
+
+	var b strings.Builder
+	if err := WriteCFG(&b, code, FormatOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	const want = `digraph code {
+	node [shape=box fontname=monospace];
+	b0 [label="0      load %3 $0\l1      test (%3 < const[0]) == true\l"];
+	b0 -> b2 [label="pass"];
+	b0 -> b3 [label="fail"];
+	b2 [label="2      jump 3\l"];
+	b2 -> b6;
+	b3 [label="3      jump %4\l"];
+	b3 -> dynamic;
+	b4 [label="4      xload %5 const[1]\l"];
+	b4 -> b6;
+	b6 [label="6      add %3 %3 $1\l7      jump -8\l"];
+	b6 -> b0;
+	b8 [label="8      jump 1\l"];
+	b8 -> end;
+	b9 [label="9      jump 5\l"];
+	b9 -> invalid;
+	dynamic [shape=diamond];
+	end [shape=oval];
+	invalid [shape=octagon];
+}
+`
+	if got := b.String(); got != want {
+		t.Errorf("WriteCFG() =\n%s\nwant\n%s", got, want)
+	}
+}
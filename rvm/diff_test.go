@@ -0,0 +1,102 @@
+package rvm
+
+import (
+	"fmt"
+	"testing"
+)
+
+// Differential testing: run the same code under several Thread configurations, one instruction at a time, and fail
+// at the first instruction after which their observable states differ. Configurations should only change how the
+// interpreter works, never what the code computes.
+
+type threadConfig struct {
+	name  string
+	setup func(*Thread)
+}
+
+func testDiffThreads(t *testing.T, fn funcData, configs ...threadConfig) {
+	t.Helper()
+	if len(configs) < 2 {
+		t.Fatal("need at least two configurations to compare")
+	}
+
+	threads := make([]*Thread, len(configs))
+	for i, c := range configs {
+		threads[i] = NewThread()
+		if c.setup != nil {
+			c.setup(threads[i])
+		}
+		threads[i].pushFrame(0, fn)
+	}
+
+	for step := 0; ; step++ {
+		var (
+			want         *Snapshot
+			wantDone     bool
+			wantErr      error
+			instr, _, ok = DecodeInstruction(fn.code[min(int(threads[0].pc), len(fn.code)):])
+		)
+
+		for i, th := range threads {
+			_, done, err := th.RunN(1)
+			if i == 0 {
+				want, wantDone, wantErr = th.Inspect(), done, err
+				continue
+			}
+
+			// Capacity, statistics, and other details that configurations may legitimately change are not part of a
+			// snapshot.
+			got := th.Inspect()
+			changes := want.Diff(got)
+			if len(changes) > 0 || len(got.Frames) != len(want.Frames) || done != wantDone ||
+				fmt.Sprint(err) != fmt.Sprint(wantErr) {
+				if ok {
+					t.Logf("step %d: %v", step, instr)
+				}
+				t.Fatalf("step %d: %s and %s differ: %v\nframes: %v, %v\ndone: %t, %t\nerr: %v, %v",
+					step, configs[0].name, configs[i].name, changes,
+					want.Frames, got.Frames, wantDone, done, wantErr, err)
+			}
+		}
+
+		if wantDone {
+			return
+		}
+	}
+}
+
+func TestDifferentialThreadConfigs(t *testing.T) {
+	configs := []threadConfig{
+		{"default", nil},
+		{"zero-on-reuse", func(th *Thread) { th.SetZeroPolicy(ZeroOnReuse) }},
+		{"reserved", func(th *Thread) { th.Reserve(1024) }},
+		{"zero-on-reuse+reserved", func(th *Thread) {
+			th.SetZeroPolicy(ZeroOnReuse)
+			th.Reserve(1024)
+		}},
+	}
+
+	// Build up and churn the stack so released slots are reused.
+	fn := funcData{
+		code: codeTable(nil).
+			load(RegisterIndex(3), ImmediateIndex(10)).
+			push(1, ImmediateIndex(0)).
+			binaryOp(OpAdd, StackIndex(-1), StackIndex(-1), RegisterIndex(3)).
+			test(CmpEqual, true, RegisterIndex(3), ConstIndex(0)).
+			jump(1, nil).
+			binaryOp(OpSub, RegisterIndex(3), RegisterIndex(3), ImmediateIndex(1)).
+			push(4, ConstIndex(1)).
+			dup(2, 1).
+			rotate(6, 2).
+			drop(5).
+			push(3, StackIndex(0)).
+			pop(2, RegisterIndex(4)).
+			size(OpAlloc, ImmediateIndex(8)).
+			drop(9).
+			push(2, ImmediateIndex(7)).
+			v(),
+		consts: []Value{Int(0), Int(1), Float(2), Uint(3), Int(4)},
+	}
+
+	testDiffThreads(t, fn, configs...)
+}
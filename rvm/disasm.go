@@ -0,0 +1,141 @@
+package rvm
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// FormatOptions controls how Instruction.Format and Disassemble format instructions. The zero value produces the same
+// output as Instruction.String.
+type FormatOptions struct {
+	// DstLast places an instruction's destination operand after its source operands (AT&T-like), instead of first
+	// (three-address form). It only affects instructions that write to an index, such as add or load.
+	DstLast bool
+	// Hex formats stack, constant, and immediate indices and integer operands in hexadecimal. Register names are
+	// unaffected.
+	Hex bool
+	// Raw appends the instruction's code words in brackets.
+	Raw bool
+	// Consts, if not nil, is used to show the value of constant operands, as in "const[1]=10.3". Constant indices out of
+	// range of Consts are shown without a value.
+	Consts []Value
+}
+
+// Format returns the instruction formatted according to opts.
+func (i Instruction) Format(opts FormatOptions) string {
+	var b strings.Builder
+	i.format(&b, opts)
+	return b.String()
+}
+
+func (i Instruction) format(b *strings.Builder, opts FormatOptions) {
+	dst, args, ok := i.operands()
+	if !ok {
+		b.WriteString("<unknown opcode for instruction " + strconv.FormatUint(uint64(i), 16) + ">")
+		return
+	}
+
+	if i.isExt() {
+		b.WriteByte('x')
+	}
+	op := i.Opcode()
+	b.WriteString(op.String())
+
+	if op == OpTest {
+		// test (lhs op rhs) == want
+		b.WriteString(" (")
+		formatOperand(b, args[0], opts)
+		b.WriteByte(' ')
+		formatOperand(b, args[1], opts)
+		b.WriteByte(' ')
+		formatOperand(b, args[2], opts)
+		b.WriteString(") == ")
+		formatOperand(b, args[3], opts)
+	} else {
+		if dst != nil && !opts.DstLast {
+			b.WriteByte(' ')
+			formatOperand(b, dst, opts)
+		}
+		for _, arg := range args {
+			b.WriteByte(' ')
+			formatOperand(b, arg, opts)
+		}
+		if dst != nil && opts.DstLast {
+			b.WriteByte(' ')
+			formatOperand(b, dst, opts)
+		}
+	}
+
+	if opts.Raw {
+		if i.isExt() {
+			fmt.Fprintf(b, " [%016x]", uint64(i))
+		} else {
+			fmt.Fprintf(b, " [%08x]", uint32(i))
+		}
+	}
+}
+
+func formatOperand(b *strings.Builder, arg interface{}, opts FormatOptions) {
+	num := func(n int64) string {
+		if !opts.Hex {
+			return strconv.FormatInt(n, 10)
+		} else if n < 0 {
+			return "-0x" + strconv.FormatUint(uint64(-n), 16)
+		}
+		return "0x" + strconv.FormatUint(uint64(n), 16)
+	}
+
+	switch arg := arg.(type) {
+	case StackIndex:
+		b.WriteString("stack[" + num(int64(arg)) + "]")
+	case ConstIndex:
+		b.WriteString("const[" + num(int64(arg)) + "]")
+		if int(arg) < len(opts.Consts) {
+			fmt.Fprint(b, "=", opts.Consts[arg])
+		}
+	case ImmediateIndex:
+		b.WriteString("$" + num(int64(arg)))
+	case int:
+		b.WriteString(num(int64(arg)))
+	case int64:
+		b.WriteString(num(arg))
+	default:
+		fmt.Fprint(b, arg)
+	}
+}
+
+// Disassemble writes a listing of code to w, one instruction per line, prefixed by the instruction's code index. If the
+// code ends with a truncated extended instruction, it is listed as invalid.
+func Disassemble(w io.Writer, code []uint32, opts FormatOptions) error {
+	var b strings.Builder
+	for pc := 0; pc < len(code); {
+		instr, n, ok := DecodeInstruction(code[pc:])
+		if !ok {
+			fmt.Fprintf(&b, "%-6d <truncated extended instruction [%08x]>\n", pc, code[pc])
+			break
+		}
+		fmt.Fprintf(&b, "%-6d ", pc)
+		instr.format(&b, opts)
+		b.WriteByte('\n')
+		pc += n
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// DecodeInstruction decodes the instruction at the start of code, returning it and the number of code words it
+// occupies. It returns false if code is empty or ends partway through an extended instruction.
+func DecodeInstruction(code []uint32) (instr Instruction, n int, ok bool) {
+	if len(code) == 0 {
+		return 0, 0, false
+	}
+	instr = Instruction(code[0])
+	if !instr.isExt() {
+		return instr, 1, true
+	} else if len(code) < 2 {
+		return 0, 0, false
+	}
+	return instr | Instruction(code[1])<<32, 2, true
+}
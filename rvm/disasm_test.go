@@ -0,0 +1,70 @@
+package rvm
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestInstructionFormat(t *testing.T) {
+	consts := []Value{Int(1), Float(10.5)}
+
+	tests := []struct {
+		instr Instruction
+		opts  FormatOptions
+		want  string
+	}{
+		{newBinary(OpAdd, RegisterIndex(4), StackIndex(-20), ConstIndex(1)), FormatOptions{},
+			"add %4 stack[-20] const[1]"},
+		{newBinary(OpAdd, RegisterIndex(4), StackIndex(-20), ConstIndex(1)), FormatOptions{DstLast: true},
+			"add stack[-20] const[1] %4"},
+		{newBinary(OpAdd, RegisterIndex(4), StackIndex(-20), ConstIndex(1)), FormatOptions{Hex: true},
+			"add %4 stack[-0x14] const[0x1]"},
+		{newBinary(OpAdd, RegisterIndex(4), StackIndex(-20), ConstIndex(1)), FormatOptions{Consts: consts},
+			"add %4 stack[-20] const[1]=10.5"},
+		{newLoad(RegisterIndex(4), ConstIndex(2)), FormatOptions{Consts: consts, DstLast: true},
+			"load const[2] %4"},
+		{newLoad(RegisterIndex(4), ConstIndex(0)), FormatOptions{Raw: true},
+			"load %4 const[0] [00004226]"},
+		{newXload(RegisterIndex(4), ConstIndex(0)), FormatOptions{Raw: true},
+			"xload %4 const[0] [0000000040010027]"},
+		{newPushPop(OpPush, 3, ImmediateIndex(-255)), FormatOptions{Hex: true},
+			"push 0x3 $-0xff"},
+		{newJump(-16, nil), FormatOptions{Hex: true},
+			"jump -0x10"},
+		{newTest(CmpLess, true, RegisterIndex(5), ConstIndex(0)), FormatOptions{Consts: consts, DstLast: true},
+			"test (%5 < const[0]=1) == true"},
+		{newRound(RegisterIndex(5), RegisterIndex(6), RoundFloor), FormatOptions{DstLast: true},
+			"round %6 floor %5"},
+	}
+
+	for _, tc := range tests {
+		if got := tc.instr.Format(tc.opts); got != tc.want {
+			t.Errorf("Format(%+v) = %q; want %q", tc.opts, got, tc.want)
+		}
+	}
+}
+
+func TestDisassemble(t *testing.T) {
+	code := codeTable(nil).
+		load(RegisterIndex(3), ConstIndex(0)).
+		xload(RegisterIndex(4), ConstIndex(1)).
+		jump(-4, nil).
+		v()
+	code = append(code, uint32(instrExtendedBit))
+
+	var b strings.Builder
+	if err := Disassemble(&b, code, FormatOptions{}); err != nil {
+		t.Fatalf("Disassemble() = %v", err)
+	}
+
+	want := strings.Join([]string{
+		"0      load %3 const[0]",
+		"1      xload %4 const[1]",
+		"3      jump -4",
+		"4      <truncated extended instruction [00000001]>",
+		"",
+	}, "\n")
+	if got := b.String(); got != want {
+		t.Errorf("Disassemble() =\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,183 @@
+package rvm
+
+import (
+	"errors"
+	"math/big"
+	"strconv"
+)
+
+var (
+	errDivideByZero = errors.New("integer divide by zero")
+	errOverflow     = errors.New("integer overflow")
+)
+
+// FaultClass is a kind of arithmetic fault.
+type FaultClass int
+
+const (
+	// FaultDivideByZero is integer division or modulo by zero. Float division by zero is not a fault.
+	FaultDivideByZero FaultClass = iota
+	// FaultConversion is an operand that has no arithmetic or bitwise form, such as a nil or host value.
+	FaultConversion
+	// FaultOverflow is an integer add, sub, mul, or neg whose result does not fit in its Int or Uint type. Integer
+	// arithmetic wraps unless the thread's FaultPolicy sets an action for it.
+	FaultOverflow
+)
+
+func (c FaultClass) String() string {
+	switch c {
+	case FaultDivideByZero:
+		return "divide by zero"
+	case FaultConversion:
+		return "conversion"
+	case FaultOverflow:
+		return "overflow"
+	default:
+		return "FaultClass(" + strconv.Itoa(int(c)) + ")"
+	}
+}
+
+// Fault is the panic value raised by an arithmetic fault.
+type Fault struct {
+	Class FaultClass
+	Err   error
+}
+
+func (f *Fault) Error() string {
+	return f.Class.String() + " fault: " + f.Err.Error()
+}
+
+func (f *Fault) Unwrap() error {
+	return f.Err
+}
+
+// FaultAction is what a thread does when an instruction faults.
+type FaultAction int
+
+const (
+	// FaultDefault is the class's default action: FaultPanic for every class but FaultOverflow, which is not checked,
+	// so integer arithmetic wraps.
+	FaultDefault FaultAction = iota
+	// FaultPanic panics with the *Fault.
+	FaultPanic
+	// FaultNil stores nil in the faulting instruction's destination and continues with the next instruction. A vector
+	// instruction stores nil in every element of its output, including elements computed before the fault.
+	FaultNil
+	// FaultCatch stores nil in the faulting instruction's destination, as FaultNil does, and stores the fault's class
+	// plus one, as an Int, in the TLS slot FaultPolicy.CatchSlot. A script catches faults by loading and testing the
+	// slot after the instructions that may fault, and clearing it once handled. The slot is not cleared by the thread.
+	FaultCatch
+)
+
+// FaultPolicy sets the action a thread takes for each class of fault. Only arithmetic instructions (binary and vector
+// arithmetic, neg, not, and round) apply it; faults raised elsewhere, such as a test comparing a nil operand, always
+// panic. The zero FaultPolicy panics on every fault and does not check for overflow.
+type FaultPolicy struct {
+	DivideByZero FaultAction
+	Conversion   FaultAction
+	Overflow     FaultAction
+	// CatchSlot is the TLS slot that FaultCatch records faults in.
+	CatchSlot TLSIndex
+}
+
+func (p *FaultPolicy) action(class FaultClass) FaultAction {
+	var act FaultAction
+	switch class {
+	case FaultDivideByZero:
+		act = p.DivideByZero
+	case FaultConversion:
+		act = p.Conversion
+	case FaultOverflow:
+		act = p.Overflow
+	}
+	if act == FaultDefault {
+		return FaultPanic
+	}
+	return act
+}
+
+// SetFaultPolicy sets the thread's fault policy.
+func (th *Thread) SetFaultPolicy(policy FaultPolicy) {
+	if auditing {
+		th.audit.enter()
+		defer th.audit.exit()
+	}
+	th.faults = policy
+}
+
+// trapFault handles rc, a value recovered while executing instr, according to the thread's fault policy. It returns
+// false if rc is not a fault or the policy does not handle it, in which case the caller must re-raise it.
+func (th *Thread) trapFault(rc interface{}, instr Instruction) bool {
+	f, ok := rc.(*Fault)
+	if !ok {
+		return false
+	}
+	act := th.faults.action(f.Class)
+	if act != FaultNil && act != FaultCatch {
+		return false
+	}
+
+	switch instr.Opcode() {
+	case OpAdd, OpSub, OpDiv, OpMul, OpPow, OpMod, OpOr, OpAnd, OpXor, OpArithshift, OpBitshift, OpNeg, OpNot, OpRound:
+	default:
+		return false
+	}
+
+	dst, args, ok := instr.operands()
+	if !ok || dst == nil {
+		return false
+	}
+	n := 1
+	if instr.isVector() {
+		n = args[0].(int)
+	}
+	for _, ix := range indexRange(dst, n) {
+		ix.store(th, nil)
+	}
+	if act == FaultCatch {
+		th.faults.CatchSlot.store(th, Int(f.Class)+1)
+	}
+	return true
+}
+
+// checkOverflow panics with a FaultOverflow fault if result, the Int or Uint result of an add, sub, mul, or neg of lhs
+// and rhs, is not the exact result of the operation. rhs is ignored for neg. Results of other types and other
+// operations are not checked. It is only called when the thread's fault policy checks for overflow.
+func checkOverflow(op Opcode, lhs, rhs, result Value) {
+	switch op {
+	case OpAdd, OpSub, OpMul, OpNeg:
+	default:
+		return
+	}
+
+	var got, l, r, want big.Int
+	if !bigInt(&got, result) || !bigInt(&l, toarith(lhs)) || op != OpNeg && !bigInt(&r, toarith(rhs)) {
+		return
+	}
+	switch op {
+	case OpAdd:
+		want.Add(&l, &r)
+	case OpSub:
+		want.Sub(&l, &r)
+	case OpMul:
+		want.Mul(&l, &r)
+	case OpNeg:
+		want.Neg(&l)
+	}
+	if want.Cmp(&got) != 0 {
+		panic(&Fault{Class: FaultOverflow, Err: errOverflow})
+	}
+}
+
+// bigInt sets z to v if v is an Int or Uint, and returns false otherwise.
+func bigInt(z *big.Int, v Value) bool {
+	switch v := v.(type) {
+	case Int:
+		z.SetInt64(int64(v))
+	case Uint:
+		z.SetUint64(uint64(v))
+	default:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,146 @@
+package rvm
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestFaultPolicy(t *testing.T) {
+	code := codeTable(nil).
+		binaryOp(OpDiv, RegisterIndex(3), RegisterIndex(10), ImmediateIndex(0)).
+		binaryOp(OpMod, RegisterIndex(4), RegisterIndex(11), ImmediateIndex(0)).
+		binaryOp(OpAdd, RegisterIndex(5), RegisterIndex(12), ImmediateIndex(1)).
+		vector(OpDiv, 2, RegisterIndex(6), RegisterIndex(13), ConstIndex(0)).
+		binaryOp(OpDiv, RegisterIndex(8), RegisterIndex(10), ConstIndex(2)).
+		load(RegisterIndex(9), ImmediateIndex(9)).
+		v()
+	consts := []Value{Int(1), Int(0), Float(0)}
+	newThread := func(policy FaultPolicy) *Thread {
+		th := NewThread()
+		th.SetFaultPolicy(policy)
+		th.pushFrame(0, funcData{code: code, consts: consts})
+		for r := RegisterIndex(3); r <= 9; r++ {
+			r.store(th, Int(-1))
+		}
+		for r, v := range []Value{Int(7), Uint(7), "seven", Int(1), Int(1)} {
+			RegisterIndex(10+r).store(th, v)
+		}
+		return th
+	}
+
+	// The default policy panics on the first fault.
+	err := newThread(FaultPolicy{}).RunProtected()
+	var fault *Fault
+	if !errors.As(err.(*RuntimePanic).Err(), &fault) || fault.Class != FaultDivideByZero {
+		t.Fatalf("RunProtected() = %v; want divide by zero fault", err)
+	}
+
+	// Each class is handled separately.
+	err = newThread(FaultPolicy{DivideByZero: FaultNil}).RunProtected()
+	if !errors.As(err.(*RuntimePanic).Err(), &fault) || fault.Class != FaultConversion {
+		t.Fatalf("RunProtected() = %v; want conversion fault", err)
+	}
+
+	th := newThread(FaultPolicy{DivideByZero: FaultNil, Conversion: FaultNil})
+	testRunThread(t, th)
+	testThreadState(t, th, []threadStateTest{
+		{RegisterIndex(3), nil},
+		{RegisterIndex(4), nil},
+		{RegisterIndex(5), nil},
+		{RegisterIndex(6), nil}, // 1/1 computed before the fault, but cleared with the rest of the vector
+		{RegisterIndex(7), nil},
+		{RegisterIndex(8), Float(math.Inf(1))}, // Float division is not a fault
+		{RegisterIndex(9), Int(9)},
+	})
+
+	// RunN counts a trapped instruction as executed.
+	th = newThread(FaultPolicy{DivideByZero: FaultNil, Conversion: FaultNil})
+	if executed, done, err := th.RunN(3); executed != 3 || done || err != nil {
+		t.Errorf("RunN(3) = %d, %t, %v; want 3, false, nil", executed, done, err)
+	}
+	if executed, done, err := th.RunN(10); executed != 3 || !done || err != nil {
+		t.Errorf("RunN(10) = %d, %t, %v; want 3, true, nil", executed, done, err)
+	}
+}
+
+func TestFaultOverflow(t *testing.T) {
+	code := codeTable(nil).
+		binaryOp(OpAdd, RegisterIndex(3), RegisterIndex(10), ImmediateIndex(1)).
+		binaryOp(OpMul, RegisterIndex(4), RegisterIndex(11), ImmediateIndex(2)).
+		unaryOp(OpNeg, RegisterIndex(5), RegisterIndex(12)).
+		vector(OpSub, 2, RegisterIndex(6), RegisterIndex(13), ImmediateIndex(1)).
+		binaryOp(OpAdd, RegisterIndex(8), RegisterIndex(15), ImmediateIndex(1)).
+		v()
+	newThread := func(policy FaultPolicy) *Thread {
+		th := NewThread()
+		th.SetFaultPolicy(policy)
+		th.pushFrame(0, funcData{code: code})
+		for r, v := range []Value{Int(math.MaxInt64), Uint(math.MaxUint64), Int(math.MinInt64), Int(0), Uint(0), Float(1)} {
+			RegisterIndex(10+r).store(th, v)
+		}
+		return th
+	}
+
+	// By default, integer arithmetic wraps.
+	th := newThread(FaultPolicy{})
+	testRunThread(t, th)
+	testThreadState(t, th, []threadStateTest{
+		{RegisterIndex(3), Int(math.MinInt64)},
+		{RegisterIndex(4), Uint(math.MaxUint64 - 1)},
+		{RegisterIndex(5), Int(math.MinInt64)},
+		{RegisterIndex(6), Int(-1)},
+		{RegisterIndex(7), Uint(math.MaxUint64)},
+		{RegisterIndex(8), Float(2)},
+	})
+
+	err := newThread(FaultPolicy{Overflow: FaultPanic}).RunProtected()
+	var fault *Fault
+	if !errors.As(err.(*RuntimePanic).Err(), &fault) || fault.Class != FaultOverflow {
+		t.Fatalf("RunProtected() = %v; want overflow fault", err)
+	}
+
+	th = newThread(FaultPolicy{Overflow: FaultNil})
+	testRunThread(t, th)
+	testThreadState(t, th, []threadStateTest{
+		{RegisterIndex(3), nil},
+		{RegisterIndex(4), nil},
+		{RegisterIndex(5), nil},
+		{RegisterIndex(6), nil}, // 0-1 does not overflow an Int, but 0-1 does overflow the Uint in %14
+		{RegisterIndex(7), nil},
+		{RegisterIndex(8), Float(2)}, // Float arithmetic is not checked
+	})
+}
+
+func TestFaultCatch(t *testing.T) {
+	// The script clears the catch slot, divides, and runs its handler if the division faulted.
+	code := codeTable(nil).
+		load(RegisterIndex(6), ConstIndex(0)).
+		tls(true, 2, RegisterIndex(6)).
+		binaryOp(OpDiv, RegisterIndex(3), RegisterIndex(10), RegisterIndex(11)).
+		tls(false, 2, RegisterIndex(4)).
+		test(CmpEqual, true, RegisterIndex(4), ConstIndex(0)).
+		jump(1, nil).
+		load(RegisterIndex(5), ImmediateIndex(42)).
+		v()
+	run := func(divisor Value) *Thread {
+		th := NewThread()
+		th.SetFaultPolicy(FaultPolicy{DivideByZero: FaultCatch, CatchSlot: 2})
+		th.pushFrame(0, funcData{code: code, consts: []Value{Int(0)}})
+		RegisterIndex(10).store(th, Int(7))
+		RegisterIndex(11).store(th, divisor)
+		testRunThread(t, th)
+		return th
+	}
+
+	testThreadState(t, run(Int(0)), []threadStateTest{
+		{RegisterIndex(3), nil},
+		{RegisterIndex(4), Int(FaultDivideByZero) + 1},
+		{RegisterIndex(5), Int(42)},
+	})
+	testThreadState(t, run(Int(7)), []threadStateTest{
+		{RegisterIndex(3), Int(1)},
+		{RegisterIndex(4), Int(0)},
+		{RegisterIndex(5), nil},
+	})
+}
@@ -0,0 +1,137 @@
+// Package golden checks disassembly listings and execution traces against golden files. A golden file holds the
+// expected output of a test; when the output changes on purpose, run the tests with -golden.update to rewrite the
+// files, and review the change with the rest of the diff.
+//
+// Output is normalized before it is compared or written, so that values that change from run to run, such as
+// pointers, do not cause spurious failures.
+package golden
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"testing"
+
+	"go.spiff.io/rusalka/rvm"
+)
+
+var update = flag.Bool("golden.update", false, "rewrite golden files with the output of each test")
+
+// maxTraceSteps is the number of instructions Trace runs before it gives up on a function that does not return.
+const maxTraceSteps = 10000
+
+// pointerPattern matches a pointer as formatted by %#v, such as (*rvm.Lazy)(0xc000012340). Submatch 1 is the address.
+var pointerPattern = regexp.MustCompile(`\(\*[^()\s]+\)\((0x[0-9a-f]+)\)`)
+
+// Normalize returns b with run-dependent details replaced by stable placeholders. The address of each pointer value
+// formatted by %#v, such as (*rvm.Lazy)(0xc000012340), is replaced by ptrN, numbered in order of first appearance, so
+// output that refers to the same object twice still shows it. Other hexadecimal numbers, such as those written by
+// rvm.FormatOptions.Hex, are left alone, as are bare addresses written by %p, which cannot be told apart from them.
+func Normalize(b []byte) []byte {
+	seen := map[string]string{}
+	return pointerPattern.ReplaceAllFunc(b, func(m []byte) []byte {
+		sub := pointerPattern.FindSubmatchIndex(m)
+		addr := string(m[sub[2]:sub[3]])
+		name, ok := seen[addr]
+		if !ok {
+			name = "ptr" + strconv.Itoa(len(seen)+1)
+			seen[addr] = name
+		}
+		out := append([]byte{}, m[:sub[2]]...)
+		out = append(out, name...)
+		return append(out, m[sub[3]:]...)
+	})
+}
+
+// Check compares got, after normalizing it, with the contents of the golden file at path. Paths are relative to the
+// test's package directory; golden files are usually kept under testdata. If the -golden.update flag is set, Check
+// writes got to path instead, creating its directory if needed.
+func Check(t testing.TB, path string, got []byte) {
+	t.Helper()
+	got = Normalize(got)
+
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0o777); err != nil {
+			t.Fatalf("cannot create golden file directory: %v", err)
+		}
+		if err := os.WriteFile(path, got, 0o666); err != nil {
+			t.Fatalf("cannot write golden file: %v", err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("cannot read golden file (run with -golden.update to create it): %v", err)
+	}
+	if bytes.Equal(got, want) {
+		return
+	}
+
+	gotLines, wantLines := bytes.Split(got, []byte("\n")), bytes.Split(want, []byte("\n"))
+	line := 0
+	for line < len(gotLines) && line < len(wantLines) && bytes.Equal(gotLines[line], wantLines[line]) {
+		line++
+	}
+	t.Errorf("output differs from %s at line %d (run with -golden.update to accept it)\ngot:  %q\nwant: %q",
+		path, line+1, lineAt(gotLines, line), lineAt(wantLines, line))
+}
+
+func lineAt(lines [][]byte, i int) []byte {
+	if i < len(lines) {
+		return lines[i]
+	}
+	return nil
+}
+
+// Disassembly checks the disassembly of code, as written by rvm.Disassemble, against the golden file at path.
+func Disassembly(t testing.TB, path string, code []uint32, opts rvm.FormatOptions) {
+	t.Helper()
+	var b bytes.Buffer
+	if err := rvm.Disassemble(&b, code, opts); err != nil {
+		t.Fatalf("cannot disassemble code: %v", err)
+	}
+	Check(t, path, b.Bytes())
+}
+
+// Trace runs fn on a new thread, with args as its arguments, and checks a trace of its execution against the golden
+// file at path. The trace lists each instruction as it is executed, formatted with opts, followed by the registers,
+// stack slots, and TLS slots it changed (see rvm.Snapshot.Diff). If fn panics, the trace ends with the panic.
+func Trace(t testing.TB, path string, fn rvm.Function, opts rvm.FormatOptions, args ...rvm.Value) {
+	t.Helper()
+	var b bytes.Buffer
+	th := rvm.NewThread()
+	for _, v := range args {
+		th.Push(v)
+	}
+	th.Enter(fn, len(args))
+
+	for step := 0; ; step++ {
+		if step == maxTraceSteps {
+			t.Fatalf("function did not return after %d instructions", maxTraceSteps)
+		}
+
+		before := th.Inspect()
+		if instr, _, ok := rvm.DecodeInstruction(fn.Code[min(int(before.PC), len(fn.Code)):]); ok {
+			fmt.Fprintf(&b, "%-6d %s\n", before.PC, instr.Format(opts))
+		}
+
+		_, done, err := th.RunN(1)
+		for _, c := range before.Diff(th.Inspect()) {
+			if c.Index != rvm.RegPC {
+				fmt.Fprintf(&b, "       %v\n", c)
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(&b, "%v\n", err)
+		}
+		if done || err != nil {
+			break
+		}
+	}
+	Check(t, path, b.Bytes())
+}
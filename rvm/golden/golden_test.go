@@ -0,0 +1,101 @@
+package golden
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.spiff.io/rusalka/rvm"
+)
+
+func function(arity int, consts []rvm.Value, code ...rvm.Instruction) rvm.Function {
+	var words []uint32
+	for _, instr := range code {
+		words = instr.AppendTo(words)
+	}
+	return rvm.Function{Info: rvm.FuncInfo{Arity: arity}, Code: words, Consts: consts}
+}
+
+// must returns instr, panicking if err is not nil.
+func must(instr rvm.Instruction, err error) rvm.Instruction {
+	if err != nil {
+		panic(err)
+	}
+	return instr
+}
+
+// sumFunction sums its two arguments and counts %4 down to zero from the first.
+var sumFunction = function(2, []rvm.Value{rvm.Int(0)},
+	must(rvm.EncodeBinary(rvm.OpAdd, rvm.Reg(3), rvm.Stack(0), rvm.Stack(1))),
+	must(rvm.EncodeLoad(rvm.Reg(4), rvm.Stack(0))),
+	must(rvm.EncodePushPop(rvm.OpPush, 1, rvm.Reg(3))),
+	// loop:
+	must(rvm.EncodeBinary(rvm.OpSub, rvm.Reg(4), rvm.Reg(4), rvm.Imm(1))),
+	must(rvm.EncodeTest(rvm.CmpLess, true, rvm.Const(0), rvm.Reg(4))),
+	must(rvm.EncodeJump(-3, rvm.Operand{})),
+	must(rvm.EncodeTLSStore(0, rvm.Reg(3))),
+)
+
+func TestNormalize(t *testing.T) {
+	in := "(*rvm.Lazy)(0xc000012340) (*rvm.Lazy)(0xc000056780) (*rvm.Lazy)(0xc000012340) 0x1f const[0x2a] $0x123456"
+	want := "(*rvm.Lazy)(ptr1) (*rvm.Lazy)(ptr2) (*rvm.Lazy)(ptr1) 0x1f const[0x2a] $0x123456"
+	if got := string(Normalize([]byte(in))); got != want {
+		t.Errorf("Normalize(%q) = %q; want %q", in, got, want)
+	}
+}
+
+func TestDisassembly(t *testing.T) {
+	Disassembly(t, "testdata/sum.disasm", sumFunction.Code, rvm.FormatOptions{Raw: true, Consts: sumFunction.Consts})
+}
+
+func TestTrace(t *testing.T) {
+	Trace(t, "testdata/sum.trace", sumFunction, rvm.FormatOptions{}, rvm.Int(2), rvm.Int(5))
+	Trace(t, "testdata/panic.trace", function(0, nil, must(rvm.EncodeLoad(rvm.Reg(1), rvm.Imm(0)))), rvm.FormatOptions{})
+}
+
+// recorder is a testing.TB that records failures instead of reporting them.
+type recorder struct {
+	testing.TB
+	failed bool
+	msg    string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.failed = true
+	r.msg = strings.TrimSpace(strings.Join([]string{r.msg, format}, "\n"))
+}
+
+func (r *recorder) Fatalf(format string, args ...interface{}) {
+	r.Errorf(format, args...)
+}
+
+func TestCheckMismatch(t *testing.T) {
+	if *update {
+		t.Skip("not checking mismatches while updating golden files")
+	}
+
+	path := filepath.Join(t.TempDir(), "out.golden")
+	if err := os.WriteFile(path, []byte("one\ntwo\n"), 0o666); err != nil {
+		t.Fatal(err)
+	}
+
+	r := &recorder{TB: t}
+	Check(r, path, []byte("one\ntwo\n"))
+	if r.failed {
+		t.Errorf("Check of matching output failed: %s", r.msg)
+	}
+
+	Check(r, path, []byte("one\n2\n"))
+	if !r.failed {
+		t.Error("Check of differing output passed")
+	}
+
+	r = &recorder{TB: t}
+	Check(r, filepath.Join(t.TempDir(), "missing.golden"), nil)
+	if !r.failed {
+		t.Error("Check with a missing golden file passed")
+	}
+}
@@ -0,0 +1,173 @@
+package rvm
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+)
+
+// HeapRecord is a record written by DumpHeap. A record with a Root describes a root; any other record describes an
+// object. Every object is written once, before any record that refers to it unless both are part of a cycle.
+type HeapRecord struct {
+	// Root names the thread storage holding a reference to object ID, such as "stack[3]", "%20", or "frame[1].const[0]".
+	// It is empty for object records.
+	Root string `json:"root,omitempty"`
+	// ID identifies the object. IDs are only meaningful within a single dump.
+	ID int `json:"id"`
+	// Type is the object's Go type.
+	Type string `json:"type,omitempty"`
+	// Size is an estimate of the object's size in bytes, not counting the objects it refers to. It includes the
+	// backing array of a slice, the contents of strings held directly by the object, and the entries of a map.
+	Size uintptr `json:"size,omitempty"`
+	// Refs lists the IDs of the objects this object refers to.
+	Refs []int `json:"refs,omitempty"`
+}
+
+// DumpHeap writes every Value reachable from the thread to w as a stream of JSON-encoded HeapRecords, one per line. The
+// roots are the thread's stack, registers, TLS slots, and host locals (see SetLocal), and the call registers and
+// constants of every frame. Values are followed through pointers, slices, maps, interfaces, and struct fields, using
+// reflection, so the dump includes host values and everything they refer to. Objects with the same address are written
+// once.
+//
+// DumpHeap is meant for offline analysis of what a long-running thread is holding on to. The thread must not be running;
+// use Pause to stop it first if it is.
+func (th *Thread) DumpHeap(w io.Writer) error {
+	if auditing {
+		th.audit.enter()
+		defer th.audit.exit()
+	}
+
+	d := &heapDumper{th: th, enc: json.NewEncoder(w), seen: map[heapKey]int{}}
+	values := func(prefix string, vs []Value) {
+		for i, v := range vs {
+			d.root(prefix+"["+strconv.Itoa(i)+"]", v)
+		}
+	}
+
+	values("stack", th.stack)
+	for i, v := range th.local {
+		d.root(RegisterIndex(specialRegisters+i).String(), v)
+	}
+	for i, v := range th.reg {
+		d.root(RegisterIndex(specialRegisters+callRegisters+i).String(), v)
+	}
+	values("tls", th.tls)
+	for k, v := range th.hostLocals {
+		d.root(fmt.Sprintf("local[%#v]", k), v)
+	}
+	values("const", th.consts)
+	for depth := 1; depth <= len(th.frames); depth++ {
+		frame, prefix := &th.frames[len(th.frames)-depth], "frame["+strconv.Itoa(depth)+"]."
+		for i, v := range th.savedLocals(depth) {
+			d.root(prefix+RegisterIndex(specialRegisters+i).String(), v)
+		}
+		values(prefix+"const", frame.consts)
+	}
+	return d.err
+}
+
+// heapKey identifies an object with an address.
+type heapKey struct {
+	addr uintptr
+	typ  reflect.Type
+	len  int // Length of a slice, since slices of one array may differ
+}
+
+type heapDumper struct {
+	th   *Thread
+	enc  *json.Encoder
+	seen map[heapKey]int
+	next int
+	err  error
+}
+
+func (d *heapDumper) write(rec *HeapRecord) {
+	if d.err == nil {
+		d.err = d.enc.Encode(rec)
+	}
+}
+
+// root writes a root record for v and the objects reachable from it. Nil values are skipped.
+func (d *heapDumper) root(name string, v interface{}) {
+	if v == nil || d.err != nil {
+		return
+	}
+	d.write(&HeapRecord{Root: name, ID: d.object(reflect.ValueOf(v))})
+}
+
+// object writes v, a value held in an interface or referred to by a pointer, slice, or map, and the objects reachable
+// from it. It returns v's ID.
+func (d *heapDumper) object(v reflect.Value) int {
+	var key heapKey
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Chan, reflect.Func, reflect.UnsafePointer:
+		key = heapKey{addr: v.Pointer(), typ: v.Type()}
+	case reflect.Slice:
+		key = heapKey{addr: v.Pointer(), typ: v.Type(), len: v.Len()}
+	}
+	if key.typ != nil {
+		if id, ok := d.seen[key]; ok {
+			return id
+		}
+	}
+
+	d.next++
+	id := d.next
+	if key.typ != nil {
+		d.seen[key] = id
+	}
+
+	rec := &HeapRecord{ID: id, Type: v.Type().String()}
+	switch v.Kind() {
+	case reflect.Ptr:
+		// The thread itself is reachable through stack slices; its contents are already covered by the roots.
+		if v.Pointer() != reflect.ValueOf(d.th).Pointer() {
+			rec.Size = v.Type().Elem().Size()
+			d.refs(v.Elem(), rec)
+		}
+	case reflect.Slice:
+		rec.Size = uintptr(v.Cap()) * v.Type().Elem().Size()
+		for i := 0; i < v.Len(); i++ {
+			d.refs(v.Index(i), rec)
+		}
+	case reflect.Map:
+		rec.Size = uintptr(v.Len()) * (v.Type().Key().Size() + v.Type().Elem().Size())
+		for it := v.MapRange(); it.Next(); {
+			d.refs(it.Key(), rec)
+			d.refs(it.Value(), rec)
+		}
+	case reflect.Chan, reflect.Func, reflect.UnsafePointer:
+	default:
+		rec.Size = v.Type().Size()
+		d.refs(v, rec)
+	}
+	d.write(rec)
+	return id
+}
+
+// refs appends the IDs of the objects referred to by v, a value stored inline in rec's object, to rec.Refs, and adds
+// the contents of strings in v to rec's size.
+func (d *heapDumper) refs(v reflect.Value, rec *HeapRecord) {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Map, reflect.Chan, reflect.Func, reflect.UnsafePointer:
+		if !v.IsNil() {
+			rec.Refs = append(rec.Refs, d.object(v))
+		}
+	case reflect.Interface:
+		if !v.IsNil() {
+			rec.Refs = append(rec.Refs, d.object(v.Elem()))
+		}
+	case reflect.String:
+		rec.Size += uintptr(v.Len())
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			d.refs(v.Field(i), rec)
+		}
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			d.refs(v.Index(i), rec)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package rvm
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"testing"
+	"unsafe"
+)
+
+func TestDumpHeap(t *testing.T) {
+	type node struct {
+		Name string
+		Next *node
+	}
+
+	shared := &node{Name: "shared"}
+	shared.Next = shared // Cycles are written once
+
+	th := NewThread()
+	th.Push(shared)
+	th.Push(Int(1))
+	th.Push([]Value{shared, nil})
+	RegisterIndex(20).store(th, shared)
+	TLSIndex(0).store(th, "tls")
+	th.SetLocal("key", shared)
+
+	var buf bytes.Buffer
+	if err := th.DumpHeap(&buf); err != nil {
+		t.Fatalf("DumpHeap() = %v", err)
+	}
+
+	objects := map[int]HeapRecord{}
+	roots := map[string]int{}
+	dec := json.NewDecoder(&buf)
+	for {
+		var rec HeapRecord
+		if err := dec.Decode(&rec); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("Decode() = %v", err)
+		}
+
+		for _, ref := range rec.Refs {
+			if _, ok := objects[ref]; !ok && ref != rec.ID {
+				t.Errorf("record %+v refers to %d before it is written", rec, ref)
+			}
+		}
+		if rec.Root != "" {
+			roots[rec.Root] = rec.ID
+		} else if _, dup := objects[rec.ID]; dup {
+			t.Errorf("object %d written twice", rec.ID)
+		} else {
+			objects[rec.ID] = rec
+		}
+	}
+
+	id := roots["stack[0]"]
+	for _, name := range []string{"%20", `local["key"]`} {
+		if roots[name] != id {
+			t.Errorf("root %s = %d; want %d", name, roots[name], id)
+		}
+	}
+	if obj := objects[id]; obj.Type != "*rvm.node" || len(obj.Refs) != 1 || obj.Refs[0] != id {
+		t.Errorf("shared object = %+v; want *rvm.node referring to itself", obj)
+	} else if want := unsafe.Sizeof(node{}) + uintptr(len("shared")); obj.Size != want {
+		t.Errorf("shared object size = %d; want %d", obj.Size, want)
+	}
+
+	slice := objects[roots["stack[2]"]]
+	if slice.Type != "[]rvm.Value" || len(slice.Refs) != 1 || slice.Refs[0] != id {
+		t.Errorf("slice object = %+v; want one ref to %d", slice, id)
+	}
+	if tls := objects[roots["tls[0]"]]; tls.Type != "string" || tls.Size != unsafe.Sizeof("")+3 {
+		t.Errorf("tls object = %+v; want string of size %d", tls, unsafe.Sizeof("")+3)
+	}
+	if _, ok := roots["stack[1]"]; !ok {
+		t.Error("no root for stack[1]")
+	}
+}
@@ -0,0 +1,114 @@
+package rvm
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Snapshot is a copy of a thread's state, taken by Inspect. It shares no memory with the thread, so it may be read and
+// modified freely while the thread keeps running. Values themselves are not copied: a Value that is a pointer still
+// refers to the same object as the thread's copy.
+type Snapshot struct {
+	// PC is the index of the next instruction the current frame will execute.
+	PC int64
+	// EBP is the absolute stack index of the current frame's ebp.
+	EBP int
+	// Registers holds every register as read by the current frame: %pc, %ebp, and %esp as Ints, then the call and
+	// volatile registers. Call registers outside of the current function's window are nil.
+	Registers [registerCount]Value
+	// Stack is the entire stack, including the values of every frame.
+	Stack []Value
+	// Frames is the thread's backtrace, innermost first. See Backtrace.
+	Frames []Frame
+	// TLS holds the thread's TLS slots. It is only as long as the highest slot stored to.
+	TLS []Value
+}
+
+// Inspect returns a snapshot of the thread's registers, stack, pc, and frames. It may be called from any goroutine
+// while the thread is running: if it is, Inspect pauses the thread at its next safe point, as Pause does, and resumes it
+// once the snapshot is taken. Like Pause, it must not be called by the goroutine running the thread.
+func (th *Thread) Inspect() *Snapshot {
+	th.Pause()
+	defer th.Resume()
+	if auditing {
+		th.audit.enter()
+		defer th.audit.exit()
+	}
+
+	snap := &Snapshot{
+		PC:     th.pc,
+		EBP:    th.ebp,
+		Stack:  append([]Value(nil), th.stack...),
+		Frames: th.Backtrace(),
+		TLS:    append([]Value(nil), th.tls...),
+	}
+	snap.Registers[RegPC] = Int(th.pc)
+	snap.Registers[RegEBP] = Int(th.ebp)
+	snap.Registers[RegESP] = Int(len(th.stack))
+	copy(snap.Registers[specialRegisters:], th.local[:th.localRegisters()])
+	copy(snap.Registers[specialRegisters+callRegisters:], th.reg[:])
+	return snap
+}
+
+// Change is a difference between two snapshots, as reported by Diff. Old or New is nil if the index exists in only one
+// of them, such as a stack slot that was pushed or popped.
+type Change struct {
+	// Index is the register, stack slot, or TLS slot that changed. Stack slots are absolute stack indices.
+	Index    Index
+	Old, New Value
+}
+
+func (c Change) String() string {
+	return fmt.Sprintf("%v: %#v -> %#v", c.Index, c.Old, c.New)
+}
+
+// Diff returns the registers, stack slots, and TLS slots whose values differ between s and other, in that order. The
+// pc and stack size are reported as changes to %pc and %esp. Values are compared with == where their types allow it,
+// and with reflect.DeepEqual otherwise.
+func (s *Snapshot) Diff(other *Snapshot) []Change {
+	var changes []Change
+	for i := range s.Registers {
+		if old, new := s.Registers[i], other.Registers[i]; !valuesEqual(old, new) {
+			changes = append(changes, Change{RegisterIndex(i), old, new})
+		}
+	}
+	changes = diffValues(changes, s.Stack, other.Stack, true, func(i int) Index { return StackIndex(i) })
+	changes = diffValues(changes, s.TLS, other.TLS, false, func(i int) Index { return TLSIndex(i) })
+	return changes
+}
+
+// diffValues appends the changes between old and new to changes. If sized is false, missing values are treated as nil,
+// as they are for TLS slots.
+func diffValues(changes []Change, old, new []Value, sized bool, index func(int) Index) []Change {
+	at := func(vs []Value, i int) Value {
+		if i < len(vs) {
+			return vs[i]
+		}
+		return nil
+	}
+
+	n := len(old)
+	if len(new) > n {
+		n = len(new)
+	}
+	for i := 0; i < n; i++ {
+		o, v := at(old, i), at(new, i)
+		if sized && (i >= len(old) || i >= len(new)) || !valuesEqual(o, v) {
+			changes = append(changes, Change{index(i), o, v})
+		}
+	}
+	return changes
+}
+
+// valuesEqual returns whether a and b are equal, without panicking on values of incomparable types.
+func valuesEqual(a, b Value) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if t := reflect.TypeOf(a); t != reflect.TypeOf(b) {
+		return false
+	} else if !t.Comparable() {
+		return reflect.DeepEqual(a, b)
+	}
+	return a == b
+}
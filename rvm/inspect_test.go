@@ -0,0 +1,94 @@
+package rvm
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestInspect(t *testing.T) {
+	const loops = 100000
+
+	th := NewThread()
+	th.Push(Int(1))
+	th.pushFrame(0, funcData{
+		code: codeTable(nil).
+			load(RegisterIndex(3), ImmediateIndex(0)).
+			binaryOp(OpAdd, RegisterIndex(3), RegisterIndex(3), ImmediateIndex(1)).
+			test(CmpLess, true, RegisterIndex(3), ConstIndex(0)).
+			jump(-3, nil).
+			v(),
+		consts: []Value{Int(loops)},
+		info:   FuncInfo{Name: "count", Registers: 1},
+	})
+	RegisterIndex(19).store(th, Int(19))
+	TLSIndex(1).store(th, Int(-1))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		th.Run()
+	}()
+
+	// Snapshots taken while running are consistent and independent of the thread.
+	for i := 0; i < 10; i++ {
+		snap := th.Inspect()
+		if v := snap.Registers[3]; v == nil {
+			continue // Not yet started
+		} else if n, ok := v.(Int); !ok || n < 0 || n > loops {
+			t.Fatalf("snapshot %%3 = %v; want 0..%d", snap.Registers[3], loops)
+		}
+		snap.Stack[0] = Int(-1)
+	}
+	<-done
+
+	snap := th.Inspect()
+	if snap.PC != 4 || snap.EBP != 1 || snap.Registers[RegPC] != Int(4) || snap.Registers[RegESP] != Int(1) {
+		t.Errorf("snapshot pc = %d, ebp = %d, registers = %v; want pc 4, ebp 1, esp 1", snap.PC, snap.EBP,
+			snap.Registers[:specialRegisters])
+	}
+	if snap.Registers[3] != Int(loops) || snap.Registers[4] != nil || snap.Registers[19] != Int(19) {
+		t.Errorf("snapshot %%3, %%4, %%19 = %v, %v, %v; want %d, nil, 19", snap.Registers[3], snap.Registers[4],
+			snap.Registers[19], loops)
+	}
+	if len(snap.Stack) != 1 || snap.Stack[0] != Int(1) {
+		t.Errorf("snapshot stack = %v; want [1]", snap.Stack)
+	}
+	if len(snap.Frames) != 2 || snap.Frames[0].Func.Name != "count" {
+		t.Errorf("snapshot frames = %v; want count and the root frame", snap.Frames)
+	}
+	if len(snap.TLS) != 2 || snap.TLS[1] != Int(-1) {
+		t.Errorf("snapshot TLS = %v; want [<nil> -1]", snap.TLS)
+	}
+}
+
+func TestSnapshotDiff(t *testing.T) {
+	th := NewThread()
+	th.Push(Int(1))
+	th.Push([]Value{Int(2)}) // Incomparable
+	th.pushFrame(0, funcData{
+		code: codeTable(nil).
+			load(RegisterIndex(4), ImmediateIndex(4)).
+			push(1, ImmediateIndex(3)).
+			tls(true, 2, RegisterIndex(4)).
+			v(),
+	})
+	TLSIndex(0).store(th, nil)
+
+	before := th.Inspect()
+	if changes := before.Diff(th.Inspect()); len(changes) != 0 {
+		t.Errorf("Diff of unchanged thread = %v; want none", changes)
+	}
+
+	th.Run()
+	want := []Change{
+		{RegPC, Int(0), Int(3)},
+		{RegESP, Int(2), Int(3)},
+		{RegisterIndex(4), nil, Int(4)},
+		{StackIndex(2), nil, Int(3)},
+		{TLSIndex(2), nil, Int(4)},
+	}
+	got := before.Diff(th.Inspect())
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Diff = %v; want %v", got, want)
+	}
+}
@@ -1,6 +1,10 @@
 package rvm
 
-import "fmt"
+import (
+	"fmt"
+
+	"go.spiff.io/rusalka/rvm/bitfield"
+)
 
 type codeTable []uint32
 
@@ -8,7 +12,34 @@ func (c codeTable) binaryOp(op Opcode, out, argA, argB Index) codeTable {
 	return append(c, mkBinaryInstr(op, out, argA, argB))
 }
 
-func (c codeTable) test(op compareOp, want bool, lhs, rhs Index) codeTable {
+func (c codeTable) vector(op Opcode, n int, out, argA, argB Index) codeTable {
+	i := mkVectorInstr(op, n, out, argA, argB)
+	return append(c, uint32(i), uint32(i>>32))
+}
+
+func (c codeTable) unaryOp(op Opcode, out, arg Index) codeTable {
+	return append(c, mkUnaryInstr(op, out, arg))
+}
+
+func (c codeTable) round(out, arg Index, mode RoundingMode) codeTable {
+	return append(c, mkRoundInstr(out, arg, mode))
+}
+
+func (c codeTable) size(op Opcode, arg Index) codeTable {
+	return append(c, mkSizeInstr(op, arg))
+}
+
+func (c codeTable) wide(op Opcode, out, argA, argB Index) codeTable {
+	i := mkWideInstr(op, out, argA, argB)
+	return append(c, uint32(i), uint32(i>>32))
+}
+
+func (c codeTable) xtest(op CompareOp, want bool, lhs, rhs Index) codeTable {
+	i := mkXtestInstr(op, want, lhs, rhs)
+	return append(c, uint32(i), uint32(i>>32))
+}
+
+func (c codeTable) test(op CompareOp, want bool, lhs, rhs Index) codeTable {
 	return append(c, mkTestInstr(op, want, lhs, rhs))
 }
 
@@ -21,6 +52,15 @@ func (c codeTable) xload(dst, src Index) codeTable {
 	return append(c, uint32(i), uint32(i>>32))
 }
 
+func (c codeTable) tls(store bool, slot int, arg Index) codeTable {
+	return append(c, mkTLSInstr(store, slot, arg))
+}
+
+func (c codeTable) frame(store bool, fx FrameIndex, arg Index) codeTable {
+	i := mkFrameInstr(store, fx, arg)
+	return append(c, uint32(i), uint32(i>>32))
+}
+
 func (c codeTable) jump(offset int, src Index) codeTable {
 	return append(c, mkJumpInstr(offset, src))
 }
@@ -33,292 +73,764 @@ func (c codeTable) pop(sz int, dst Index) codeTable {
 	return append(c, mkPushPop(OpPop, sz, dst))
 }
 
+func (c codeTable) xpush(list ConstIndex) codeTable {
+	i := mkXpushInstr(list)
+	return append(c, uint32(i), uint32(i>>32))
+}
+
+func (c codeTable) dup(sz, depth int) codeTable {
+	return append(c, mkStackOp(OpDup, sz, depth))
+}
+
+func (c codeTable) rotate(sz, shift int) codeTable {
+	return append(c, mkStackOp(OpRotate, sz, shift))
+}
+
+func (c codeTable) drop(sz int) codeTable {
+	return append(c, mkPushPop(OpPop, sz, nil))
+}
+
 func (c codeTable) v() []uint32 {
 	return []uint32(c)
 }
 
-func mkLoadInstr(dst, src Index) (instr uint32) {
+// The mk* functions encode instructions from Index operands and panic on invalid operands. They wrap the encode*
+// functions, which take Operands and return errors.
+
+func mkLoadInstr(dst, src Index) uint32 {
+	return mustEncode32(encodeLoad(mustOperand(dst), mustOperand(src)))
+}
+
+func mkTLSInstr(store bool, slot int, arg Index) uint32 {
+	return mustEncode32(encodeTLS(store, slot, mustOperand(arg)))
+}
+
+func mkFrameInstr(store bool, fx FrameIndex, arg Index) uint64 {
+	return mustEncode64(encodeFrame(store, fx, mustOperand(arg)))
+}
+
+func mkJumpInstr(offset int, src Index) uint32 {
+	return mustEncode32(encodeJump(offset, mustOperand(src)))
+}
+
+func mkXloadInstr(dst, src Index) uint64 {
+	return mustEncode64(encodeXload(mustOperand(dst), mustOperand(src)))
+}
+
+func mkBinaryInstr(op Opcode, out, argA, argB Index) uint32 {
+	return mustEncode32(encodeBinary(op, mustOperand(out), mustOperand(argA), mustOperand(argB)))
+}
+
+func mkWideInstr(op Opcode, out, argA, argB Index) uint64 {
+	return mustEncode64(encodeWide(op, mustOperand(out), mustOperand(argA), mustOperand(argB)))
+}
+
+func mkXtestInstr(oper CompareOp, want bool, argA, argB Index) uint64 {
+	return mustEncode64(encodeXtest(oper, want, mustOperand(argA), mustOperand(argB)))
+}
+
+func mkVectorInstr(op Opcode, n int, out, argA, argB Index) uint64 {
+	return mustEncode64(encodeVector(op, n, mustOperand(out), mustOperand(argA), mustOperand(argB)))
+}
+
+func mkUnaryInstr(op Opcode, out, arg Index) uint32 {
+	return mustEncode32(encodeUnary(op, mustOperand(out), mustOperand(arg)))
+}
+
+func mkRoundInstr(out, arg Index, mode RoundingMode) uint32 {
+	return mustEncode32(encodeRound(mustOperand(out), mustOperand(arg), mode))
+}
+
+func mkSizeInstr(op Opcode, arg Index) uint32 {
+	return mustEncode32(encodeSize(op, mustOperand(arg)))
+}
+
+func mkTestInstr(oper CompareOp, want bool, argA, argB Index) uint32 {
+	return mustEncode32(encodeTest(oper, want, mustOperand(argA), mustOperand(argB)))
+}
+
+func mkPushPop(op Opcode, oprange int, arg Index) uint32 {
+	return mustEncode32(encodePushPop(op, oprange, mustOperand(arg)))
+}
+
+func mkXpushInstr(list ConstIndex) uint64 {
+	return mustEncode64(encodeXpush(Const(int(list))))
+}
+
+func mkStackOp(op Opcode, oprange int, arg int) uint32 {
+	return mustEncode32(encodeStackOp(op, oprange, arg))
+}
+
+// The new* functions build Instructions from Index operands with the exported Encode* functions, panicking on invalid
+// operands, for tests and tables written in terms of runtime indices.
+
+func newBinary(op Opcode, out, argA, argB Index) Instruction {
+	return mustInstr(EncodeBinary(op, mustOperand(out), mustOperand(argA), mustOperand(argB)))
+}
+
+func newVector(op Opcode, n int, out, argA, argB Index) Instruction {
+	return mustInstr(EncodeVector(op, n, mustOperand(out), mustOperand(argA), mustOperand(argB)))
+}
+
+func newWideBinary(op Opcode, out, argA, argB Index) Instruction {
+	return mustInstr(EncodeWideBinary(op, mustOperand(out), mustOperand(argA), mustOperand(argB)))
+}
+
+func newUnary(op Opcode, out, arg Index) Instruction {
+	return mustInstr(EncodeUnary(op, mustOperand(out), mustOperand(arg)))
+}
+
+func newRound(out, arg Index, mode RoundingMode) Instruction {
+	return mustInstr(EncodeRound(mustOperand(out), mustOperand(arg), mode))
+}
+
+func newReserve(size Index) Instruction {
+	return mustInstr(EncodeReserve(mustOperand(size)))
+}
+
+func newAlloc(n Index) Instruction {
+	return mustInstr(EncodeAlloc(mustOperand(n)))
+}
+
+func newFrameAdjust(delta Index) Instruction {
+	return mustInstr(EncodeFrameAdjust(mustOperand(delta)))
+}
+
+func newLoad(dst, src Index) Instruction {
+	return mustInstr(EncodeLoad(mustOperand(dst), mustOperand(src)))
+}
+
+func newXload(dst, src Index) Instruction {
+	return mustInstr(EncodeXload(mustOperand(dst), mustOperand(src)))
+}
+
+func newTLSLoad(dst Index, slot int) Instruction {
+	return mustInstr(EncodeTLSLoad(mustOperand(dst), slot))
+}
+
+func newTLSStore(slot int, src Index) Instruction {
+	return mustInstr(EncodeTLSStore(slot, mustOperand(src)))
+}
+
+func newFrameLoad(dst Index, fx FrameIndex) Instruction {
+	return mustInstr(EncodeFrameLoad(mustOperand(dst), fx))
+}
+
+func newFrameStore(fx FrameIndex, src Index) Instruction {
+	return mustInstr(EncodeFrameStore(fx, mustOperand(src)))
+}
+
+func newJump(offset int, src Index) Instruction {
+	return mustInstr(EncodeJump(offset, mustOperand(src)))
+}
+
+func newTest(op CompareOp, want bool, lhs, rhs Index) Instruction {
+	return mustInstr(EncodeTest(op, want, mustOperand(lhs), mustOperand(rhs)))
+}
+
+func newXtest(op CompareOp, want bool, lhs, rhs Index) Instruction {
+	return mustInstr(EncodeXtest(op, want, mustOperand(lhs), mustOperand(rhs)))
+}
+
+func newPushPop(op Opcode, n int, arg Index) Instruction {
+	return mustInstr(EncodePushPop(op, n, mustOperand(arg)))
+}
+
+func newXpush(list ConstIndex) Instruction {
+	return mustInstr(EncodeXpush(Const(int(list))))
+}
+
+func newStackOp(op Opcode, n, arg int) Instruction {
+	return mustInstr(EncodeStackOp(op, n, arg))
+}
+
+func mustInstr(instr Instruction, err error) Instruction {
+	if err != nil {
+		panic(err)
+	}
+	return instr
+}
+
+func mustEncode32(instr uint32, err error) uint32 {
+	if err != nil {
+		panic(err)
+	}
+	return instr
+}
+
+func mustEncode64(instr uint64, err error) uint64 {
+	if err != nil {
+		panic(err)
+	}
+	return instr
+}
+
+func encodeLoad(dst, src Operand) (instr uint32, err error) {
 	instr = opcodeBits(OpLoad)
 
-	switch dst := dst.(type) {
-	case RegisterIndex:
-		instr |= registerOp(dst, opLoadDstOff)
-	case StackIndex:
-		if !canStore(int64(dst), opLoadDstLen) {
-			panic(InvalidRegister(dst))
+	switch dst.Kind {
+	case OperandReg:
+		bits, err := registerBits(dst, opLoadDstOff)
+		if err != nil {
+			return 0, err
+		}
+		instr |= bits
+	case OperandStack:
+		if err := checkStackOperand(dst, LoadDstStackRange); err != nil {
+			return 0, err
 		}
-		instr |= signedBits32(int32(dst), opLoadDstOff, opLoadDstLen) | uint32(opLoadDstStack)
+		instr |= bitfield.Signed32(int32(dst.Value), opLoadDstOff, opLoadDstLen) | uint32(opLoadDstStack)
 	default:
-		panic(fmt.Errorf("invalid index type %T; must be register or stack", dst))
+		return 0, operandKindError("load dst", dst, "register or stack")
 	}
 
-	switch src := src.(type) {
-	case RegisterIndex:
-		instr |= registerOp(src, opLoadSrcLen)
-	case constIndex:
-		if !canStoreUnsigned(uint64(src), opLoadSrcLen) {
-			panic(InvalidConstIndex(src))
+	switch src.Kind {
+	case OperandReg:
+		bits, err := registerBits(src, opLoadSrcOff)
+		if err != nil {
+			return 0, err
 		}
-		instr |= unsignedBits32(uint32(src), opLoadSrcOff, opLoadSrcLen) | uint32(opLoadSrcConst)
-	case StackIndex:
-		if !canStore(int64(src), opLoadSrcLen) {
-			panic(InvalidStackIndex(src))
+		instr |= bits
+	case OperandConst:
+		if err := checkConstOperand(src, LoadSrcConstRange); err != nil {
+			return 0, err
 		}
-		instr |= signedBits32(int32(src), opLoadSrcOff, opLoadSrcLen) | uint32(opLoadSrcStack)
+		instr |= bitfield.Unsigned32(uint32(src.Value), opLoadSrcOff, opLoadSrcLen) | uint32(opLoadSrcConst)
+	case OperandStack:
+		if err := checkStackOperand(src, LoadSrcStackRange); err != nil {
+			return 0, err
+		}
+		instr |= bitfield.Signed32(int32(src.Value), opLoadSrcOff, opLoadSrcLen) | uint32(opLoadSrcStack)
+	case OperandImmediate:
+		if !LoadSrcImmRange.Contains(src.Value) {
+			return 0, fmt.Errorf("immediate outside range %v: %d", LoadSrcImmRange, src.Value)
+		}
+		instr |= bitfield.Signed32(int32(src.Value), opLoadSrcOff, opLoadSrcLen) | uint32(opLoadSrcImm)
 	default:
-		panic(fmt.Errorf("invalid index type %T; must be register, stack, or const", src))
+		return 0, operandKindError("load src", src, "register, stack, const, or immediate")
 	}
 
-	return instr
+	return instr, nil
 }
 
-func mkJumpInstr(offset int, src Index) (instr uint32) {
-	if src != nil && offset != 0 {
-		panic(fmt.Errorf("may not define an index (%v) and an offset (%d)", src, offset))
+func encodeJump(offset int, src Operand) (instr uint32, err error) {
+	if src.Kind != OperandNone && offset != 0 {
+		return 0, fmt.Errorf("may not define an index (%v) and an offset (%d)", src, offset)
 	}
 
 	instr = opcodeBits(OpJump)
 
-	if src == nil {
-		if !canStore(int64(offset), opJumpLitLen) {
-			panic(fmt.Errorf("offset exceeds 25-bit range: %d", offset))
+	switch src.Kind {
+	case OperandNone:
+		if !JumpOffsetRange.Contains(int64(offset)) {
+			return 0, fmt.Errorf("jump offset outside range %v: %d", JumpOffsetRange, offset)
 		}
-		return instr | signedBits32(int32(offset), opJumpLitOff, opJumpLitLen) | uint32(opJumpLiteral)
-	}
-
-	switch src := src.(type) {
-	case RegisterIndex:
-		instr |= registerOp(src, opJumpRelOff)
-	case constIndex:
-		if !canStoreUnsigned(uint64(src), opJumpRelLen) {
-			panic(InvalidConstIndex(src))
+		instr |= bitfield.Signed32(int32(offset), opJumpLitOff, opJumpLitLen) | uint32(opJumpLiteral)
+	case OperandReg:
+		bits, err := registerBits(src, opJumpRelOff)
+		if err != nil {
+			return 0, err
 		}
-		instr |= unsignedBits32(uint32(src), opJumpRelOff, opJumpRelLen) | uint32(opJumpConst)
-	case StackIndex:
-		if !canStore(int64(src), opJumpStackLen) {
-			panic(InvalidStackIndex(src))
+		instr |= bits
+	case OperandConst:
+		if err := checkConstOperand(src, JumpConstRange); err != nil {
+			return 0, err
 		}
-		instr |= signedBits32(int32(src), opJumpStackOff, opJumpStackLen) | uint32(opJumpStack)
+		instr |= bitfield.Unsigned32(uint32(src.Value), opJumpRelOff, opJumpRelLen) | uint32(opJumpConst)
+	case OperandStack:
+		if err := checkStackOperand(src, JumpStackRange); err != nil {
+			return 0, err
+		}
+		instr |= bitfield.Signed32(int32(src.Value), opJumpStackOff, opJumpStackLen) | uint32(opJumpStack)
 	default:
-		panic(fmt.Errorf("invalid index type %T; must be register, stack, or const", src))
+		return 0, operandKindError("jump", src, "register, stack, or const")
 	}
 
-	return instr
+	return instr, nil
 }
 
-func mkXloadInstr(dst, src Index) (instr uint64) {
+func encodeXload(dst, src Operand) (instr uint64, err error) {
 	instr = uint64(instrExtendedBit) |
 		xopcodeBits(OpLoad)
 
-	switch dst := dst.(type) {
-	case RegisterIndex:
-		instr |= xregisterOp(dst, opXloadDstOff)
-	case StackIndex:
-		if !canStore(int64(dst), opXloadDstLen) {
-			panic(InvalidRegister(dst))
+	switch dst.Kind {
+	case OperandReg:
+		bits, err := registerBits(dst, opXloadDstOff)
+		if err != nil {
+			return 0, err
 		}
-		instr |= signedBits64(int64(dst), opXloadDstOff, opXloadDstLen) | uint64(opXloadDstStack)
+		instr |= uint64(bits)
+	case OperandRelReg:
+		bits, err := relRegisterBits(dst)
+		if err != nil {
+			return 0, err
+		}
+		instr |= bits << opXloadDstOff
+	case OperandStack:
+		if err := checkStackOperand(dst, XloadDstStackRange); err != nil {
+			return 0, err
+		}
+		instr |= bitfield.Signed64(dst.Value, opXloadDstOff, opXloadDstLen) | uint64(opXloadDstStack)
 	default:
-		panic(fmt.Errorf("invalid index type %T; must be register or stack", dst))
+		return 0, operandKindError("xload dst", dst, "register, relative register, or stack")
 	}
 
-	switch src := src.(type) {
-	case RegisterIndex:
-		instr |= xregisterOp(src, opXloadSrcLen)
-	case constIndex:
-		if !canStoreUnsigned(uint64(src), opXloadSrcLen) {
-			panic(InvalidConstIndex(src))
+	switch src.Kind {
+	case OperandReg:
+		if err := checkRegisterOperand(src); err != nil {
+			return 0, err
+		}
+		instr |= uint64(src.Value&opRegMask) << opXloadSrcOff
+	case OperandRelReg:
+		bits, err := relRegisterBits(src)
+		if err != nil {
+			return 0, err
 		}
-		instr |= unsignedBits64(uint64(src), opXloadSrcOff, opXloadSrcLen) | uint64(opXloadSrcConst)
-	case StackIndex:
-		if !canStore(int64(src), opXloadSrcLen) {
-			panic(InvalidStackIndex(src))
+		instr |= bits << opXloadSrcOff
+	case OperandConst:
+		if err := checkConstOperand(src, XloadSrcConstRange); err != nil {
+			return 0, err
 		}
-		instr |= signedBits64(int64(src), opXloadSrcOff, opXloadSrcLen) | uint64(opXloadSrcStack)
+		instr |= bitfield.Unsigned64(uint64(src.Value), opXloadSrcOff, opXloadSrcLen) | uint64(opXloadSrcConst)
+	case OperandStack:
+		if err := checkStackOperand(src, XloadSrcStackRange); err != nil {
+			return 0, err
+		}
+		instr |= bitfield.Signed64(src.Value, opXloadSrcOff, opXloadSrcLen) | uint64(opXloadSrcStack)
+	case OperandImmediate:
+		if !XloadSrcImmRange.Contains(src.Value) {
+			return 0, fmt.Errorf("immediate outside range %v: %d", XloadSrcImmRange, src.Value)
+		}
+		instr |= bitfield.Signed64(src.Value, opXloadSrcOff, opXloadSrcLen) | uint64(opXloadSrcImm)
 	default:
-		panic(fmt.Errorf("invalid index type %T; must be register, stack, or const", src))
+		return 0, operandKindError("xload src", src, "register, relative register, stack, const, or immediate")
 	}
 
-	return instr
+	return instr, nil
+}
+
+// relRegisterBits returns the bits of a relative register in an xload register operand field.
+func relRegisterBits(r Operand) (uint64, error) {
+	if err := checkRegisterOperand(r); err != nil {
+		return 0, err
+	} else if !RelRegOffsetRange.Contains(r.Offset) {
+		return 0, fmt.Errorf("relative register offset outside range %v: %d", RelRegOffsetRange, r.Offset)
+	}
+	return uint64(r.Value&opRegMask) | opXloadRelReg | bitfield.Signed64(r.Offset, opXloadRelOff, opXloadRelLen), nil
+}
+
+func encodeBinary(op Opcode, out, argA, argB Operand) (instr uint32, err error) {
+	var bits [3]uint32
+	if bits[0], err = binOutBits(out); err != nil {
+		return 0, err
+	} else if bits[1], err = binArgABits(argA); err != nil {
+		return 0, err
+	} else if bits[2], err = binArgBBits(argB); err != nil {
+		return 0, err
+	}
+	return opcodeBits(op) | bits[0] | bits[1] | bits[2], nil
+}
+
+// encodeTLS encodes a tls instruction loading TLS slot slot into arg, or storing arg in it if store is set. arg uses the
+// load dst layout.
+func encodeTLS(store bool, slot int, arg Operand) (instr uint32, err error) {
+	if !TLSRange.Contains(int64(slot)) {
+		return 0, InvalidTLSIndex(slot)
+	}
+
+	instr = opcodeBits(OpTLS) | bitfield.Unsigned32(uint32(slot), opTLSSlotOff, opTLSSlotLen)
+	if store {
+		instr |= uint32(opTLSStore)
+	}
+
+	switch arg.Kind {
+	case OperandReg:
+		bits, err := registerBits(arg, opLoadDstOff)
+		if err != nil {
+			return 0, err
+		}
+		instr |= bits
+	case OperandStack:
+		if err := checkStackOperand(arg, LoadDstStackRange); err != nil {
+			return 0, err
+		}
+		instr |= bitfield.Signed32(int32(arg.Value), opLoadDstOff, opLoadDstLen) | uint32(opLoadDstStack)
+	default:
+		return 0, operandKindError("tls", arg, "register or stack")
+	}
+	return instr, nil
 }
 
-func mkBinaryInstr(op Opcode, out, argA, argB Index) (instr uint32) {
-	instr = opcodeBits(op)
+// encodeFrame encodes an extended frame instruction loading the caller frame index fx into arg, or storing arg in it if
+// store is set. arg uses the xload dst layout, without relative registers, and fx is held in the second word.
+func encodeFrame(store bool, fx FrameIndex, arg Operand) (instr uint64, err error) {
+	if !FrameDepthRange.Contains(int64(fx.Depth)) {
+		return 0, InvalidFrameDepth(fx.Depth)
+	} else if !FrameStackRange.Contains(int64(fx.Index)) {
+		return 0, InvalidStackIndex(fx.Index)
+	}
+
+	instr = uint64(instrExtendedBit) |
+		xopcodeBits(OpFrame) |
+		bitfield.Unsigned64(uint64(fx.Depth), opFrameDepthOff, opFrameDepthLen) |
+		bitfield.Signed64(int64(fx.Index), opFrameIndexOff, opFrameIndexLen)
+	if store {
+		instr |= uint64(opFrameStore)
+	}
 
-	switch out := out.(type) {
-	case RegisterIndex:
-		instr |= registerOp(out, opBinOutOff)
-	case StackIndex:
-		if !canStore(int64(out), opBinOutOff) {
-			panic(InvalidRegister(out))
+	switch arg.Kind {
+	case OperandReg:
+		bits, err := registerBits(arg, opXloadDstOff)
+		if err != nil {
+			return 0, err
 		}
-		instr |= signedBits32(int32(out), opBinOutOff, opBinOutLen) | uint32(opBinOutStack)
+		instr |= uint64(bits)
+	case OperandStack:
+		if err := checkStackOperand(arg, XloadDstStackRange); err != nil {
+			return 0, err
+		}
+		instr |= bitfield.Signed64(arg.Value, opXloadDstOff, opXloadDstLen) | uint64(opXloadDstStack)
 	default:
-		panic(fmt.Errorf("invalid index type %T; must be register or stack", out))
+		return 0, operandKindError("frame", arg, "register or stack")
+	}
+	return instr, nil
+}
+
+// encodeVector encodes the vector form of a binary instruction: an extended instruction holding the element count in
+// its first word and out, argA, and argB in its second, using the binary instruction layout without an opcode.
+func encodeVector(op Opcode, n int, out, argA, argB Operand) (instr uint64, err error) {
+	switch {
+	case op == OpSlice:
+		return 0, fmt.Errorf("slice has no vector form")
+	case !VectorCountRange.Contains(int64(n)):
+		return 0, fmt.Errorf("invalid vector count: %d not in %v", n, VectorCountRange)
+	}
+
+	for _, o := range [...]Operand{out, argA, argB} {
+		if o.Kind == OperandReg && !RegisterRange.Contains(o.Value+int64(n)-1) {
+			return 0, InvalidRegister(o.Value)
+		}
+	}
+
+	args, err := encodeBinary(op, out, argA, argB)
+	if err != nil {
+		return 0, err
+	}
+	args &^= opcodeBits(op)
+
+	return uint64(instrExtendedBit) |
+		xopcodeBits(op) |
+		bitfield.Unsigned64(uint64(n), opVecCountOff, opVecCountLen) |
+		uint64(args)<<opVecArgsOff, nil
+}
+
+// encodeWide encodes the wide form of a binary instruction: an extended instruction holding out in its first word and
+// argA and argB in its second, each in a 16-bit wide operand field. It is told apart from the vector form by the
+// opXbinWide flag.
+func encodeWide(op Opcode, out, argA, argB Operand) (instr uint64, err error) {
+	var bits [3]uint64
+	if bits[0], err = wideBits("out", out, false); err != nil {
+		return 0, err
+	} else if bits[1], err = wideBits("argA", argA, false); err != nil {
+		return 0, err
+	} else if bits[2], err = wideBits("argB", argB, true); err != nil {
+		return 0, err
+	}
+	return uint64(instrExtendedBit|opXbinWide) |
+		xopcodeBits(op) |
+		bits[0]<<opWideOutOff |
+		bits[1]<<opWideArgAOff |
+		bits[2]<<opWideArgBOff, nil
+}
+
+// encodeXtest encodes the wide form of a test instruction, with argA and argB in wide operand fields in its second
+// word. Unlike the basic form, argA and argB may also be immediates.
+func encodeXtest(oper CompareOp, want bool, argA, argB Operand) (instr uint64, err error) {
+	instr = uint64(instrExtendedBit) |
+		xopcodeBits(OpTest) |
+		bitfield.Unsigned64(uint64(oper), opXtestOperOff, opTestOperLen)
+	if want {
+		instr |= uint64(opXtestWant)
 	}
 
-	switch argA := argA.(type) {
-	case RegisterIndex:
-		instr |= registerOp(argA, opBinArgAOff)
-	case StackIndex:
-		if !canStore(int64(argA), opBinArgALen) {
-			panic(InvalidRegister(argA))
+	var bits [2]uint64
+	if bits[0], err = wideBits("argA", argA, true); err != nil {
+		return 0, err
+	} else if bits[1], err = wideBits("argB", argB, true); err != nil {
+		return 0, err
+	}
+	return instr | bits[0]<<opWideArgAOff | bits[1]<<opWideArgBOff, nil
+}
+
+// wideBits returns the 16-bit wide operand field for o. Register and stack operands are always accepted; constant and
+// immediate operands only if src is set.
+func wideBits(field string, o Operand, src bool) (uint64, error) {
+	switch {
+	case o.Kind == OperandReg:
+		if err := checkRegisterOperand(o); err != nil {
+			return 0, err
+		}
+		return uint64(o.Value), nil
+	case o.Kind == OperandStack:
+		if err := checkStackOperand(o, WideStackRange); err != nil {
+			return 0, err
+		}
+		return bitfield.Signed64(o.Value, 0, opWideValLen) | opWideStack, nil
+	case o.Kind == OperandConst && src:
+		if err := checkConstOperand(o, WideConstRange); err != nil {
+			return 0, err
+		}
+		return uint64(o.Value) | opWideConst, nil
+	case o.Kind == OperandImmediate && src:
+		if !WideImmRange.Contains(o.Value) {
+			return 0, fmt.Errorf("immediate outside range %v: %d", WideImmRange, o.Value)
 		}
-		instr |= signedBits32(int32(argA), opBinArgAOff, opBinArgALen) | uint32(opBinArgAStack)
+		return bitfield.Signed64(o.Value, 0, opWideValLen) | opWideImm, nil
+	case src:
+		return 0, operandKindError(field, o, "register, stack, const, or immediate")
 	default:
-		panic(fmt.Errorf("invalid index type %T; must be register or stack", argA))
+		return 0, operandKindError(field, o, "register or stack")
+	}
+}
+
+// encodeUnary encodes a unary instruction (neg, not, round) storing the result of op(arg) in out. Unary instructions
+// use the binary instruction layout with their operand in argB, leaving the argA field free for flags (e.g., the
+// rounding mode).
+func encodeUnary(op Opcode, out, arg Operand) (instr uint32, err error) {
+	var bits [2]uint32
+	if bits[0], err = binOutBits(out); err != nil {
+		return 0, err
+	} else if bits[1], err = binArgBBits(arg); err != nil {
+		return 0, err
+	}
+	return opcodeBits(op) | bits[0] | bits[1], nil
+}
+
+func encodeRound(out, arg Operand, mode RoundingMode) (instr uint32, err error) {
+	if !RoundingModeRange.Contains(int64(mode)) {
+		return 0, InvalidRoundingMode(mode)
+	}
+	if instr, err = encodeUnary(OpRound, out, arg); err != nil {
+		return 0, err
 	}
+	return instr | bitfield.Unsigned32(uint32(mode), opBinArgAOff, opBinArgALen), nil
+}
 
-	switch argB := argB.(type) {
-	case RegisterIndex:
-		instr |= registerOp(argB, opBinArgBOff)
-	case constIndex:
-		if !canStoreUnsigned(uint64(argB), opBinArgBLen) {
-			panic(InvalidConstIndex(argB))
+// encodeSize encodes an instruction taking only an argB operand (reserve, alloc, frameadj). An immediate outside
+// BinaryArgBImmRange is encoded in the wide immediate field instead, which takes the place of the unused out and argA
+// fields, so small sizes need neither a constant nor a register.
+func encodeSize(op Opcode, arg Operand) (instr uint32, err error) {
+	if arg.Kind == OperandImmediate && !BinaryArgBImmRange.Contains(arg.Value) {
+		if !SizeImmRange.Contains(arg.Value) {
+			return 0, fmt.Errorf("immediate outside range %v: %d", SizeImmRange, arg.Value)
 		}
-		instr |= unsignedBits32(uint32(argB), opBinArgBOff, opBinArgBLen) | uint32(opBinArgBConst)
-	case StackIndex:
-		if !canStore(int64(argB), opBinArgBLen) {
-			panic(InvalidRegister(argB))
+		return opcodeBits(op) | bitfield.Signed32(int32(arg.Value), opSizeImmOff, opSizeImmLen) | uint32(opSizeImm), nil
+	}
+	bits, err := binArgBBits(arg)
+	if err != nil {
+		return 0, err
+	}
+	return opcodeBits(op) | bits, nil
+}
+
+func binOutBits(out Operand) (uint32, error) {
+	switch out.Kind {
+	case OperandReg:
+		return registerBits(out, opBinOutOff)
+	case OperandStack:
+		if err := checkStackOperand(out, BinaryOutStackRange); err != nil {
+			return 0, err
 		}
-		instr |= signedBits32(int32(argB), opBinArgBOff, opBinArgBStackLen) | uint32(opBinArgBStack)
+		return bitfield.Signed32(int32(out.Value), opBinOutOff, opBinOutLen) | uint32(opBinOutStack), nil
 	default:
-		panic(fmt.Errorf("invalid index type %T; must be register, stack, or const", argB))
+		return 0, operandKindError("out", out, "register or stack")
 	}
+}
 
-	return instr
+func binArgABits(argA Operand) (uint32, error) {
+	switch argA.Kind {
+	case OperandReg:
+		return registerBits(argA, opBinArgAOff)
+	case OperandStack:
+		if err := checkStackOperand(argA, BinaryArgAStackRange); err != nil {
+			return 0, err
+		}
+		return bitfield.Signed32(int32(argA.Value), opBinArgAOff, opBinArgALen) | uint32(opBinArgAStack), nil
+	default:
+		return 0, operandKindError("argA", argA, "register or stack")
+	}
+}
+
+func binArgBBits(argB Operand) (uint32, error) {
+	switch argB.Kind {
+	case OperandReg:
+		return registerBits(argB, opBinArgBOff)
+	case OperandConst:
+		if err := checkConstOperand(argB, BinaryArgBConstRange); err != nil {
+			return 0, err
+		}
+		return bitfield.Unsigned32(uint32(argB.Value), opBinArgBOff, opBinArgBLen) | uint32(opBinArgBConst), nil
+	case OperandStack:
+		if err := checkStackOperand(argB, BinaryArgBStackRange); err != nil {
+			return 0, err
+		}
+		return bitfield.Signed32(int32(argB.Value), opBinArgBOff, opBinArgBStackLen) | uint32(opBinArgBStack), nil
+	case OperandImmediate:
+		if !BinaryArgBImmRange.Contains(argB.Value) {
+			return 0, fmt.Errorf("immediate outside range %v: %d", BinaryArgBImmRange, argB.Value)
+		}
+		return bitfield.Signed32(int32(argB.Value), opBinArgBOff, opBinArgBImmLen) | uint32(opBinArgBImm), nil
+	default:
+		return 0, operandKindError("argB", argB, "register, stack, const, or immediate")
+	}
 }
 
-func mkTestInstr(oper compareOp, want bool, argA, argB Index) (instr uint32) {
+func encodeTest(oper CompareOp, want bool, argA, argB Operand) (instr uint32, err error) {
 	instr = opcodeBits(OpTest) |
-		unsignedBits32(uint32(oper), opTestOperOff, opTestOperLen)
+		bitfield.Unsigned32(uint32(oper), opTestOperOff, opTestOperLen)
 
 	if want {
 		instr |= uint32(opCmpTestBit)
 	}
 
-	switch arg := argA.(type) {
-	case RegisterIndex:
-		instr |= registerOp(arg, opTestArgAOff)
-	case constIndex:
-		if !canStoreUnsigned(uint64(arg), opTestArgALen) {
-			panic(InvalidConstIndex(arg))
+	switch argA.Kind {
+	case OperandReg:
+		bits, err := registerBits(argA, opTestArgAOff)
+		if err != nil {
+			return 0, err
+		}
+		instr |= bits
+	case OperandConst:
+		if err := checkConstOperand(argA, TestArgAConstRange); err != nil {
+			return 0, err
 		}
-		instr |= unsignedBits32(uint32(arg), opTestArgAOff, opTestArgALen) | uint32(opCmpArgAConst)
-	case StackIndex:
-		if !canStore(int64(arg), opTestArgAStackLen) {
-			panic(InvalidStackIndex(arg))
+		instr |= bitfield.Unsigned32(uint32(argA.Value), opTestArgAOff, opTestArgALen) | uint32(opCmpArgAConst)
+	case OperandStack:
+		if err := checkStackOperand(argA, TestArgAStackRange); err != nil {
+			return 0, err
 		}
-		instr |= signedBits32(int32(arg), opTestArgAOff, opTestArgAStackLen) | uint32(opCmpArgAStack)
+		instr |= bitfield.Signed32(int32(argA.Value), opTestArgAOff, opTestArgAStackLen) | uint32(opCmpArgAStack)
 	default:
-		panic(fmt.Errorf("invalid index type %T; must be register, stack, or const", arg))
+		return 0, operandKindError("test lhs", argA, "register, stack, or const")
 	}
 
-	switch arg := argB.(type) {
-	case RegisterIndex:
-		instr |= registerOp(arg, opTestArgBOff)
-	case constIndex:
-		if !canStoreUnsigned(uint64(arg), opTestArgBLen) {
-			panic(InvalidConstIndex(arg))
+	switch argB.Kind {
+	case OperandReg:
+		bits, err := registerBits(argB, opTestArgBOff)
+		if err != nil {
+			return 0, err
 		}
-		instr |= unsignedBits32(uint32(arg), opTestArgBOff, opTestArgBLen) | uint32(opCmpArgBConst)
-	case StackIndex:
-		if !canStore(int64(arg), opTestArgBStackLen) {
-			panic(InvalidStackIndex(arg))
+		instr |= bits
+	case OperandConst:
+		if err := checkConstOperand(argB, TestArgBConstRange); err != nil {
+			return 0, err
 		}
-		instr |= signedBits32(int32(arg), opTestArgBOff, opTestArgBStackLen) | uint32(opCmpArgBStack)
+		instr |= bitfield.Unsigned32(uint32(argB.Value), opTestArgBOff, opTestArgBLen) | uint32(opCmpArgBConst)
+	case OperandStack:
+		if err := checkStackOperand(argB, TestArgBStackRange); err != nil {
+			return 0, err
+		}
+		instr |= bitfield.Signed32(int32(argB.Value), opTestArgBOff, opTestArgBStackLen) | uint32(opCmpArgBStack)
 	default:
-		panic(fmt.Errorf("invalid index type %T; must be register, stack, or const", arg))
+		return 0, operandKindError("test rhs", argB, "register, stack, or const")
 	}
 
-	return instr
+	return instr, nil
 }
 
-func mkPushPop(op Opcode, oprange int, arg Index) (instr uint32) {
+func encodePushPop(op Opcode, oprange int, arg Operand) (instr uint32, err error) {
 	switch {
 	case op != OpPush && op != OpPop:
-		panic(fmt.Errorf("op is not push or pop: %v", op))
-	case !canStoreUnsigned(uint64(oprange-1), opPushPopRangeLen):
-		panic(fmt.Errorf("invalid push/pop range: %d not in 1..%d", oprange, (1 << opPushPopRangeLen)))
+		return 0, fmt.Errorf("op is not push or pop: %v", op)
+	case !PushPopCountRange.Contains(int64(oprange)):
+		return 0, fmt.Errorf("invalid push/pop range: %d not in %v", oprange, PushPopCountRange)
 	}
 
 	instr = opcodeBits(op) |
-		unsignedBits32(uint32(oprange-1), opPushPopRangeOff, opPushPopRangeLen)
+		bitfield.Unsigned32(uint32(oprange-1), opPushPopRangeOff, opPushPopRangeLen)
 
-	switch arg := arg.(type) {
-	case RegisterIndex:
-		if arg+RegisterIndex(oprange) > registerCount {
-			panic(InvalidRegister(arg))
+	switch arg.Kind {
+	case OperandNone:
+		if op != OpPop {
+			return 0, operandKindError(op.String(), arg, "register, stack, const, or immediate")
+		}
+		instr |= uint32(opPopDiscard)
+	case OperandReg:
+		bits, err := registerBits(arg, opPushPopTargetOff)
+		if err != nil {
+			return 0, err
+		} else if !RegisterRange.Contains(arg.Value + int64(oprange) - 1) {
+			return 0, InvalidRegister(arg.Value)
 		}
-		instr |= registerOp(arg, opPushPopTargetOff)
-	case StackIndex:
-		if !canStore(int64(arg), opPushPopTargetLen) {
-			panic(InvalidStackIndex(arg))
+		instr |= bits
+	case OperandStack:
+		if err := checkStackOperand(arg, PushPopStackRange); err != nil {
+			return 0, err
 		}
-		instr |= signedBits32(int32(arg), opPushPopTargetOff, opPushPopTargetLen) | uint32(opPushPopStack)
-	case constIndex:
-		if !canStoreUnsigned(uint64(arg), opPushPopTargetLen) {
-			panic(InvalidConstIndex(arg))
+		instr |= bitfield.Signed32(int32(arg.Value), opPushPopTargetOff, opPushPopTargetLen) | uint32(opPushPopStack)
+	case OperandConst:
+		if op != OpPush {
+			return 0, operandKindError(op.String(), arg, "register, stack, or none")
+		} else if err := checkConstOperand(arg, PushConstRange); err != nil {
+			return 0, err
 		}
-		instr |= unsignedBits32(uint32(arg), opPushPopTargetOff, opPushPopTargetLen) | uint32(opPushConst)
+		instr |= bitfield.Unsigned32(uint32(arg.Value), opPushPopTargetOff, opPushPopTargetLen) | uint32(opPushConst)
+	case OperandImmediate:
+		if op != OpPush {
+			return 0, operandKindError(op.String(), arg, "register, stack, or none")
+		} else if !PushImmediateRange.Contains(arg.Value) {
+			return 0, fmt.Errorf("immediate outside range %v: %d", PushImmediateRange, arg.Value)
+		}
+		instr |= bitfield.Signed32(int32(arg.Value), opPushPopTargetOff, opPushPopTargetLen) | uint32(opPushImmediate)
 	default:
-		req := "register, stack, or const"
 		if op == OpPop {
-			req = "register or stack"
+			return 0, operandKindError(op.String(), arg, "register, stack, or none")
 		}
-		panic(fmt.Errorf("invalid index type %T; must be %s", arg, req))
+		return 0, operandKindError(op.String(), arg, "register, stack, const, or immediate")
 	}
 
-	return instr
-}
-
-func opcodeBits(op Opcode) uint32 {
-	return (uint32(op) & (1<<opBOpcodeLen - 1)) << opBOpcodeOff
-}
-
-func xopcodeBits(op Opcode) uint64 {
-	return (uint64(op) & (1<<opXOpcodeLen - 1)) << opXOpcodeOff
+	return instr, nil
 }
 
-func xregisterOp(r RegisterIndex, pos uint) uint64 {
-	if r < 0 || r > registerCount {
-		panic(InvalidRegister(r))
+func encodeXpush(list Operand) (instr uint64, err error) {
+	if list.Kind != OperandConst {
+		return 0, operandKindError("xpush", list, "const")
+	} else if err := checkConstOperand(list, XpushListRange); err != nil {
+		return 0, err
 	}
-	return uint64(r&opRegMask) << pos
+	return uint64(instrExtendedBit) |
+		xopcodeBits(OpPush) |
+		bitfield.Unsigned64(uint64(list.Value), opXpushListOff, opXpushListLen), nil
 }
 
-func registerOp(r RegisterIndex, pos uint) uint32 {
-	if r < 0 || r > registerCount {
-		panic(InvalidRegister(r))
+func encodeStackOp(op Opcode, oprange int, arg int) (instr uint32, err error) {
+	switch {
+	case op != OpDup && op != OpRotate:
+		return 0, fmt.Errorf("op is not dup or rot: %v", op)
+	case !PushPopCountRange.Contains(int64(oprange)):
+		return 0, fmt.Errorf("invalid %v range: %d not in %v", op, oprange, PushPopCountRange)
+	case op == OpDup && arg < 0:
+		return 0, fmt.Errorf("invalid dup depth: %d", arg)
+	case !StackOpArgRange.Contains(int64(arg)):
+		return 0, fmt.Errorf("%v operand outside range %v: %d", op, StackOpArgRange, arg)
 	}
-	return uint32(r&opRegMask) << pos
-}
-
-func signedBits64(i int64, pos, length uint) uint64 {
-	return (uint64(i<<(64-length)) >> (64 - (length + pos))) & ((1<<length - 1) << pos)
-}
 
-func signedBits32(i int32, pos, length uint) uint32 {
-	return uint32(i<<(32-length)) >> (32 - (length + pos)) & ((1<<length - 1) << pos)
+	return opcodeBits(op) |
+		bitfield.Unsigned32(uint32(oprange-1), opPushPopRangeOff, opPushPopRangeLen) |
+		bitfield.Signed32(int32(arg), opPushPopTargetOff, opPushPopTargetLen), nil
 }
 
-func unsignedBits64(i uint64, pos, length uint) uint64 {
-	return uint64(i&(1<<length-1)) << pos
-}
-
-func unsignedBits32(i uint32, pos, length uint) uint32 {
-	return uint32(i&(1<<length-1)) << pos
+func opcodeBits(op Opcode) uint32 {
+	return (uint32(op) & (1<<opBOpcodeLen - 1)) << opBOpcodeOff
 }
 
-func canStore(i int64, bits uint) bool {
-	bits-- // sign bit
-	var (
-		max int64 = 1<<bits - 1
-		min int64 = -max - 1
-	)
-	return i >= min && i <= max
+func xopcodeBits(op Opcode) uint64 {
+	return (uint64(op) & (1<<opXOpcodeLen - 1)) << opXOpcodeOff
 }
 
-func canStoreUnsigned(i uint64, bits uint) bool {
-	return i <= (^uint64(0) >> (64 - bits))
+func registerBits(r Operand, pos uint) (uint32, error) {
+	if err := checkRegisterOperand(r); err != nil {
+		return 0, err
+	}
+	return uint32(r.Value&opRegMask) << pos, nil
 }
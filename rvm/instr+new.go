@@ -0,0 +1,333 @@
+package rvm
+
+import "fmt"
+
+func checkBinaryOp(op Opcode) error {
+	switch op {
+	case OpAdd, OpSub, OpDiv, OpMul, OpPow, OpMod,
+		OpOr, OpAnd, OpXor, OpArithshift, OpBitshift, OpSlice:
+		return nil
+	default:
+		return fmt.Errorf("op is not a binary op: %v", op)
+	}
+}
+
+func checkUnaryOp(op Opcode) error {
+	if op != OpNeg && op != OpNot {
+		return fmt.Errorf("op is not neg or not: %v", op)
+	}
+	return nil
+}
+
+// Instruction encoders. Each Encode function returns an error if an operand's kind is not accepted by the instruction
+// or its value does not fit in the instruction's encoding.
+
+// EncodeBinary returns an instruction storing the result of `argA op argB` in out. op must be one of OpAdd, OpSub,
+// OpDiv, OpMul, OpPow, OpMod, OpOr, OpAnd, OpXor, OpArithshift, OpBitshift, or OpSlice. out and argA may be register or
+// stack operands; argB may also be a constant operand or an immediate in BinaryArgBImmRange.
+func EncodeBinary(op Opcode, out, argA, argB Operand) (Instruction, error) {
+	if err := checkBinaryOp(op); err != nil {
+		return 0, err
+	}
+	instr, err := encodeBinary(op, out, argA, argB)
+	return Instruction(instr), err
+}
+
+// EncodeVector returns an extended instruction applying a binary op to n consecutive elements, storing `argA[j] op
+// argB[j]` in out[j] for each j in 0..n-1. op, out, argA, and argB are as for EncodeBinary, except that op may not be
+// OpSlice. Register, stack, and constant operands are the first index of a range; an immediate argB is used for every
+// element. n must be in VectorCountRange.
+func EncodeVector(op Opcode, n int, out, argA, argB Operand) (Instruction, error) {
+	if err := checkBinaryOp(op); err != nil {
+		return 0, err
+	}
+	instr, err := encodeVector(op, n, out, argA, argB)
+	return Instruction(instr), err
+}
+
+// EncodeWideBinary returns the wide (two word) form of a binary instruction, which takes the same op and operands as
+// EncodeBinary but accepts stack, constant, and immediate operands in WideStackRange, WideConstRange, and WideImmRange
+// for each of them.
+func EncodeWideBinary(op Opcode, out, argA, argB Operand) (Instruction, error) {
+	if err := checkBinaryOp(op); err != nil {
+		return 0, err
+	}
+	instr, err := encodeWide(op, out, argA, argB)
+	return Instruction(instr), err
+}
+
+// EncodeUnary returns an instruction storing the result of op(arg) in out. op must be OpNeg or OpNot. out may be a
+// register or stack operand; arg may also be a constant or immediate operand.
+func EncodeUnary(op Opcode, out, arg Operand) (Instruction, error) {
+	if err := checkUnaryOp(op); err != nil {
+		return 0, err
+	}
+	instr, err := encodeUnary(op, out, arg)
+	return Instruction(instr), err
+}
+
+// EncodeRound returns an instruction storing arg rounded using mode in out.
+func EncodeRound(out, arg Operand, mode RoundingMode) (Instruction, error) {
+	instr, err := encodeRound(out, arg, mode)
+	return Instruction(instr), err
+}
+
+// EncodeReserve returns an instruction reserving stack capacity for size more values. size may be a register, stack, or
+// constant operand as for EncodeBinary's argB, or an immediate in SizeImmRange. Immediates too large for argB are
+// encoded in a wider field, so reserving a fixed size never needs a constant. EncodeAlloc and EncodeFrameAdjust accept
+// the same operands.
+func EncodeReserve(size Operand) (Instruction, error) {
+	instr, err := encodeSize(OpReserve, size)
+	return Instruction(instr), err
+}
+
+// EncodeAlloc returns an instruction allocating n nil values on the stack, or releasing -n values if n is negative.
+func EncodeAlloc(n Operand) (Instruction, error) {
+	instr, err := encodeSize(OpAlloc, n)
+	return Instruction(instr), err
+}
+
+// EncodeFrameAdjust returns an instruction moving the current frame's ebp by delta.
+func EncodeFrameAdjust(delta Operand) (Instruction, error) {
+	instr, err := encodeSize(OpFrameAdjust, delta)
+	return Instruction(instr), err
+}
+
+// EncodeLoad returns an instruction copying src to dst. dst may be a register or stack operand; src may also be a
+// constant operand or an immediate in LoadSrcImmRange.
+func EncodeLoad(dst, src Operand) (Instruction, error) {
+	instr, err := encodeLoad(dst, src)
+	return Instruction(instr), err
+}
+
+// EncodeXload returns an extended (two word) load instruction, accepting wider stack, constant, and immediate operands
+// than EncodeLoad. Either dst or src may also be a relative register operand, whose offset must be in
+// RelRegOffsetRange.
+func EncodeXload(dst, src Operand) (Instruction, error) {
+	instr, err := encodeXload(dst, src)
+	return Instruction(instr), err
+}
+
+// EncodeMove returns an instruction copying src to dst, using the compact encoding of EncodeLoad if dst and src fit it
+// and the extended encoding of EncodeXload otherwise. It returns an error only if they fit neither.
+func EncodeMove(dst, src Operand) (Instruction, error) {
+	if instr, err := encodeLoad(dst, src); err == nil {
+		return Instruction(instr), nil
+	}
+	instr, err := encodeXload(dst, src)
+	return Instruction(instr), err
+}
+
+// EncodeTLSLoad returns an instruction copying TLS slot slot to dst, which may be a register or stack operand. slot
+// must be in TLSRange.
+func EncodeTLSLoad(dst Operand, slot int) (Instruction, error) {
+	instr, err := encodeTLS(false, slot, dst)
+	return Instruction(instr), err
+}
+
+// EncodeTLSStore returns an instruction copying src, a register or stack operand, to TLS slot slot. slot must be in
+// TLSRange.
+func EncodeTLSStore(slot int, src Operand) (Instruction, error) {
+	instr, err := encodeTLS(true, slot, src)
+	return Instruction(instr), err
+}
+
+// EncodeFrameLoad returns an instruction copying the value at fx, in a caller's frame, to dst, which may be a register
+// or stack operand. fx's depth must be in FrameDepthRange and its index in FrameStackRange.
+func EncodeFrameLoad(dst Operand, fx FrameIndex) (Instruction, error) {
+	instr, err := encodeFrame(false, fx, dst)
+	return Instruction(instr), err
+}
+
+// EncodeFrameStore returns an instruction copying src, a register or stack operand, to fx in a caller's frame. fx's
+// depth must be in FrameDepthRange and its index in FrameStackRange.
+func EncodeFrameStore(fx FrameIndex, src Operand) (Instruction, error) {
+	instr, err := encodeFrame(true, fx, src)
+	return Instruction(instr), err
+}
+
+// EncodeJump returns an instruction jumping by offset, relative to the next instruction. If src is not the zero
+// Operand, offset must be zero and the jump offset is read from src instead.
+func EncodeJump(offset int, src Operand) (Instruction, error) {
+	instr, err := encodeJump(offset, src)
+	return Instruction(instr), err
+}
+
+// EncodeLongJump returns an instruction jumping by offset, relative to the next instruction, and the function's
+// constant table with any constant the jump needs. If offset is in JumpOffsetRange, the jump encodes it directly and
+// consts is returned unchanged. Otherwise, the jump reads offset from an Int constant, which is reused if consts
+// already holds it and appended to consts if not. Either form is one code word, so choosing between them never moves
+// other code.
+//
+// consts must be the function's own constant table, with its const[0] first. For a function in a shared pool, that is
+// Consts[ConstBase:ConstBase+ConstLen], and the caller must move the returned table into the pool and update ConstLen
+// if the jump added a constant. The returned table never shares storage beyond len(consts), so appending to a subslice
+// of a pool does not overwrite the constants that follow it.
+func EncodeLongJump(offset int, consts []Value) (Instruction, []Value, error) {
+	if JumpOffsetRange.Contains(int64(offset)) {
+		instr, err := encodeJump(offset, Operand{})
+		return Instruction(instr), consts, err
+	}
+
+	index := len(consts)
+	for i, v := range consts {
+		if n, ok := v.(Int); ok && n == Int(offset) {
+			index = i
+			break
+		}
+	}
+	instr, err := encodeJump(0, Const(index))
+	if err != nil {
+		return 0, consts, err
+	}
+	if index == len(consts) {
+		consts = append(consts[:len(consts):len(consts)], Int(offset))
+	}
+	return Instruction(instr), consts, nil
+}
+
+// EncodeTest returns an instruction comparing lhs and rhs using op. If the result of the comparison is equal to want,
+// the next instruction is executed immediately if it is a jump, and skipped otherwise.
+func EncodeTest(op CompareOp, want bool, lhs, rhs Operand) (Instruction, error) {
+	instr, err := encodeTest(op, want, lhs, rhs)
+	return Instruction(instr), err
+}
+
+// EncodeXtest returns the wide (two word) form of a test instruction, which accepts stack and constant operands in
+// WideStackRange and WideConstRange, and immediates in WideImmRange, for lhs and rhs.
+func EncodeXtest(op CompareOp, want bool, lhs, rhs Operand) (Instruction, error) {
+	instr, err := encodeXtest(op, want, lhs, rhs)
+	return Instruction(instr), err
+}
+
+// EncodePushPop returns a push or pop instruction for n values, where n is in 1..64. For OpPush, arg is the first
+// source of a range of registers, stack slots, or constants, or an immediate pushed n times. For OpPop, arg is the
+// first destination register or stack slot, or the zero Operand to discard the popped values.
+func EncodePushPop(op Opcode, n int, arg Operand) (Instruction, error) {
+	instr, err := encodePushPop(op, n, arg)
+	return Instruction(instr), err
+}
+
+// EncodeXpush returns an extended push instruction pushing each source of the PushList at the constant operand list.
+func EncodeXpush(list Operand) (Instruction, error) {
+	instr, err := encodeXpush(list)
+	return Instruction(instr), err
+}
+
+// EncodeStackOp returns a dup or rot instruction over the top n values of the stack, where n is in 1..64. For OpDup,
+// arg is the depth below the top of the stack to copy from; for OpRotate, it is the number of positions to rotate by.
+func EncodeStackOp(op Opcode, n, arg int) (Instruction, error) {
+	instr, err := encodeStackOp(op, n, arg)
+	return Instruction(instr), err
+}
+
+// Len returns the number of code words the instruction occupies: 2 for extended instructions, otherwise 1.
+func (i Instruction) Len() int {
+	if i.isExt() {
+		return 2
+	}
+	return 1
+}
+
+// AppendTo appends the instruction's code words to code and returns the extended slice.
+func (i Instruction) AppendTo(code []uint32) []uint32 {
+	if i.isExt() {
+		return append(code, uint32(i), uint32(i>>32))
+	}
+	return append(code, uint32(i))
+}
+
+// EncodeDecodeCheck decodes instr and re-encodes its operands with the encoder for its opcode, returning an error if
+// the result differs from instr or if its operands cannot be re-encoded. A nil error means instr is in the canonical
+// form produced by the encoders, so external assemblers can use it to verify their output.
+func EncodeDecodeCheck(instr Instruction) error {
+	dst, args, ok := instr.operands()
+	if !ok {
+		return InvalidOpcode(instr.Opcode())
+	}
+
+	// Decoded operands always have an Operand form, so operand only panics if the decoder is broken.
+	operand := func(v interface{}) Operand {
+		if v == nil {
+			return Operand{}
+		}
+		return mustOperand(v.(Index))
+	}
+
+	var (
+		enc Instruction
+		err error
+	)
+	switch op := instr.Opcode(); op {
+	case OpAdd, OpSub, OpDiv, OpMul, OpPow, OpMod,
+		OpOr, OpAnd, OpXor, OpArithshift, OpBitshift, OpSlice:
+		if instr.isWide() {
+			enc, err = EncodeWideBinary(op, operand(dst), operand(args[0]), operand(args[1]))
+		} else if instr.isExt() {
+			enc, err = EncodeVector(op, args[0].(int), operand(dst), operand(args[1]), operand(args[2]))
+		} else {
+			enc, err = EncodeBinary(op, operand(dst), operand(args[0]), operand(args[1]))
+		}
+	case OpNeg, OpNot:
+		enc, err = EncodeUnary(op, operand(dst), operand(args[0]))
+	case OpRound:
+		enc, err = EncodeRound(operand(dst), operand(args[0]), args[1].(RoundingMode))
+	case OpReserve, OpAlloc, OpFrameAdjust:
+		var sz uint32
+		sz, err = encodeSize(op, operand(args[0]))
+		enc = Instruction(sz)
+	case OpLoad:
+		if instr.isExt() {
+			enc, err = EncodeXload(operand(dst), operand(args[0]))
+		} else {
+			enc, err = EncodeLoad(operand(dst), operand(args[0]))
+		}
+	case OpFrame:
+		if fx, ok := dst.(FrameIndex); ok {
+			enc, err = EncodeFrameStore(fx, operand(args[0]))
+		} else {
+			enc, err = EncodeFrameLoad(operand(dst), args[0].(FrameIndex))
+		}
+	case OpTLS:
+		if slot, ok := dst.(TLSIndex); ok {
+			enc, err = EncodeTLSStore(int(slot), operand(args[0]))
+		} else {
+			enc, err = EncodeTLSLoad(operand(dst), int(args[0].(TLSIndex)))
+		}
+	case OpPush:
+		if instr.isExt() {
+			enc, err = EncodeXpush(operand(args[0]))
+		} else {
+			enc, err = EncodePushPop(op, args[0].(int), operand(args[1]))
+		}
+	case OpPop:
+		var arg Operand
+		if len(args) > 1 {
+			arg = operand(args[1])
+		}
+		enc, err = EncodePushPop(op, args[0].(int), arg)
+	case OpDup, OpRotate:
+		enc, err = EncodeStackOp(op, args[0].(int), args[1].(int))
+	case OpJump:
+		if off, ok := args[0].(int64); ok {
+			enc, err = EncodeJump(int(off), Operand{})
+		} else {
+			enc, err = EncodeJump(0, operand(args[0]))
+		}
+	case OpTest:
+		if instr.isExt() {
+			enc, err = EncodeXtest(args[1].(CompareOp), args[3].(bool), operand(args[0]), operand(args[2]))
+		} else {
+			enc, err = EncodeTest(args[1].(CompareOp), args[3].(bool), operand(args[0]), operand(args[2]))
+		}
+	default:
+		return fmt.Errorf("no canonical encoding for %v", op)
+	}
+
+	if err != nil {
+		return fmt.Errorf("cannot re-encode %v: %v", instr, err)
+	} else if enc != instr {
+		return fmt.Errorf("instruction %v [%016x] re-encodes as %v [%016x]", instr, uint64(instr), enc, uint64(enc))
+	}
+	return nil
+}
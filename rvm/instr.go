@@ -31,6 +31,7 @@ const (
 	opBinArgAStack Instruction = 0x2000
 	opBinArgBConst Instruction = 0x100000
 	opBinArgBStack Instruction = 0x80000000
+	opBinArgBImm   Instruction = 0x40000000 // Only if argB is neither const nor stack
 
 	opCmpTestBit   Instruction = 0x200
 	opCmpArgAConst Instruction = 0x400
@@ -45,13 +46,32 @@ const (
 	opLoadDstStack Instruction = 0x40
 	opLoadSrcConst Instruction = 0x4000
 	opLoadSrcStack Instruction = 0x8000
+	opLoadSrcImm   Instruction = opLoadSrcConst | opLoadSrcStack
 
 	opXloadDstStack Instruction = 0x2000
 	opXloadSrcConst Instruction = 0x40000000
 	opXloadSrcStack Instruction = 0x80000000
+	opXloadSrcImm   Instruction = opXloadSrcConst | opXloadSrcStack
+	opXloadRelReg               = 0x40 // Within an xload register operand field: the register is relative
 
-	opPushConst    Instruction = 0x1000
-	opPushPopStack Instruction = 0x2000
+	opTLSStore Instruction = 0x4000 // Store to the TLS slot instead of loading from it
+
+	opFrameStore Instruction = 0x40000000 // Store to the caller's frame instead of loading from it
+
+	opSizeImm Instruction = 0x40 // Reserve, alloc, frameadj: a wide immediate in place of the out and argA fields
+
+	opXbinWide  Instruction = 0x2000 // Extended binary only: wide operands instead of a vector
+	opXtestWant Instruction = 0x2000
+
+	// Within a wide operand field: the kind of operand. Neither bit set is a register.
+	opWideStack = 0x4000
+	opWideConst = 0x8000
+	opWideImm   = opWideStack | opWideConst
+
+	opPushConst     Instruction = 0x1000
+	opPopDiscard    Instruction = 0x1000 // Pop only: discard values instead of storing them
+	opPushPopStack  Instruction = 0x2000
+	opPushImmediate Instruction = opPushConst | opPushPopStack
 )
 
 const (
@@ -71,11 +91,14 @@ const (
 	opBinArgBOff      = 21
 	opBinArgBLen      = 11
 	opBinArgBStackLen = 10
+	opBinArgBImmLen   = 9
 
 	opXloadDstOff = 14
 	opXloadDstLen = 16
 	opXloadSrcOff = 32
 	opXloadSrcLen = 32
+	opXloadRelOff = 7 // Offset of a relative register's offset within an xload register operand field
+	opXloadRelLen = 7
 
 	opLoadDstOff = 7
 	opLoadDstLen = 7
@@ -103,6 +126,30 @@ const (
 	opPushPopTargetOff = 14
 	opPushPopTargetLen = 18
 
+	opXpushListOff = 32
+	opXpushListLen = 32
+
+	opTLSSlotOff = 16
+	opTLSSlotLen = 8
+
+	opFrameIndexOff = 32
+	opFrameIndexLen = 24
+	opFrameDepthOff = 56
+	opFrameDepthLen = 8
+
+	opVecCountOff = 16
+	opVecCountLen = 16
+	opVecArgsOff  = 32
+
+	opSizeImmOff = 7
+	opSizeImmLen = 25
+
+	opXtestOperOff = 14
+	opWideOutOff   = 16
+	opWideArgAOff  = 32
+	opWideArgBOff  = 48
+	opWideValLen   = 14 // Width of the value in a 16-bit wide operand field, below its kind bits
+
 	opBOpcodeMask       = (1<<opBOpcodeLen - 1) << opBOpcodeOff
 	opXOpcodeMask       = (1<<opXOpcodeLen - 1) << opXOpcodeOff
 	opBinOutMask        = (1<<opBinOutLen - 1) << opBinOutOff
@@ -139,6 +186,9 @@ func (i Instruction) Opcode() Opcode {
 }
 
 func (i Instruction) regOut() Index {
+	if i.isExt() {
+		return wideOperand(uint16(i >> opWideOutOff))
+	}
 	const l, r uint = 32 - (opBinOutOff + opBinOutLen), 32 - opBinOutLen
 	if i&opBinOutStack != 0 {
 		return StackIndex(int32(i<<l) >> r)
@@ -147,7 +197,9 @@ func (i Instruction) regOut() Index {
 }
 
 func (i Instruction) argA() Index {
-	if i&opBinArgAStack != 0 {
+	if i.isExt() {
+		return wideOperand(uint16(i >> opWideArgAOff))
+	} else if i&opBinArgAStack != 0 {
 		const l, r uint = 32 - (opBinArgAOff + opBinArgALen), 32 - opBinArgALen
 		return StackIndex(int32((i&opBinArgAMask)<<l) >> r)
 	}
@@ -163,24 +215,46 @@ func (i Instruction) argAU() uint {
 	return uint(i&opBinArgAXMask) >> opBinArgAOff
 }
 
+// roundMode returns the rounding mode of a round instruction, stored in the argA field. It is not read with argAU,
+// whose seventh bit is the argB constant flag.
+func (i Instruction) roundMode() RoundingMode {
+	return RoundingMode((i & opBinArgAMask) >> opBinArgAOff)
+}
+
 func (i Instruction) argB() Index {
 	ix := uint32(i >> opBinArgBOff)
-	if i&opBinArgBConst != 0 {
-		return constIndex((i & opBinArgBMask) >> opBinArgBOff)
+	if i.isExt() {
+		return wideOperand(uint16(i >> opWideArgBOff))
+	} else if i&opBinArgBConst != 0 {
+		return ConstIndex((i & opBinArgBMask) >> opBinArgBOff)
 	} else if i&opBinArgBStack != 0 {
 		const l, r uint = 32 - (opBinArgBOff + opBinArgBStackLen), 32 - opBinArgBStackLen
 		return StackIndex(int32((i&opBinArgBMask)<<l) >> r)
+	} else if i&opBinArgBImm != 0 {
+		const l, r uint = 32 - (opBinArgBOff + opBinArgBImmLen), 32 - opBinArgBImmLen
+		return ImmediateIndex(int32(i<<l) >> r)
 	}
 	return RegisterIndex(ix & opRegMask)
 }
 
+// sizeArg returns the operand of a reserve, alloc, or frameadj instruction: either its wide immediate or argB.
+func (i Instruction) sizeArg() Index {
+	if i&opSizeImm != 0 {
+		const l, r uint = 32 - (opSizeImmOff + opSizeImmLen), 32 - opSizeImmLen
+		return ImmediateIndex(int32(i<<l) >> r)
+	}
+	return i.argB()
+}
+
 func (i Instruction) pushPopRange() int {
 	return 1 + int((i&opPushPopRangeMask)>>opPushPopRangeOff)
 }
 
 func (i Instruction) pushArg() Index {
-	if i&opPushConst != 0 {
-		return constIndex((i & opPushPopTargetMask) >> opPushPopTargetOff)
+	if i&opPushImmediate == opPushImmediate {
+		return ImmediateIndex(int32(i&opPushPopTargetMask) >> opPushPopTargetOff)
+	} else if i&opPushConst != 0 {
+		return ConstIndex((i & opPushPopTargetMask) >> opPushPopTargetOff)
 	} else if i&opPushPopStack != 0 {
 		return StackIndex(int32(i&opPushPopTargetMask) >> opPushPopTargetOff)
 	}
@@ -188,26 +262,118 @@ func (i Instruction) pushArg() Index {
 	return RegisterIndex(i>>opPushPopTargetOff) & opRegMask
 }
 
+// tlsSlot returns the TLS slot of a tls instruction. Its register or stack operand uses the load dst layout and is
+// decoded by loadDst.
+func (i Instruction) tlsSlot() TLSIndex {
+	return TLSIndex(uint8(i >> opTLSSlotOff))
+}
+
+// frameIndex returns the caller frame index of a frame instruction, which is held in its second word. Its register or
+// stack operand uses the xload dst layout.
+func (i Instruction) frameIndex() FrameIndex {
+	const l, r uint = 64 - (opFrameIndexOff + opFrameIndexLen), 64 - opFrameIndexLen
+	return FrameIndex{
+		Depth: int(i>>opFrameDepthOff) & (1<<opFrameDepthLen - 1),
+		Index: StackIndex(int64(i<<l) >> r),
+	}
+}
+
+// wideOperand decodes a 16-bit wide operand field, used by the wide forms of binary and test instructions. Its top two
+// bits give the kind of operand and the rest its value, which is signed for stack and immediate operands.
+func wideOperand(field uint16) Index {
+	const l, r uint = 16 - opWideValLen, 16 - opWideValLen
+	switch field & opWideImm {
+	case opWideStack:
+		return StackIndex(int16(field<<l) >> r)
+	case opWideConst:
+		return ConstIndex(field &^ opWideImm)
+	case opWideImm:
+		return ImmediateIndex(int16(field<<l) >> r)
+	default:
+		return RegisterIndex(field & opRegMask)
+	}
+}
+
+// isWide returns whether the instruction is the wide (extended) form of a binary or test instruction. Its operands are
+// decoded by the same methods as the basic form's.
+func (i Instruction) isWide() bool {
+	if !i.isExt() {
+		return false
+	}
+	switch i.Opcode() {
+	case OpTest:
+		return true
+	case OpAdd, OpSub, OpDiv, OpMul, OpPow, OpMod,
+		OpOr, OpAnd, OpXor, OpArithshift, OpBitshift, OpSlice:
+		return i&opXbinWide != 0
+	}
+	return false
+}
+
+// isVector returns whether the instruction is the vector (extended) form of a binary instruction.
+func (i Instruction) isVector() bool {
+	if !i.isExt() || i&opXbinWide != 0 {
+		return false
+	}
+	switch i.Opcode() {
+	case OpAdd, OpSub, OpDiv, OpMul, OpPow, OpMod,
+		OpOr, OpAnd, OpXor, OpArithshift, OpBitshift:
+		return true
+	}
+	return false
+}
+
+// vecCount returns the element count of a vector instruction.
+func (i Instruction) vecCount() int {
+	return int(uint16(i >> opVecCountOff))
+}
+
+// vecArgs returns the second word of a vector instruction, which holds its operands in the binary instruction layout.
+func (i Instruction) vecArgs() Instruction {
+	return i >> opVecArgsOff
+}
+
+// pushList returns the constant index of the PushList used by an xpush instruction.
+func (i Instruction) pushList() ConstIndex {
+	return ConstIndex(i >> opXpushListOff)
+}
+
+// stackOpArg returns the signed operand of a dup or rotate instruction, stored in the push/pop target field.
+func (i Instruction) stackOpArg() int {
+	return int(int32(i&opPushPopTargetMask) >> opPushPopTargetOff)
+}
+
+// popArg returns the destination of a pop instruction. If the pop discards its values, it returns nil.
 func (i Instruction) popArg() Index {
-	if i&opPushPopStack != 0 {
+	if i&opPopDiscard != 0 {
+		return nil
+	} else if i&opPushPopStack != 0 {
 		return StackIndex(int32(i&opPushPopTargetMask) >> opPushPopTargetOff)
 	}
 
 	return RegisterIndex(i>>opPushPopTargetOff) & opRegMask
 }
 
-func (i Instruction) cmpOp() compareOp {
-	return compareOp((i & opTestOperMask) >> opTestOperOff)
+func (i Instruction) cmpOp() CompareOp {
+	if i.isExt() {
+		return CompareOp((i >> opXtestOperOff) & (1<<opTestOperLen - 1))
+	}
+	return CompareOp((i & opTestOperMask) >> opTestOperOff)
 }
 
 func (i Instruction) cmpWant() bool {
+	if i.isExt() {
+		return i&opXtestWant != 0
+	}
 	return i&opCmpTestBit != 0
 }
 
 func (i Instruction) cmpArgA() Index {
 	ix := uint32((i & opTestArgAMask) >> opTestArgAOff)
-	if i&opCmpArgAConst != 0 {
-		return constIndex(ix)
+	if i.isExt() {
+		return wideOperand(uint16(i >> opWideArgAOff))
+	} else if i&opCmpArgAConst != 0 {
+		return ConstIndex(ix)
 	} else if i&opCmpArgAStack != 0 {
 		const l, r uint = 32 - (opTestArgAOff + opTestArgAStackLen), 32 - opTestArgAStackLen
 		return StackIndex(int32(i<<l) >> r)
@@ -217,8 +383,10 @@ func (i Instruction) cmpArgA() Index {
 
 func (i Instruction) cmpArgB() Index {
 	ix := uint32((i & opTestArgBMask) >> opTestArgBOff)
-	if i&opCmpArgBConst != 0 {
-		return constIndex(ix)
+	if i.isExt() {
+		return wideOperand(uint16(i >> opWideArgBOff))
+	} else if i&opCmpArgBConst != 0 {
+		return ConstIndex(ix)
 	} else if i&opCmpArgBStack != 0 {
 		const l, r uint = 32 - (opTestArgBOff + opTestArgBStackLen), 32 - opTestArgBStackLen
 		return StackIndex(int32(i<<l) >> r)
@@ -233,7 +401,7 @@ func (i Instruction) jumpOffset() (offset int64, index Index) {
 	}
 
 	if i&opJumpConst != 0 {
-		return 0, constIndex((i & opJumpRelMask) >> opJumpRelOff)
+		return 0, ConstIndex((i & opJumpRelMask) >> opJumpRelOff)
 	} else if i&opJumpStack != 0 {
 		const l, r uint = 32 - (opJumpStackOff + opJumpStackLen), 32 - opJumpStackLen
 		return 0, StackIndex(int32(i<<l) >> r)
@@ -256,17 +424,29 @@ func (i Instruction) loadDst() Index {
 		regR = opXloadDstOff
 	}
 
-	if i&stackF == 0 {
-		return RegisterIndex(uint32(i>>regR) & opRegMask)
+	if i&stackF != 0 {
+		return StackIndex(int64(i<<(64-stackL)) >> (64 - stackR))
+	} else if i&instrExtendedBit != 0 {
+		return xloadRegister(uint64(i >> regR))
 	}
+	return RegisterIndex(uint32(i>>regR) & opRegMask)
+}
 
-	return StackIndex(int64(i<<(64-stackL)) >> (64 - stackR))
+// xloadRegister decodes the register or relative register in the low bits of an xload register operand field.
+func xloadRegister(field uint64) Index {
+	r := RegisterIndex(field & opRegMask)
+	if field&opXloadRelReg == 0 {
+		return r
+	}
+	const l, s uint = 64 - (opXloadRelOff + opXloadRelLen), 64 - opXloadRelLen
+	return RelRegisterIndex{Base: r, Offset: int(int64(field<<l) >> s)}
 }
 
 func (i Instruction) loadSrc() Index {
 	var (
 		stackF      = opLoadSrcStack
 		constF      = opLoadSrcConst
+		immF        = opLoadSrcImm
 		stackL uint = opLoadSrcOff + opLoadSrcLen
 		stackR uint = opLoadSrcLen
 		uiR    uint = opLoadSrcOff
@@ -275,73 +455,124 @@ func (i Instruction) loadSrc() Index {
 	if i&instrExtendedBit != 0 {
 		stackF = opXloadSrcStack
 		constF = opXloadSrcConst
+		immF = opXloadSrcImm
 		stackL, stackR = opXloadSrcOff+opXloadSrcLen, opXloadSrcLen
 		uiR = opXloadSrcOff
 	}
 
-	if i&stackF != 0 {
+	if i&immF == immF {
+		return ImmediateIndex(int64(i<<(64-stackL)) >> (64 - stackR))
+	} else if i&stackF != 0 {
 		return StackIndex(int64(i<<(64-stackL)) >> (64 - stackR))
 	} else if i&constF != 0 {
-		return constIndex((i >> uiR))
+		return ConstIndex((i >> uiR))
+	} else if i&instrExtendedBit != 0 {
+		return xloadRegister(uint64(i >> uiR))
 	}
 	return RegisterIndex((i >> uiR) & opRegMask)
 }
 
 func (i Instruction) String() string {
-	xbit := ""
-	if i.isExt() {
-		xbit = "x"
-	}
+	return i.Format(FormatOptions{})
+}
 
+// operands returns the decoded operands of the instruction. If the instruction writes to an index, that index is
+// returned as dst and omitted from args. Args are Index values, integers, or fmt.Stringers (e.g., a RoundingMode).
+func (i Instruction) operands() (dst Index, args []interface{}, ok bool) {
 	switch op := i.Opcode(); op {
 	// Binary
 	case OpAdd, OpSub, OpDiv, OpMul, OpPow, OpMod,
-		OpOr, OpAnd, OpXor, OpArithshift, OpBitshift:
-		return fmt.Sprint(xbit, op, i.regOut(), i.argA(), i.argB())
-		// Unary
-	case OpReserve:
-		return fmt.Sprint(xbit, op, i.argB())
+		OpOr, OpAnd, OpXor, OpArithshift, OpBitshift, OpSlice:
+		if i.isWide() {
+			return i.regOut(), []interface{}{i.argA(), i.argB()}, true
+		} else if i.isExt() {
+			if op == OpSlice {
+				return nil, nil, false
+			}
+			v := i.vecArgs()
+			return v.regOut(), []interface{}{i.vecCount(), v.argA(), v.argB()}, true
+		}
+		return i.regOut(), []interface{}{i.argA(), i.argB()}, true
+	// Unary (no extended forms)
+	case OpNeg, OpNot:
+		if i.isExt() {
+			return nil, nil, false
+		}
+		return i.regOut(), []interface{}{i.argB()}, true
+	case OpRound:
+		if i.isExt() {
+			return nil, nil, false
+		}
+		return i.regOut(), []interface{}{i.argB(), i.roundMode()}, true
+	// Stack
+	case OpReserve, OpAlloc, OpFrameAdjust:
+		if i.isExt() {
+			return nil, nil, false
+		}
+		return nil, []interface{}{i.sizeArg()}, true
 	case OpLoad:
-		return fmt.Sprint(xbit, op, i.loadDst(), i.loadSrc())
+		return i.loadDst(), []interface{}{i.loadSrc()}, true
+	case OpFrame:
+		if i&opFrameStore != 0 {
+			return i.frameIndex(), []interface{}{i.loadDst()}, true
+		}
+		return i.loadDst(), []interface{}{i.frameIndex()}, true
+	case OpTLS:
+		if i&opTLSStore != 0 {
+			return i.tlsSlot(), []interface{}{i.loadDst()}, true
+		}
+		return i.loadDst(), []interface{}{i.tlsSlot()}, true
 	case OpPop:
-		return fmt.Sprint(xbit, op, i.pushPopRange(), i.popArg())
+		if dst := i.popArg(); dst != nil {
+			return nil, []interface{}{i.pushPopRange(), dst}, true
+		}
+		return nil, []interface{}{i.pushPopRange()}, true
 	case OpPush:
-		return fmt.Sprint(xbit, op, i.pushPopRange(), i.pushArg())
-	case OpNeg, OpNot, OpRound, OpDefer, OpJoin:
-		// TODO: Fix per-unary string (e.g., load differs from neg)
-		return fmt.Sprint(xbit, op, i.regOut(), i.argA(), i.argB())
+		if i.isExt() {
+			return nil, []interface{}{i.pushList()}, true
+		}
+		return nil, []interface{}{i.pushPopRange(), i.pushArg()}, true
+	case OpDup, OpRotate:
+		return nil, []interface{}{i.pushPopRange(), i.stackOpArg()}, true
 	// Branch
 	case OpJump:
-		o, i := i.jumpOffset()
-		if i == nil {
-			return fmt.Sprint(xbit, op, o)
+		o, ix := i.jumpOffset()
+		if ix == nil {
+			return nil, []interface{}{o}, true
 		}
-		return fmt.Sprint(xbit, op, i)
+		return nil, []interface{}{ix}, true
 	case OpTest:
-		return fmt.Sprint(xbit, op, " (", i.cmpArgA(), i.cmpOp(), i.cmpArgB(), ") == ", i.cmpWant())
-	// Frame
-	case OpCall, OpReturn:
-		return fmt.Sprint(xbit, op, i.regOut(), i.argA(), i.argB())
+		return nil, []interface{}{i.cmpArgA(), i.cmpOp(), i.cmpArgB(), i.cmpWant()}, true
+	// Frame (formats are provisional until these are implemented)
+	case OpCall, OpReturn, OpDefer, OpFork, OpJoin:
+		return i.regOut(), []interface{}{i.argA(), i.argB()}, true
 	default:
-		return "<unknown opcode for instruction " + strconv.FormatUint(uint64(i), 16) + ">"
+		return nil, nil, false
 	}
 }
 
+// execer returns the function executing the instruction. Basic instructions index opFuncTable by their 5-bit opcode,
+// and extended instructions by their 12-bit opcode, so extended-only opcodes such as OpFrame have their own entries.
+// Extended opcodes past the end of opFuncTable are executed by invalidOp.
 func (i Instruction) execer() opFunc {
-	return opFuncTable[int(i>>1)&0x1F]
+	op := int(i>>1) & (0x1F | int(i&instrExtendedBit)*0xFFF)
+	if op >= len(opFuncTable) {
+		return invalidOp
+	}
+	return opFuncTable[op]
 }
 
-type compareOp uint
+type CompareOp uint
 
 const (
-	cmpLess compareOp = iota
-	cmpLequal
-	cmpEqual
-	cmpNotEqual
-	cmpGreater
-	cmpGequal
-	cmpIncludes
-	cmpExcludes
+	CmpLess CompareOp = iota
+	CmpLequal
+	CmpEqual
+	CmpNotEqual
+	CmpGreater
+	CmpGequal
+	CmpIncludes
+	CmpExcludes
 )
 
 type (
@@ -398,46 +629,46 @@ func equalTo(lhs, rhs Value) bool {
 	}
 }
 
-func (c compareOp) String() string {
+func (c CompareOp) String() string {
 	switch c {
-	case cmpLess:
+	case CmpLess:
 		return "<"
-	case cmpLequal:
+	case CmpLequal:
 		return "<="
-	case cmpEqual:
+	case CmpEqual:
 		return "=="
-	case cmpNotEqual:
+	case CmpNotEqual:
 		return "<>"
-	case cmpGreater:
+	case CmpGreater:
 		return ">"
-	case cmpGequal:
+	case CmpGequal:
 		return ">="
-	case cmpIncludes:
+	case CmpIncludes:
 		return "includes"
-	case cmpExcludes:
+	case CmpExcludes:
 		return "excludes"
 	default:
 		return "{bad-test-op: " + strconv.Itoa(int(c)) + "}"
 	}
 }
 
-func (c compareOp) comparator() (result bool, fn func(lhs, rhs Value) bool) {
+func (c CompareOp) comparator() (result bool, fn func(lhs, rhs Value) bool) {
 	switch c {
-	case cmpLess:
+	case CmpLess:
 		return true, lessThan
-	case cmpLequal:
+	case CmpLequal:
 		return true, lessEqual
-	case cmpEqual:
+	case CmpEqual:
 		return true, equalTo
-	case cmpNotEqual:
+	case CmpNotEqual:
 		return false, equalTo
-	case cmpGreater:
+	case CmpGreater:
 		return false, lessEqual
-	case cmpGequal:
+	case CmpGequal:
 		return false, lessThan
-	case cmpIncludes:
+	case CmpIncludes:
 		fallthrough
-	case cmpExcludes:
+	case CmpExcludes:
 		fallthrough
 	default:
 		return false, func(Value, Value) bool { panic(fmt.Errorf("bad comparator op: %d", c)) }
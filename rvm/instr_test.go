@@ -0,0 +1,695 @@
+package rvm
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// Round-trip tests for instruction encoders. Fields of up to 12 bits are tested exhaustively; wider fields are tested
+// at their bounds, around each power of two, and at random values in range.
+
+const roundTripSamples = 256
+
+func testRegisters() []Index {
+	ix := make([]Index, registerCount)
+	for i := range ix {
+		ix[i] = RegisterIndex(i)
+	}
+	return ix
+}
+
+func testSignedRange(bits uint) []int64 {
+	var (
+		max int64 = 1<<(bits-1) - 1
+		min int64 = -max - 1
+	)
+
+	if bits <= 12 {
+		vals := make([]int64, 0, max-min+1)
+		for i := min; i <= max; i++ {
+			vals = append(vals, i)
+		}
+		return vals
+	}
+
+	vals := []int64{min, min + 1, -1, 0, 1, max - 1, max}
+	for b := uint(1); b < bits-1; b++ {
+		vals = append(vals, 1<<b-1, 1<<b, -1<<b, -1<<b-1)
+	}
+	rng := rand.New(rand.NewSource(int64(bits)))
+	for i := 0; i < roundTripSamples; i++ {
+		vals = append(vals, min+rng.Int63n(max-min+1))
+	}
+	return vals
+}
+
+func testUnsignedRange(bits uint) []uint64 {
+	var max uint64 = 1<<bits - 1
+
+	if bits <= 12 {
+		vals := make([]uint64, 0, max+1)
+		for i := uint64(0); i <= max; i++ {
+			vals = append(vals, i)
+		}
+		return vals
+	}
+
+	vals := []uint64{0, 1, max - 1, max}
+	for b := uint(1); b < bits; b++ {
+		vals = append(vals, 1<<b-1, 1<<b)
+	}
+	rng := rand.New(rand.NewSource(int64(bits)))
+	for i := 0; i < roundTripSamples; i++ {
+		vals = append(vals, uint64(rng.Int63n(int64(max)+1)))
+	}
+	return vals
+}
+
+func testStackIndices(bits uint) []Index {
+	vals := testSignedRange(bits)
+	ix := make([]Index, len(vals))
+	for i, v := range vals {
+		ix[i] = StackIndex(v)
+	}
+	return ix
+}
+
+func testConstIndices(bits uint) []Index {
+	vals := testUnsignedRange(bits)
+	ix := make([]Index, len(vals))
+	for i, v := range vals {
+		ix[i] = ConstIndex(v)
+	}
+	return ix
+}
+
+func testImmediates(bits uint) []Index {
+	vals := testSignedRange(bits)
+	ix := make([]Index, len(vals))
+	for i, v := range vals {
+		ix[i] = ImmediateIndex(v)
+	}
+	return ix
+}
+
+func testIndices(sets ...[]Index) []Index {
+	var ix []Index
+	for _, set := range sets {
+		ix = append(ix, set...)
+	}
+	return ix
+}
+
+// testRoundTrip checks that instr decodes to dst and args and passes EncodeDecodeCheck.
+func testRoundTrip(t *testing.T, instr Instruction, dst Index, args ...interface{}) {
+	t.Helper()
+
+	gotDst, gotArgs, ok := instr.operands()
+	if !ok {
+		t.Fatalf("%016x: cannot decode operands", uint64(instr))
+	}
+
+	if gotDst != dst || len(gotArgs) != len(args) {
+		t.Fatalf("%016x: decoded (%v, %v); want (%v, %v)", uint64(instr), gotDst, gotArgs, dst, args)
+	}
+
+	for i := range args {
+		if gotArgs[i] != args[i] {
+			t.Fatalf("%016x: arg %d decoded as %#v; want %#v", uint64(instr), i, gotArgs[i], args[i])
+		}
+	}
+
+	if err := EncodeDecodeCheck(instr); err != nil {
+		t.Fatalf("%016x: %v", uint64(instr), err)
+	}
+}
+
+func testPanics(t *testing.T, desc string, fn func()) {
+	t.Helper()
+	defer func() {
+		if recover() == nil {
+			t.Errorf("%s: expected panic", desc)
+		}
+	}()
+	fn()
+}
+
+func TestBinaryRoundTrip(t *testing.T) {
+	var (
+		outs  = testIndices(testRegisters(), testStackIndices(opBinOutLen))
+		argAs = testIndices(testRegisters(), testStackIndices(opBinArgALen))
+		argBs = testIndices(testRegisters(), testStackIndices(opBinArgBStackLen), testConstIndices(opBinArgBLen),
+			testImmediates(opBinArgBImmLen))
+		fixed = []Index{RegisterIndex(63), StackIndex(-1), ConstIndex(1)}
+	)
+
+	for _, op := range []Opcode{OpAdd, OpMod, OpBitshift, OpSlice} {
+		for _, out := range outs {
+			for _, argA := range fixed[:2] {
+				for _, argB := range fixed {
+					testRoundTrip(t, Instruction(mkBinaryInstr(op, out, argA, argB)), out, argA, argB)
+				}
+			}
+		}
+		for _, argA := range argAs {
+			testRoundTrip(t, Instruction(mkBinaryInstr(op, fixed[1], argA, fixed[2])), fixed[1], argA, fixed[2])
+		}
+		for _, argB := range argBs {
+			testRoundTrip(t, Instruction(mkBinaryInstr(op, fixed[1], fixed[1], argB)), fixed[1], fixed[1], argB)
+		}
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < roundTripSamples*16; i++ {
+		out, argA, argB := outs[rng.Intn(len(outs))], argAs[rng.Intn(len(argAs))], argBs[rng.Intn(len(argBs))]
+		testRoundTrip(t, Instruction(mkBinaryInstr(OpSub, out, argA, argB)), out, argA, argB)
+	}
+
+	for _, ix := range []Index{StackIndex(1 << (opBinOutLen - 1)), StackIndex(-1<<(opBinOutLen-1) - 1)} {
+		testPanics(t, fmt.Sprint("out ", ix), func() { mkBinaryInstr(OpAdd, ix, RegisterIndex(0), RegisterIndex(0)) })
+	}
+	for _, ix := range []Index{StackIndex(1 << (opBinArgALen - 1)), StackIndex(-1<<(opBinArgALen-1) - 1)} {
+		testPanics(t, fmt.Sprint("argA ", ix), func() { mkBinaryInstr(OpAdd, RegisterIndex(0), ix, RegisterIndex(0)) })
+	}
+	for _, ix := range []Index{
+		StackIndex(1 << (opBinArgBStackLen - 1)),
+		StackIndex(-1<<(opBinArgBStackLen-1) - 1),
+		ConstIndex(1 << opBinArgBLen),
+		ImmediateIndex(1 << (opBinArgBImmLen - 1)),
+		ImmediateIndex(-1<<(opBinArgBImmLen-1) - 1),
+	} {
+		testPanics(t, fmt.Sprint("argB ", ix), func() { mkBinaryInstr(OpAdd, RegisterIndex(0), RegisterIndex(0), ix) })
+	}
+}
+
+// testVectorFits returns whether a vector operand range starting at ix fits in the registers.
+func testVectorFits(ix Index, n int) bool {
+	r, ok := ix.(RegisterIndex)
+	return !ok || int(r)+n <= registerCount
+}
+
+func TestVectorRoundTrip(t *testing.T) {
+	var (
+		outs  = []Index{RegisterIndex(0), RegisterIndex(32), StackIndex(-32), StackIndex(31)}
+		argBs = []Index{RegisterIndex(3), StackIndex(-512), ConstIndex(2047), ImmediateIndex(-256), ImmediateIndex(255)}
+	)
+
+	for _, op := range []Opcode{OpAdd, OpXor, OpBitshift} {
+		for _, n := range []int{1, 2, 32, 1<<opVecCountLen - 1} {
+			for _, out := range outs {
+				for _, argB := range argBs {
+					if !testVectorFits(out, n) || !testVectorFits(argB, n) {
+						continue
+					}
+					instr := Instruction(mkVectorInstr(op, n, out, StackIndex(-1), argB))
+					if !instr.isVector() || instr.Opcode() != op {
+						t.Fatalf("%v: not a vector %v", instr, op)
+					}
+					testRoundTrip(t, instr, out, n, StackIndex(-1), argB)
+				}
+			}
+		}
+	}
+
+	for _, n := range []int{0, 1 << opVecCountLen} {
+		testPanics(t, fmt.Sprint("count ", n), func() {
+			mkVectorInstr(OpAdd, n, RegisterIndex(3), RegisterIndex(3), RegisterIndex(3))
+		})
+	}
+	testPanics(t, "register range", func() {
+		mkVectorInstr(OpAdd, 2, RegisterIndex(3), RegisterIndex(3), RegisterIndex(63))
+	})
+	testPanics(t, "slice", func() {
+		mkVectorInstr(OpSlice, 2, RegisterIndex(3), RegisterIndex(3), RegisterIndex(5))
+	})
+}
+
+func TestUnaryRoundTrip(t *testing.T) {
+	var (
+		outs = testIndices(testRegisters(), testStackIndices(opBinOutLen))
+		args = testIndices(testRegisters(), testStackIndices(opBinArgBStackLen), testConstIndices(opBinArgBLen),
+			testImmediates(opBinArgBImmLen))
+	)
+
+	for _, op := range []Opcode{OpNeg, OpNot} {
+		for _, out := range outs {
+			testRoundTrip(t, newUnary(op, out, ConstIndex(1)), out, ConstIndex(1))
+		}
+		for _, arg := range args {
+			testRoundTrip(t, newUnary(op, RegisterIndex(3), arg), RegisterIndex(3), arg)
+		}
+	}
+	for mode := RoundTruncate; mode <= RoundCeil; mode++ {
+		for _, arg := range args {
+			testRoundTrip(t, newRound(StackIndex(-1), arg, mode), StackIndex(-1), arg, mode)
+		}
+	}
+
+	testPanics(t, "op", func() { newUnary(OpRound, RegisterIndex(3), RegisterIndex(3)) })
+	testPanics(t, "mode", func() { newRound(RegisterIndex(3), RegisterIndex(3), RoundCeil+1) })
+	testPanics(t, "argB", func() { newUnary(OpNeg, RegisterIndex(3), ConstIndex(1<<opBinArgBLen)) })
+
+	// Unary instructions have no extended form.
+	if _, _, ok := (newUnary(OpNeg, RegisterIndex(3), RegisterIndex(3)) | instrExtendedBit).operands(); ok {
+		t.Error("extended neg decoded")
+	}
+}
+
+func TestSizeRoundTrip(t *testing.T) {
+	args := testIndices(testRegisters(), testStackIndices(opBinArgBStackLen), testConstIndices(opBinArgBLen),
+		testImmediates(opSizeImmLen))
+
+	for _, op := range []Opcode{OpReserve, OpAlloc, OpFrameAdjust} {
+		for _, arg := range args {
+			instr := Instruction(mkSizeInstr(op, arg))
+			imm, ok := arg.(ImmediateIndex)
+			if got, want := instr&opSizeImm != 0, ok && !BinaryArgBImmRange.Contains(int64(imm)); got != want {
+				t.Errorf("%v: wide immediate = %t; want %t", instr, got, want)
+			}
+			testRoundTrip(t, instr, nil, arg)
+		}
+	}
+
+	testPanics(t, "imm", func() { newReserve(ImmediateIndex(SizeImmRange.Max + 1)) })
+
+	// Immediates that fit argB are only canonical in argB.
+	if err := EncodeDecodeCheck(Instruction(opcodeBits(OpReserve)) | opSizeImm | 1<<opSizeImmOff); err == nil {
+		t.Error("expected error for small wide immediate")
+	}
+}
+
+func TestWideRoundTrip(t *testing.T) {
+	var (
+		args  = testIndices(testRegisters(), testStackIndices(opWideValLen))
+		argBs = testIndices(args, testConstIndices(opWideValLen), testImmediates(opWideValLen))
+	)
+
+	for _, op := range []Opcode{OpAdd, OpXor, OpSlice} {
+		for _, out := range args {
+			instr := Instruction(mkWideInstr(op, out, StackIndex(-1), ConstIndex(1)))
+			if !instr.isWide() || instr.isVector() || instr.Opcode() != op {
+				t.Fatalf("%v: not a wide %v", instr, op)
+			}
+			testRoundTrip(t, instr, out, StackIndex(-1), ConstIndex(1))
+		}
+		for _, argA := range args {
+			testRoundTrip(t, Instruction(mkWideInstr(op, RegisterIndex(3), argA, argA)), RegisterIndex(3), argA, argA)
+		}
+		for _, argB := range argBs {
+			testRoundTrip(t, Instruction(mkWideInstr(op, RegisterIndex(3), RegisterIndex(4), argB)), RegisterIndex(3),
+				RegisterIndex(4), argB)
+		}
+	}
+
+	testPanics(t, "stack", func() {
+		mkWideInstr(OpAdd, StackIndex(WideStackRange.Max+1), RegisterIndex(3), RegisterIndex(3))
+	})
+	testPanics(t, "const", func() {
+		mkWideInstr(OpAdd, RegisterIndex(3), RegisterIndex(3), ConstIndex(WideConstRange.Max+1))
+	})
+	testPanics(t, "argA const", func() { mkWideInstr(OpAdd, RegisterIndex(3), ConstIndex(0), RegisterIndex(3)) })
+	testPanics(t, "neg", func() { newWideBinary(OpNeg, RegisterIndex(3), RegisterIndex(3), RegisterIndex(3)) })
+}
+
+func TestXtestRoundTrip(t *testing.T) {
+	args := testIndices(testRegisters(), testStackIndices(opWideValLen), testConstIndices(opWideValLen),
+		testImmediates(opWideValLen))
+
+	for oper := CmpLess; oper <= CmpExcludes; oper++ {
+		for _, want := range []bool{false, true} {
+			for _, arg := range args {
+				testRoundTrip(t, Instruction(mkXtestInstr(oper, want, arg, StackIndex(-1))), nil, arg, oper,
+					StackIndex(-1), want)
+				testRoundTrip(t, Instruction(mkXtestInstr(oper, want, ConstIndex(1), arg)), nil, ConstIndex(1), oper,
+					arg, want)
+			}
+		}
+	}
+
+	testPanics(t, "stack", func() { mkXtestInstr(CmpLess, true, StackIndex(WideStackRange.Min-1), RegisterIndex(0)) })
+	testPanics(t, "imm", func() { mkXtestInstr(CmpLess, true, RegisterIndex(0), ImmediateIndex(WideImmRange.Max+1)) })
+}
+
+func TestTestRoundTrip(t *testing.T) {
+	var (
+		argAs = testIndices(testRegisters(), testStackIndices(opTestArgAStackLen), testConstIndices(opTestArgALen))
+		argBs = testIndices(testRegisters(), testStackIndices(opTestArgBStackLen), testConstIndices(opTestArgBLen))
+	)
+
+	for oper := CmpLess; oper <= CmpExcludes; oper++ {
+		for _, want := range []bool{false, true} {
+			for _, argA := range argAs {
+				argB := StackIndex(-1)
+				testRoundTrip(t, Instruction(mkTestInstr(oper, want, argA, argB)), nil, argA, oper, argB, want)
+			}
+			for _, argB := range argBs {
+				argA := ConstIndex(1)
+				testRoundTrip(t, Instruction(mkTestInstr(oper, want, argA, argB)), nil, argA, oper, argB, want)
+			}
+		}
+	}
+
+	for _, ix := range []Index{
+		StackIndex(1 << (opTestArgAStackLen - 1)),
+		StackIndex(-1<<(opTestArgAStackLen-1) - 1),
+		ConstIndex(1 << opTestArgALen),
+	} {
+		testPanics(t, fmt.Sprint("argA ", ix), func() { mkTestInstr(CmpLess, true, ix, RegisterIndex(0)) })
+		testPanics(t, fmt.Sprint("argB ", ix), func() { mkTestInstr(CmpLess, true, RegisterIndex(0), ix) })
+	}
+}
+
+func TestJumpRoundTrip(t *testing.T) {
+	for _, off := range testSignedRange(opJumpLitLen) {
+		testRoundTrip(t, Instruction(mkJumpInstr(int(off), nil)), nil, off)
+	}
+
+	for _, src := range testIndices(testRegisters(), testStackIndices(opJumpStackLen), testConstIndices(opJumpRelLen)) {
+		testRoundTrip(t, Instruction(mkJumpInstr(0, src)), nil, src)
+	}
+
+	testPanics(t, "offset", func() { mkJumpInstr(1<<(opJumpLitLen-1), nil) })
+	testPanics(t, "stack", func() { mkJumpInstr(0, StackIndex(1<<(opJumpStackLen-1))) })
+	testPanics(t, "const", func() { mkJumpInstr(0, ConstIndex(1<<opJumpRelLen)) })
+}
+
+func TestLoadRoundTrip(t *testing.T) {
+	var (
+		dsts = testIndices(testRegisters(), testStackIndices(opLoadDstLen))
+		srcs = testIndices(testRegisters(), testStackIndices(opLoadSrcLen), testConstIndices(opLoadSrcLen),
+			testImmediates(opLoadSrcLen))
+	)
+
+	for _, dst := range dsts {
+		testRoundTrip(t, Instruction(mkLoadInstr(dst, ConstIndex(1))), dst, ConstIndex(1))
+	}
+	for _, src := range srcs {
+		testRoundTrip(t, Instruction(mkLoadInstr(StackIndex(-1), src)), StackIndex(-1), src)
+	}
+
+	testPanics(t, "dst", func() { mkLoadInstr(StackIndex(1<<(opLoadDstLen-1)), RegisterIndex(0)) })
+	testPanics(t, "src", func() { mkLoadInstr(RegisterIndex(0), ConstIndex(1<<opLoadSrcLen)) })
+}
+
+func TestTLSRoundTrip(t *testing.T) {
+	args := testIndices(testRegisters(), testStackIndices(opLoadDstLen))
+	for _, arg := range args {
+		for _, slot := range []int{0, 1, int(TLSRange.Max)} {
+			testRoundTrip(t, Instruction(mkTLSInstr(false, slot, arg)), arg, TLSIndex(slot))
+			testRoundTrip(t, Instruction(mkTLSInstr(true, slot, arg)), TLSIndex(slot), arg)
+		}
+	}
+
+	testPanics(t, "slot", func() { mkTLSInstr(false, int(TLSRange.Max)+1, RegisterIndex(3)) })
+	testPanics(t, "const", func() { mkTLSInstr(true, 0, ConstIndex(0)) })
+	testPanics(t, "immediate", func() { mkTLSInstr(true, 0, ImmediateIndex(0)) })
+}
+
+func TestFrameRoundTrip(t *testing.T) {
+	var (
+		args = testIndices(testRegisters(), testStackIndices(opLoadDstLen))
+		fxs  = []FrameIndex{
+			{1, 0},
+			{int(FrameDepthRange.Max), StackIndex(FrameStackRange.Min)},
+			{2, StackIndex(FrameStackRange.Max)},
+			{3, -1},
+		}
+	)
+	for _, arg := range args {
+		for _, fx := range fxs {
+			testRoundTrip(t, Instruction(mkFrameInstr(false, fx, arg)), arg, fx)
+			testRoundTrip(t, Instruction(mkFrameInstr(true, fx, arg)), fx, arg)
+		}
+	}
+
+	testPanics(t, "depth 0", func() { mkFrameInstr(false, FrameIndex{0, 0}, RegisterIndex(3)) })
+	testPanics(t, "depth", func() { mkFrameInstr(false, FrameIndex{int(FrameDepthRange.Max) + 1, 0}, RegisterIndex(3)) })
+	testPanics(t, "index", func() { mkFrameInstr(false, FrameIndex{1, StackIndex(FrameStackRange.Max + 1)}, RegisterIndex(3)) })
+	testPanics(t, "const", func() { mkFrameInstr(true, FrameIndex{1, 0}, ConstIndex(0)) })
+}
+
+func TestXloadRoundTrip(t *testing.T) {
+	var (
+		dsts = testIndices(testRegisters(), testStackIndices(opXloadDstLen))
+		srcs = testIndices(testRegisters(), testStackIndices(opXloadSrcLen), testConstIndices(opXloadSrcLen),
+			testImmediates(opXloadSrcLen))
+	)
+
+	for _, dst := range dsts {
+		testRoundTrip(t, Instruction(mkXloadInstr(dst, ConstIndex(1))), dst, ConstIndex(1))
+	}
+	for _, src := range srcs {
+		testRoundTrip(t, Instruction(mkXloadInstr(StackIndex(-1), src)), StackIndex(-1), src)
+	}
+
+	rels := []Index{
+		RelRegisterIndex{RegisterIndex(3), 0},
+		RelRegisterIndex{RegisterIndex(63), int(RelRegOffsetRange.Min)},
+		RelRegisterIndex{RegisterIndex(0), int(RelRegOffsetRange.Max)},
+	}
+	for _, rel := range rels {
+		testRoundTrip(t, Instruction(mkXloadInstr(rel, StackIndex(-1))), rel, StackIndex(-1))
+		testRoundTrip(t, Instruction(mkXloadInstr(RegisterIndex(3), rel)), RegisterIndex(3), rel)
+	}
+
+	testPanics(t, "rel offset", func() {
+		mkXloadInstr(RelRegisterIndex{RegisterIndex(3), int(RelRegOffsetRange.Max + 1)}, RegisterIndex(0))
+	})
+	testPanics(t, "basic load rel", func() { mkLoadInstr(RelRegisterIndex{RegisterIndex(3), 0}, RegisterIndex(0)) })
+	testPanics(t, "dst", func() { mkXloadInstr(StackIndex(1<<(opXloadDstLen-1)), RegisterIndex(0)) })
+	testPanics(t, "src", func() { mkXloadInstr(RegisterIndex(0), ConstIndex(1<<opXloadSrcLen)) })
+}
+
+func TestMove(t *testing.T) {
+	cases := []struct {
+		dst, src Index
+		ext      bool
+	}{
+		{RegisterIndex(3), ImmediateIndex(LoadSrcImmRange.Max), false},
+		{StackIndex(LoadDstStackRange.Min), ConstIndex(LoadSrcConstRange.Max), false},
+		{RegisterIndex(3), ImmediateIndex(LoadSrcImmRange.Max + 1), true},
+		{StackIndex(LoadDstStackRange.Max + 1), RegisterIndex(3), true},
+		{RelRegisterIndex{RegisterIndex(3), 1}, ConstIndex(0), true},
+	}
+	for _, c := range cases {
+		instr, err := EncodeMove(mustOperand(c.dst), mustOperand(c.src))
+		if err != nil {
+			t.Fatalf("EncodeMove(%v, %v): %v", c.dst, c.src, err)
+		}
+		if instr.isExt() != c.ext {
+			t.Errorf("EncodeMove(%v, %v) = %v; extended = %t, want %t", c.dst, c.src, instr, instr.isExt(), c.ext)
+		}
+		testRoundTrip(t, instr, c.dst, c.src)
+	}
+
+	if _, err := EncodeMove(Reg(3), Imm(int(XloadSrcImmRange.Max+1))); err == nil {
+		t.Error("EncodeMove(%3, out of range) = nil error; want error")
+	}
+}
+
+func TestLongJump(t *testing.T) {
+	consts := []Value{"x", []Value{}}
+	instr, got := mustLongJump(t, int(JumpOffsetRange.Max), consts)
+	if len(got) != len(consts) {
+		t.Errorf("EncodeLongJump(max) consts = %v; want %v", got, consts)
+	}
+	testRoundTrip(t, instr, nil, JumpOffsetRange.Max)
+
+	far := int(JumpOffsetRange.Min) - 1
+	instr, consts = mustLongJump(t, far, consts)
+	if want := []Value{"x", []Value{}, Int(far)}; len(consts) != len(want) || consts[2] != want[2] {
+		t.Errorf("EncodeLongJump(far) consts = %v; want %v", consts, want)
+	}
+	testRoundTrip(t, instr, nil, ConstIndex(2))
+
+	// The constant is reused by later jumps of the same offset.
+	if instr, got = mustLongJump(t, far, consts); len(got) != len(consts) {
+		t.Errorf("EncodeLongJump(far) again consts = %v; want %v", got, consts)
+	}
+	testRoundTrip(t, instr, nil, ConstIndex(2))
+}
+
+func TestLongJumpSharedPool(t *testing.T) {
+	pool := []Value{Int(1), Int(2)}
+	far := int(JumpOffsetRange.Max) + 1
+	instr, consts := mustLongJump(t, far, pool[:1])
+	if pool[1] != Int(2) {
+		t.Errorf("pool[1] = %v; want 2", pool[1])
+	}
+	if len(consts) != 2 || consts[1] != Int(far) {
+		t.Errorf("consts = %v; want [1 %d]", consts, far)
+	}
+	testRoundTrip(t, instr, nil, ConstIndex(1))
+}
+
+func mustLongJump(t *testing.T, offset int, consts []Value) (Instruction, []Value) {
+	t.Helper()
+	instr, consts, err := EncodeLongJump(offset, consts)
+	if err != nil {
+		t.Fatalf("EncodeLongJump(%d): %v", offset, err)
+	}
+	return instr, consts
+}
+
+func TestPushPopRoundTrip(t *testing.T) {
+	var (
+		targets = testIndices(testStackIndices(opPushPopTargetLen), testConstIndices(opPushPopTargetLen))
+		imms    []Index
+	)
+	for _, v := range testSignedRange(opPushPopTargetLen) {
+		imms = append(imms, ImmediateIndex(v))
+	}
+	targets = append(targets, imms...)
+
+	for n := 1; n <= 1<<opPushPopRangeLen; n++ {
+		for r := 0; r+n <= registerCount; r++ {
+			testRoundTrip(t, Instruction(mkPushPop(OpPush, n, RegisterIndex(r))), nil, n, RegisterIndex(r))
+			testRoundTrip(t, Instruction(mkPushPop(OpPop, n, RegisterIndex(r))), nil, n, RegisterIndex(r))
+		}
+		testRoundTrip(t, Instruction(mkPushPop(OpPop, n, nil)), nil, n)
+	}
+
+	for _, arg := range targets {
+		testRoundTrip(t, Instruction(mkPushPop(OpPush, 3, arg)), nil, 3, arg)
+		if _, ok := arg.(StackIndex); ok {
+			testRoundTrip(t, Instruction(mkPushPop(OpPop, 3, arg)), nil, 3, arg)
+		}
+	}
+
+	testPanics(t, "range 0", func() { mkPushPop(OpPush, 0, RegisterIndex(0)) })
+	testPanics(t, "range 65", func() { mkPushPop(OpPush, 65, RegisterIndex(0)) })
+	testPanics(t, "register range", func() { mkPushPop(OpPush, 2, RegisterIndex(registerCount-1)) })
+	testPanics(t, "stack", func() { mkPushPop(OpPop, 1, StackIndex(1<<(opPushPopTargetLen-1))) })
+	testPanics(t, "const", func() { mkPushPop(OpPush, 1, ConstIndex(1<<opPushPopTargetLen)) })
+}
+
+func TestEncodeDecodeCheck(t *testing.T) {
+	valid := []Instruction{
+		newUnary(OpNeg, StackIndex(-32), ConstIndex(2047)),
+		newRound(RegisterIndex(63), StackIndex(511), RoundCeil),
+		newAlloc(StackIndex(-512)),
+		newXpush(ConstIndex(1<<32 - 1)),
+		newStackOp(OpRotate, 64, -1<<17),
+		newStackOp(OpDup, 1, 1<<17-1),
+		newVector(OpMul, 4, RegisterIndex(3), StackIndex(-4), ImmediateIndex(2)),
+		newWideBinary(OpSlice, StackIndex(-8192), RegisterIndex(3), ConstIndex(16383)),
+		newXtest(CmpEqual, false, ImmediateIndex(-8192), StackIndex(8191)),
+	}
+	for _, instr := range valid {
+		if err := EncodeDecodeCheck(instr); err != nil {
+			t.Errorf("%v: %v", instr, err)
+		}
+	}
+
+	invalid := []Instruction{
+		// Stray bits outside of any operand field.
+		newPushPop(OpPop, 1, nil) | 1<<opPushPopTargetOff,
+		newPushPop(OpPush, 1, RegisterIndex(1)) | 1<<(opPushPopTargetOff+6),
+		// Out of range rounding mode.
+		Instruction(mkUnaryInstr(OpRound, RegisterIndex(0), RegisterIndex(0))) | 0x3F<<opBinArgAOff,
+		// Vector instruction with an opcode in its operand word.
+		newVector(OpAdd, 2, RegisterIndex(3), RegisterIndex(3), RegisterIndex(5)) | Instruction(opcodeBits(OpSub))<<opVecArgsOff,
+		// Stray bits between the compare op and operands of an xtest.
+		newXtest(CmpLess, true, RegisterIndex(3), RegisterIndex(4)) | 1<<20,
+		// Opcodes without a defined encoding.
+		Instruction(opcodeBits(OpCall)),
+		Instruction(opcodeBits(opCount)),
+		Instruction(xopcodeBits(opCount)) | instrExtendedBit,
+	}
+	for _, instr := range invalid {
+		if err := EncodeDecodeCheck(instr); err == nil {
+			t.Errorf("%016x: expected error", uint64(instr))
+		} else {
+			t.Logf("%016x: %v", uint64(instr), err)
+		}
+	}
+}
+
+func TestOperandRangeErrors(t *testing.T) {
+	reg := RegisterIndex(registerCount)
+	tests := []struct {
+		desc string
+		want interface{}
+		fn   func()
+	}{
+		{"binary out", InvalidRegister(reg), func() { mkBinaryInstr(OpAdd, reg, RegisterIndex(0), RegisterIndex(0)) }},
+		{"binary argA", InvalidRegister(reg), func() { mkBinaryInstr(OpAdd, RegisterIndex(0), reg, RegisterIndex(0)) }},
+		{"binary argB", InvalidRegister(reg), func() { mkBinaryInstr(OpAdd, RegisterIndex(0), RegisterIndex(0), reg) }},
+		{"test argA", InvalidRegister(-1), func() { mkTestInstr(CmpLess, true, RegisterIndex(-1), RegisterIndex(0)) }},
+		{"jump", InvalidRegister(reg), func() { mkJumpInstr(0, reg) }},
+		{"load dst", InvalidRegister(reg), func() { mkLoadInstr(reg, RegisterIndex(0)) }},
+		{"xload src", InvalidRegister(reg), func() { mkXloadInstr(RegisterIndex(0), reg) }},
+		{"push", InvalidRegister(reg), func() { mkPushPop(OpPush, 1, reg) }},
+		{"binary out stack", InvalidStackIndex(BinaryOutStackRange.Max + 1),
+			func() {
+				mkBinaryInstr(OpAdd, StackIndex(BinaryOutStackRange.Max+1), RegisterIndex(0), RegisterIndex(0))
+			}},
+		{"binary argB stack", InvalidStackIndex(BinaryArgBStackRange.Min - 1),
+			func() {
+				mkBinaryInstr(OpAdd, RegisterIndex(0), RegisterIndex(0), StackIndex(BinaryArgBStackRange.Min-1))
+			}},
+		{"load dst stack", InvalidStackIndex(LoadDstStackRange.Max + 1),
+			func() { mkLoadInstr(StackIndex(LoadDstStackRange.Max+1), RegisterIndex(0)) }},
+		{"binary argB const", InvalidConstIndex(-1),
+			func() { mkBinaryInstr(OpAdd, RegisterIndex(0), RegisterIndex(0), ConstIndex(-1)) }},
+	}
+
+	for _, c := range tests {
+		func() {
+			defer func() {
+				if rc := recover(); rc != c.want {
+					t.Errorf("%s: panic = %#v; want %#v", c.desc, rc, c.want)
+				}
+			}()
+			c.fn()
+		}()
+	}
+}
+
+func TestOperandEncoders(t *testing.T) {
+	for _, ix := range []Index{nil, RegisterIndex(3), StackIndex(-4), ConstIndex(5), ImmediateIndex(-6)} {
+		o, ok := OperandOf(ix)
+		if !ok || o.Index() != ix {
+			t.Errorf("OperandOf(%v) = %v, %t; want operand for %v", ix, o, ok, ix)
+		}
+	}
+
+	same := []struct {
+		got  func() (Instruction, error)
+		want Instruction
+	}{
+		{func() (Instruction, error) { return EncodeBinary(OpAdd, Reg(4), Stack(-20), Const(1)) },
+			newBinary(OpAdd, RegisterIndex(4), StackIndex(-20), ConstIndex(1))},
+		{func() (Instruction, error) { return EncodeXload(Stack(-1000), Const(70000)) },
+			newXload(StackIndex(-1000), ConstIndex(70000))},
+		{func() (Instruction, error) { return EncodeJump(-5, Operand{}) }, newJump(-5, nil)},
+		{func() (Instruction, error) { return EncodePushPop(OpPop, 2, Operand{}) }, newPushPop(OpPop, 2, nil)},
+		{func() (Instruction, error) { return EncodePushPop(OpPush, 2, Imm(-7)) }, newPushPop(OpPush, 2, ImmediateIndex(-7))},
+		{func() (Instruction, error) { return EncodeXpush(Const(9)) }, newXpush(ConstIndex(9))},
+	}
+	for _, c := range same {
+		if got, err := c.got(); err != nil || got != c.want {
+			t.Errorf("encoded %v, %v; want %v", got, err, c.want)
+		}
+	}
+
+	invalid := []func() (Instruction, error){
+		func() (Instruction, error) { return EncodeBinary(OpTest, Reg(0), Reg(0), Reg(0)) },
+		func() (Instruction, error) { return EncodeBinary(OpAdd, Const(0), Reg(0), Reg(0)) },
+		func() (Instruction, error) { return EncodeBinary(OpAdd, Reg(0), Reg(0), Imm(256)) },
+		func() (Instruction, error) { return EncodeBinary(OpAdd, Reg(registerCount), Reg(0), Reg(0)) },
+		func() (Instruction, error) { return EncodeUnary(OpNeg, Operand{}, Reg(0)) },
+		func() (Instruction, error) { return EncodeLoad(Reg(0), Imm(1<<15)) },
+		func() (Instruction, error) { return EncodeJump(1, Reg(0)) },
+		func() (Instruction, error) { return EncodePushPop(OpPush, 1, Operand{}) },
+		func() (Instruction, error) { return EncodePushPop(OpPop, 1, Const(0)) },
+		func() (Instruction, error) { return EncodeXpush(Reg(0)) },
+		func() (Instruction, error) { return EncodeTest(CmpLess, true, Operand{Kind: 99}, Reg(0)) },
+	}
+	for i, fn := range invalid {
+		if instr, err := fn(); err == nil {
+			t.Errorf("invalid[%d]: encoded %v; want error", i, instr)
+		}
+	}
+}
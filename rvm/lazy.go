@@ -0,0 +1,47 @@
+package rvm
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Lazy is a constant whose value is computed the first time it is loaded from a constants table. The result is cached
+// for subsequent loads, including loads from other threads sharing the same constants.
+//
+// If the function panics, the panic propagates to the loading thread as a *HostPanic and the next load calls the
+// function again.
+type Lazy struct {
+	done uint32
+	mu   sync.Mutex
+	fn   func() Value
+	v    Value
+}
+
+// NewLazy returns a Lazy constant that computes its value by calling fn.
+func NewLazy(fn func() Value) *Lazy {
+	return &Lazy{fn: fn}
+}
+
+// load returns the constant's value for a thread loading it, wrapping any panic from the function in a *HostPanic.
+func (l *Lazy) load() Value {
+	if atomic.LoadUint32(&l.done) == 1 {
+		return l.v
+	}
+	defer wrapHostPanic()
+	return l.Value()
+}
+
+// Value returns the constant's value, computing it if it has not been computed yet.
+func (l *Lazy) Value() Value {
+	if atomic.LoadUint32(&l.done) == 1 {
+		return l.v
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.done == 0 {
+		l.v = l.fn()
+		atomic.StoreUint32(&l.done, 1)
+	}
+	return l.v
+}
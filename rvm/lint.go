@@ -0,0 +1,228 @@
+package rvm
+
+import (
+	"fmt"
+	"sort"
+)
+
+// LintWarning is a suspicious register or stack access found by Lint.
+type LintWarning struct {
+	PC    int // Code index of the instruction
+	Instr Instruction
+	Index Index
+	Msg   string
+}
+
+func (w LintWarning) String() string {
+	return fmt.Sprintf("%d: %v: %v %s", w.PC, w.Instr, w.Index, w.Msg)
+}
+
+// Lint checks code for register and stack accesses that usually point to a code generator bug:
+//
+//   - A register is written but never read.
+//   - A register is read but never written. The special registers (%pc, %ebp, %esp) and any registers listed in inputs
+//     are assumed to be set before the code runs.
+//   - A stack slot is read before it is pushed.
+//   - A register past %63 is read or written, such as by a register range or vector that runs off the end of the
+//     register file.
+//
+// Register checks ignore control flow: a register counts as read or written if any instruction reads or writes it.
+// The stack check assumes the frame starts with an empty stack and follows the code only until the first branch,
+// jump target, or instruction whose effect on the stack is not known from its encoding.
+//
+// Warnings are returned in code order. If the code ends with a truncated extended instruction, it is ignored.
+func Lint(code []uint32, inputs ...RegisterIndex) []LintWarning {
+	type access struct {
+		pc    int
+		instr Instruction
+		ok    bool
+	}
+
+	var (
+		warnings       []LintWarning
+		reads, writes  [registerCount]access
+		assumed        [registerCount]bool
+		targets        = lintJumpTargets(code)
+		depth, tracked = 0, true
+	)
+
+	for _, r := range inputs {
+		if RegisterRange.Contains(int64(r)) {
+			assumed[r] = true
+		}
+	}
+
+	for pc := 0; pc < len(code); {
+		instr, n, ok := DecodeInstruction(code[pc:])
+		if !ok {
+			break
+		}
+		if targets[pc] && pc != 0 {
+			tracked = false
+		}
+
+		rd, wr := instr.accesses()
+		for _, ix := range rd {
+			switch ix := ix.(type) {
+			case RegisterIndex:
+				if !RegisterRange.Contains(int64(ix)) {
+					warnings = append(warnings, LintWarning{pc, instr, ix, "register out of range"})
+				} else if !reads[ix].ok {
+					reads[ix] = access{pc, instr, true}
+				}
+			case StackIndex:
+				if tracked && (ix >= 0 && int(ix) >= depth || ix < 0 && int(-ix) > depth) {
+					warnings = append(warnings, LintWarning{pc, instr, ix, "read before push"})
+				}
+			}
+		}
+		for _, ix := range wr {
+			if ix, ok := ix.(RegisterIndex); !ok {
+				continue
+			} else if !RegisterRange.Contains(int64(ix)) {
+				warnings = append(warnings, LintWarning{pc, instr, ix, "register out of range"})
+			} else if !writes[ix].ok {
+				writes[ix] = access{pc, instr, true}
+			}
+		}
+
+		if tracked {
+			depth, tracked = instr.stackEffect(depth)
+		}
+		pc += n
+	}
+
+	for r := RegisterIndex(specialRegisters); r < registerCount; r++ {
+		if w := writes[r]; w.ok && !reads[r].ok {
+			warnings = append(warnings, LintWarning{w.pc, w.instr, r, "written but never read"})
+		} else if rd := reads[r]; rd.ok && !w.ok && !assumed[r] {
+			warnings = append(warnings, LintWarning{rd.pc, rd.instr, r, "read but never written"})
+		}
+	}
+
+	sort.SliceStable(warnings, func(i, j int) bool { return warnings[i].PC < warnings[j].PC })
+	return warnings
+}
+
+// lintJumpTargets returns the code indices targeted by literal jumps in code.
+func lintJumpTargets(code []uint32) map[int]bool {
+	targets := map[int]bool{}
+	for pc := 0; pc < len(code); {
+		instr, n, ok := DecodeInstruction(code[pc:])
+		if !ok {
+			break
+		}
+		pc += n
+		if instr.Opcode() != OpJump {
+			continue
+		}
+		if off, ix := instr.jumpOffset(); ix == nil {
+			targets[pc+int(off)] = true
+		}
+	}
+	return targets
+}
+
+// accesses returns the registers and stack slots read and written by the instruction. Ranges of registers and stack
+// slots used by push, pop, and vector instructions are expanded to each index in the range. A relative register also
+// reads its base register; the register it refers to cannot be known from the encoding.
+func (i Instruction) accesses() (reads, writes []Index) {
+	dst, args, ok := i.operands()
+	if !ok {
+		return nil, nil
+	}
+
+	if i.isVector() {
+		n := args[0].(int)
+		reads = append(indexRange(args[1].(Index), n), indexRange(args[2].(Index), n)...)
+		return reads, indexRange(dst, n)
+	}
+
+	switch i.Opcode() {
+	case OpPush:
+		if !i.isExt() {
+			reads = indexRange(args[1].(Index), args[0].(int))
+		}
+		return reads, nil
+	case OpPop:
+		if len(args) > 1 {
+			writes = indexRange(args[1].(Index), args[0].(int))
+		}
+		return nil, writes
+	}
+
+	for _, arg := range args {
+		if ix, ok := arg.(Index); ok {
+			reads = append(reads, ix)
+		}
+		if ix, ok := arg.(RelRegisterIndex); ok {
+			reads = append(reads, ix.Base)
+		}
+	}
+	if dst != nil {
+		writes = append(writes, dst)
+	}
+	if ix, ok := dst.(RelRegisterIndex); ok {
+		reads = append(reads, ix.Base)
+	}
+	return reads, writes
+}
+
+// indexRange returns the n register or stack indices starting at ix. A register range is clamped to the register
+// file: if it runs past %63, the first register past the end is returned in place of the rest. Other indices are
+// returned as-is.
+func indexRange(ix Index, n int) []Index {
+	var r []Index
+	switch ix := ix.(type) {
+	case RegisterIndex:
+		for j := 0; j < n; j++ {
+			r = append(r, ix+RegisterIndex(j))
+			if ix+RegisterIndex(j) >= registerCount {
+				break
+			}
+		}
+	case StackIndex:
+		for j := 0; j < n; j++ {
+			r = append(r, ix+StackIndex(j))
+		}
+	default:
+		r = append(r, ix)
+	}
+	return r
+}
+
+// stackEffect returns the stack depth after executing the instruction with the given depth. It returns false if the
+// instruction branches or its effect on the stack cannot be known from its encoding.
+func (i Instruction) stackEffect(depth int) (int, bool) {
+	switch i.Opcode() {
+	case OpPush:
+		if i.isExt() {
+			return depth, false
+		}
+		return depth + i.pushPopRange(), true
+	case OpPop:
+		if r, ok := i.popArg().(RegisterIndex); ok && r < specialRegisters {
+			return depth, false
+		}
+		return depth - i.pushPopRange(), true
+	case OpDup:
+		return depth + i.pushPopRange(), true
+	case OpRotate, OpReserve:
+		return depth, true
+	case OpAlloc:
+		if n, ok := i.sizeArg().(ImmediateIndex); ok {
+			return depth + int(n), true
+		}
+		return depth, false
+	case OpJump, OpTest, OpFrameAdjust, OpCall, OpReturn, OpDefer, OpFork, OpJoin:
+		return depth, false
+	}
+
+	_, writes := i.accesses()
+	for _, ix := range writes {
+		if r, ok := ix.(RegisterIndex); ok && r < specialRegisters {
+			return depth, false
+		}
+	}
+	return depth, true
+}
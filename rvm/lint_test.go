@@ -0,0 +1,122 @@
+package rvm
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	type want struct {
+		pc    int
+		index Index
+		msg   string
+	}
+
+	tests := []struct {
+		name   string
+		code   []uint32
+		inputs []RegisterIndex
+		want   []want
+	}{
+		{
+			name: "clean",
+			code: codeTable(nil).
+				load(RegisterIndex(3), ConstIndex(0)).
+				push(1, RegisterIndex(3)).
+				binaryOp(OpAdd, StackIndex(-1), StackIndex(0), ImmediateIndex(1)).
+				pop(1, RegisterIndex(4)).
+				push(1, RegisterIndex(4)).
+				v(),
+		},
+		{
+			name: "registers",
+			code: codeTable(nil).
+				load(RegisterIndex(3), ConstIndex(0)).                                 // written, never read
+				binaryOp(OpAdd, RegisterIndex(4), RegisterIndex(5), RegisterIndex(6)). // 4 unread, 5 and 6 unwritten
+				load(RegisterIndex(2), RegisterIndex(7)).                              // %esp is special; 7 is an input
+				v(),
+			inputs: []RegisterIndex{7},
+			want: []want{
+				{0, RegisterIndex(3), "written but never read"},
+				{1, RegisterIndex(4), "written but never read"},
+				{1, RegisterIndex(5), "read but never written"},
+				{1, RegisterIndex(6), "read but never written"},
+			},
+		},
+		{
+			name: "stack",
+			code: codeTable(nil).
+				push(2, ImmediateIndex(0)).
+				load(RegisterIndex(3), StackIndex(1)).
+				load(RegisterIndex(3), StackIndex(2)).  // read before push
+				load(RegisterIndex(3), StackIndex(-3)). // read before push
+				pop(1, nil).
+				push(2, StackIndex(0)). // stack[1] was popped
+				jump(0, nil).
+				load(RegisterIndex(3), StackIndex(10)). // not tracked past a branch
+				push(1, RegisterIndex(3)).
+				v(),
+			want: []want{
+				{2, StackIndex(2), "read before push"},
+				{3, StackIndex(-3), "read before push"},
+				{5, StackIndex(1), "read before push"},
+			},
+		},
+		{
+			name: "register ranges",
+			code: []uint32{
+				withPushPopRange(mkPushPop(OpPush, 1, RegisterIndex(63)), 2), // %64 is past the register file
+				withPushPopRange(mkPushPop(OpPop, 1, RegisterIndex(63)), 2),
+			},
+			inputs: []RegisterIndex{63},
+			want: []want{
+				{0, RegisterIndex(64), "register out of range"},
+				{1, RegisterIndex(64), "register out of range"},
+			},
+		},
+	}
+
+	for _, c := range tests {
+		t.Run(c.name, func(t *testing.T) {
+			got := Lint(c.code, c.inputs...)
+			for _, w := range got {
+				t.Log(w)
+			}
+			if len(got) != len(c.want) {
+				t.Fatalf("Lint() returned %d warnings; want %d", len(got), len(c.want))
+			}
+			for i, w := range c.want {
+				if g := got[i]; g.PC != w.pc || g.Index != w.index || g.Msg != w.msg {
+					t.Errorf("warning %d = %v; want %d: %v %s", i, g, w.pc, w.index, w.msg)
+				}
+			}
+		})
+	}
+}
+
+func TestLintRandom(t *testing.T) {
+	words := []uint32{withPushPopRange(mkPushPop(OpPush, 1, RegisterIndex(31)), 64)}
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 1<<16; i++ {
+		words = append(words, rng.Uint32())
+	}
+
+	// Lint must not panic on any code that decodes, so lint each word as the start of the code.
+	for pc := range words {
+		func() {
+			defer func() {
+				if rc := recover(); rc != nil {
+					instr, _, _ := DecodeInstruction(words[pc:])
+					t.Fatalf("Lint(%08x...) panicked on %v: %v", words[pc], instr, rc)
+				}
+			}()
+			Lint(words[pc : pc+1+pc%2])
+		}()
+	}
+}
+
+// withPushPopRange returns the push or pop instruction word with its range set to n, which the encoders reject if the
+// range runs past %63.
+func withPushPopRange(word uint32, n int) uint32 {
+	return word&^opPushPopRangeMask | uint32(n-1)<<opPushPopRangeOff
+}
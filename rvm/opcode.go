@@ -12,7 +12,7 @@ type Opcode uint32
 
 func (o Opcode) String() string {
 	i := int(o)
-	if i < 0 || i >= len(opNames) {
+	if i < 0 || i >= len(opNames) || opNames[i] == "" {
 		return "INVALID"
 	}
 	return opNames[i]
@@ -44,165 +44,105 @@ const (
 	OpDefer
 	OpFork
 	OpJoin
-	opCount
+	OpFrameAdjust
+	OpAlloc
+	OpDup
+	OpRotate
+	OpSlice
+	OpTLS
+	opCount // 0x1F is reserved for extensions
+)
+
+// Extended-only opcodes. These are above the basic opcode field's range, so they are only encoded by extended (two
+// word) instructions, like xload and xpush.
+const (
+	OpFrame Opcode = 1<<opBOpcodeLen + iota
 )
 
 const OpExtended Opcode = 0x3F
 
 var opNames = [...]string{
-	OpAdd:        `add`,
-	OpSub:        `sub`,
-	OpDiv:        `div`,
-	OpMul:        `mul`,
-	OpPow:        `pow`,
-	OpMod:        `mod`,
-	OpNeg:        `neg`,
-	OpNot:        `not`,
-	OpOr:         `or`,
-	OpAnd:        `and`,
-	OpXor:        `xor`,
-	OpArithshift: `ashift`,
-	OpBitshift:   `bshift`,
-	OpRound:      `round`,
-	OpTest:       `test`,
-	OpJump:       `jump`,
-	OpPush:       `push`,
-	OpPop:        `pop`,
-	OpReserve:    `reserve`,
-	OpLoad:       `load`,
-	OpCall:       `call`,
-	OpReturn:     `return`,
-	OpDefer:      `defer`,
-	OpFork:       `fork`,
-	OpJoin:       `join`,
+	OpAdd:         `add`,
+	OpSub:         `sub`,
+	OpDiv:         `div`,
+	OpMul:         `mul`,
+	OpPow:         `pow`,
+	OpMod:         `mod`,
+	OpNeg:         `neg`,
+	OpNot:         `not`,
+	OpOr:          `or`,
+	OpAnd:         `and`,
+	OpXor:         `xor`,
+	OpArithshift:  `ashift`,
+	OpBitshift:    `bshift`,
+	OpRound:       `round`,
+	OpTest:        `test`,
+	OpJump:        `jump`,
+	OpPush:        `push`,
+	OpPop:         `pop`,
+	OpReserve:     `reserve`,
+	OpLoad:        `load`,
+	OpCall:        `call`,
+	OpReturn:      `return`,
+	OpDefer:       `defer`,
+	OpFork:        `fork`,
+	OpJoin:        `join`,
+	OpFrameAdjust: `frameadj`,
+	OpAlloc:       `alloc`,
+	OpDup:         `dup`,
+	OpRotate:      `rot`,
+	OpSlice:       `slice`,
+	OpTLS:         `tls`,
+	OpFrame:       `frame`,
 }
 
 type opFunc func(instr Instruction, vm *Thread)
 
-var opFuncTable = [...]opFunc{
+// opFuncTable holds the handler for each opcode, indexed as by Instruction.execer. Opcodes without a handler, such as
+// the reserved opcode 0x1F, are set to invalidOp.
+var opFuncTable = [1 << (opBOpcodeLen + 1)]opFunc{
 
-	OpAdd: func(instr Instruction, vm *Thread) {
-		var (
-			out = instr.regOut()
-			lhs = toarith(instr.argA().load(vm))
-			rhs = toarith(instr.argB().load(vm))
-		)
-		out.store(vm, lhs.Add(rhs))
-	},
-
-	OpSub: func(instr Instruction, vm *Thread) {
-		var (
-			out = instr.regOut()
-			lhs = toarith(instr.argA().load(vm))
-			rhs = toarith(instr.argB().load(vm))
-		)
-		out.store(vm, lhs.Sub(rhs))
-	},
-
-	OpDiv: func(instr Instruction, vm *Thread) {
-		var (
-			out = instr.regOut()
-			lhs = toarith(instr.argA().load(vm))
-			rhs = toarith(instr.argB().load(vm))
-		)
-		out.store(vm, lhs.Div(rhs))
-	},
-
-	OpMul: func(instr Instruction, vm *Thread) {
-		var (
-			out = instr.regOut()
-			lhs = toarith(instr.argA().load(vm))
-			rhs = toarith(instr.argB().load(vm))
-		)
-		out.store(vm, lhs.Mul(rhs))
-	},
-
-	OpPow: func(instr Instruction, vm *Thread) {
-		var (
-			out = instr.regOut()
-			lhs = toarith(instr.argA().load(vm))
-			rhs = toarith(instr.argB().load(vm))
-		)
-		out.store(vm, lhs.Pow(rhs))
-	},
-
-	OpMod: func(instr Instruction, vm *Thread) {
-		var (
-			out = instr.regOut()
-			lhs = toarith(instr.argA().load(vm))
-			rhs = toarith(instr.argB().load(vm))
-		)
-		out.store(vm, lhs.Mod(rhs))
-	},
+	OpAdd: binaryOp(addValues),
+	OpSub: binaryOp(subValues),
+	OpDiv: binaryOp(divValues),
+	OpMul: binaryOp(mulValues),
+	OpPow: binaryOp(powValues),
+	OpMod: binaryOp(modValues),
 
+	// neg out src
 	OpNeg: func(instr Instruction, vm *Thread) {
 		var (
 			out  = instr.regOut()
-			recv = toarith(instr.argA().load(vm))
+			recv = toarith(instr.argB().load(vm))
+			v    = recv.Neg()
 		)
-		out.store(vm, recv.Neg())
+		if vm.faults.Overflow != FaultDefault {
+			checkOverflow(OpNeg, recv, nil, v)
+		}
+		out.store(vm, v)
 	},
 
+	// not out src
 	OpNot: func(instr Instruction, vm *Thread) {
 		var (
 			out  = instr.regOut()
-			recv = tobitwise(instr.argA().load(vm))
+			recv = tobitwise(instr.argB().load(vm))
 		)
 		out.store(vm, recv.Not())
 	},
 
-	OpOr: func(instr Instruction, vm *Thread) {
-		var (
-			out = instr.regOut()
-			lhs = tobitwise(instr.argA().load(vm))
-			rhs = tobitwise(instr.argB().load(vm))
-		)
-		out.store(vm, lhs.Or(rhs))
-	},
-
-	OpAnd: func(instr Instruction, vm *Thread) {
-		var (
-			out = instr.regOut()
-			lhs = tobitwise(instr.argA().load(vm))
-			rhs = tobitwise(instr.argB().load(vm))
-		)
-		out.store(vm, lhs.And(rhs))
-	},
-
-	OpXor: func(instr Instruction, vm *Thread) {
-		var (
-			out = instr.regOut()
-			lhs = tobitwise(instr.argA().load(vm))
-			rhs = tobitwise(instr.argB().load(vm))
-		)
-		out.store(vm, lhs.Xor(rhs))
-	},
-
-	OpArithshift: func(instr Instruction, vm *Thread) {
-		var (
-			out = instr.regOut()
-			lhs = instr.argA().load(vm)
-			rhs = instr.argB().load(vm)
-		)
-
-		out.store(vm, arithShift(lhs, rhs))
-	},
-
-	OpBitshift: func(instr Instruction, vm *Thread) {
-		var (
-			out = instr.regOut()
-			lhs = instr.argA().load(vm)
-			rhs = instr.argB().load(vm)
-		)
-
-		out.store(vm, bitwiseShift(lhs, rhs))
-	},
+	OpOr:         binaryOp(orValues),
+	OpAnd:        binaryOp(andValues),
+	OpXor:        binaryOp(xorValues),
+	OpArithshift: binaryOp(arithShift),
+	OpBitshift:   binaryOp(bitwiseShift),
 
+	// round out src mode
 	OpRound: func(instr Instruction, vm *Thread) {
 		var (
 			out  = instr.regOut()
-			mode = RoundingMode(instr.argAU())
-			val  = round(instr.argA().load(vm), mode)
+			mode = instr.roundMode()
+			val  = round(instr.argB().load(vm), mode)
 		)
 		out.store(vm, val)
 	},
@@ -211,8 +151,8 @@ var opFuncTable = [...]opFunc{
 		var (
 			op       = instr.cmpOp()
 			want, fn = op.comparator()
-			lhs      = instr.cmpArgA()
-			rhs      = instr.cmpArgB()
+			lhs      = instr.cmpArgA().load(vm)
+			rhs      = instr.cmpArgB().load(vm)
 		)
 
 		if (fn(lhs, rhs) == want) != instr.cmpWant() {
@@ -224,60 +164,79 @@ var opFuncTable = [...]opFunc{
 		// If the next instruction is a jump, execute it immediately
 		if sz, ji, ok := vm.step(false); ok && ji.Opcode() == OpJump {
 			if off, ix := ji.jumpOffset(); ix == nil {
-				vm.pc += sz + off
+				vm.jump(sz + off)
 			} else {
-				vm.pc += sz + int64(toint(ix.load(vm)))
+				vm.jump(sz + int64(toint(ix.load(vm))))
 			}
 		}
 	},
 
 	OpJump: func(instr Instruction, vm *Thread) {
 		if off, ix := instr.jumpOffset(); ix == nil {
-			vm.pc += off
+			vm.jump(off)
 		} else {
-			vm.pc += int64(toint(ix.load(vm)))
+			vm.jump(int64(toint(ix.load(vm))))
 		}
 	},
 
 	// push n src
+	// push n $imm
+	// xpush list
 	OpPush: func(instr Instruction, vm *Thread) {
+		if instr.isExt() {
+			vm.pushList(instr.pushList().load(vm))
+			return
+		}
+
 		n := instr.pushPopRange()
 		switch src := instr.pushArg().(type) {
 		case StackIndex:
-			var incr StackIndex = 1
-			if src < 0 {
-				incr = -1
+			// Resolve src once, so a range relative to the top of the stack is not shifted by the values pushed.
+			base := src.abs(vm)
+			if base < 0 || base+n > len(vm.stack) {
+				panic(InvalidStackIndex(src))
 			}
-
-			for i, top := src, src+StackIndex(n)*incr; i != top; i = i + incr {
-				vm.Push(i.load(vm))
+			for j := 0; j < n; j++ {
+				vm.Push(vm.stack[base+j])
 			}
 		case RegisterIndex:
 			for i, top := src, src+RegisterIndex(n); i < top; i++ {
 				vm.Push(i.load(vm))
 			}
-		case constIndex:
-			for i, top := src, src+constIndex(n); i < top; i++ {
+		case ConstIndex:
+			for i, top := src, src+ConstIndex(n); i < top; i++ {
 				vm.Push(i.load(vm))
 			}
+		case ImmediateIndex:
+			v := src.load(vm)
+			for i := 0; i < n; i++ {
+				vm.Push(v)
+			}
 		}
 	},
 
 	// pop n dst
+	// pop n
 	OpPop: func(instr Instruction, vm *Thread) {
 		n := instr.pushPopRange()
 		switch src := instr.popArg().(type) {
+		case nil:
+			vm.drop(n)
 		case StackIndex:
-			if src < 0 {
-				for i := src + StackIndex(n-1); i >= src; i-- {
-					i.store(vm, vm.Pop())
-				}
-				return
+			// Pop all values first, then store them in stack order starting at src, resolved against the new top.
+			top := len(vm.stack) - n
+			if top < 0 {
+				panic(ErrUnderflow)
 			}
+			var buf [1 << opPushPopRangeLen]Value
+			vals := buf[:copy(buf[:], vm.stack[top:])]
+			vm.drop(n)
 
-			for i := src - StackIndex(n-1); i <= src; i++ {
-				i.store(vm, vm.Pop())
+			dst := src.abs(vm)
+			if dst < 0 || dst+n > len(vm.stack) {
+				panic(InvalidStackIndex(src))
 			}
+			copy(vm.stack[dst:], vals)
 		case RegisterIndex:
 			for i := src + RegisterIndex(n-1); i >= src; i-- {
 				i.store(vm, vm.Pop())
@@ -285,12 +244,67 @@ var opFuncTable = [...]opFunc{
 		}
 	},
 
+	// dup n depth
+	OpDup: func(instr Instruction, vm *Thread) {
+		vm.dup(instr.pushPopRange(), instr.stackOpArg())
+	},
+
+	// rot n shift
+	OpRotate: func(instr Instruction, vm *Thread) {
+		vm.rotate(instr.pushPopRange(), instr.stackOpArg())
+	},
+
+	// slice out start n
+	OpSlice: func(instr Instruction, vm *Thread) {
+		var (
+			out   = instr.regOut()
+			start = int(toint(instr.argA().load(vm)))
+			n     = int(toint(instr.argB().load(vm)))
+		)
+		out.store(vm, vm.Slice(start, n))
+	},
+
 	OpReserve: func(instr Instruction, vm *Thread) {
-		sz := int(toint(instr.argB().load(vm)))
+		sz := int(toint(instr.sizeArg().load(vm)))
 		vm.growStack(sz)
 	},
 
+	// alloc n
+	OpAlloc: func(instr Instruction, vm *Thread) {
+		n := int(toint(instr.sizeArg().load(vm)))
+		vm.allocStack(n)
+	},
+
+	// frameadj delta
+	OpFrameAdjust: func(instr Instruction, vm *Thread) {
+		delta := int(toint(instr.sizeArg().load(vm)))
+		vm.adjustFrame(delta)
+	},
+
+	// tls dst tls[slot]
+	// tls tls[slot] src
+	OpTLS: func(instr Instruction, vm *Thread) {
+		if slot, ix := instr.tlsSlot(), instr.loadDst(); instr&opTLSStore != 0 {
+			slot.store(vm, ix.load(vm))
+		} else {
+			ix.store(vm, slot.load(vm))
+		}
+	},
+
+	// xframe dst frame[depth].stack[index]
+	// xframe frame[depth].stack[index] src
+	OpFrame: func(instr Instruction, vm *Thread) {
+		if fx, ix := instr.frameIndex(), instr.loadDst(); instr&opFrameStore != 0 {
+			fx.store(vm, ix.load(vm))
+		} else {
+			ix.store(vm, fx.load(vm))
+		}
+	},
+
 	OpLoad: func(instr Instruction, vm *Thread) {
+		if loadToReg(instr, vm) {
+			return
+		}
 		instr.loadDst().store(vm, instr.loadSrc().load(vm))
 	},
 
@@ -314,3 +328,60 @@ var opFuncTable = [...]opFunc{
 		panic("unimplemented")
 	},
 }
+
+func init() {
+	for op, fn := range opFuncTable {
+		if fn == nil {
+			opFuncTable[op] = invalidOp
+		}
+	}
+}
+
+// invalidOp is the handler for opcodes that have none. It panics with InvalidOpcode.
+func invalidOp(instr Instruction, vm *Thread) {
+	panic(InvalidOpcode(instr.Opcode()))
+}
+
+// binaryOp returns the handler for a binary instruction computing fn(argA, argB). The extended form of the
+// instruction is a vector instruction; see vectorOp.
+func binaryOp(fn func(lhs, rhs Value) Value) opFunc {
+	return func(instr Instruction, vm *Thread) {
+		if instr&(instrExtendedBit|opXbinWide) == instrExtendedBit {
+			vm.vectorOp(instr, fn)
+			return
+		}
+		var (
+			out = instr.regOut()
+			lhs = instr.argA().load(vm)
+			rhs = instr.argB().load(vm)
+			v   = fn(lhs, rhs)
+		)
+		if vm.faults.Overflow != FaultDefault {
+			checkOverflow(instr.Opcode(), lhs, rhs, v)
+		}
+		out.store(vm, v)
+	}
+}
+
+// loadToReg executes a basic (non-extended) load into a register, decoding the source directly instead of through
+// loadSrc so that no Index is boxed and every load and store is a static call. It returns false for loads into the
+// stack and extended loads, which are left to the generic path.
+func loadToReg(instr Instruction, vm *Thread) bool {
+	if instr&(instrExtendedBit|opLoadDstStack) != 0 {
+		return false
+	}
+
+	var v Value
+	switch instr & opLoadSrcImm {
+	case 0:
+		v = RegisterIndex(uint32(instr>>opLoadSrcOff) & opRegMask).load(vm)
+	case opLoadSrcConst:
+		v = ConstIndex(uint16(instr >> opLoadSrcOff)).load(vm)
+	case opLoadSrcStack:
+		v = StackIndex(int16(instr >> opLoadSrcOff)).load(vm)
+	case opLoadSrcImm:
+		v = Int(int16(instr >> opLoadSrcOff))
+	}
+	RegisterIndex(uint32(instr>>opLoadDstOff)&opRegMask).store(vm, v)
+	return true
+}
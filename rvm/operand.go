@@ -0,0 +1,126 @@
+package rvm
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// OperandKind is the addressing mode of an Operand.
+type OperandKind uint8
+
+const (
+	// OperandNone is the kind of the zero Operand. It is accepted only where an operand is optional (jump offsets and
+	// discarding pops).
+	OperandNone OperandKind = iota
+	OperandReg
+	OperandStack
+	OperandConst
+	OperandImmediate
+	OperandRelReg // Register whose index is the value of Value's register plus Offset; see RelRegisterIndex
+)
+
+func (k OperandKind) String() string {
+	switch k {
+	case OperandNone:
+		return "none"
+	case OperandReg:
+		return "register"
+	case OperandStack:
+		return "stack"
+	case OperandConst:
+		return "const"
+	case OperandImmediate:
+		return "immediate"
+	case OperandRelReg:
+		return "relative register"
+	default:
+		return "OperandKind(" + strconv.Itoa(int(k)) + ")"
+	}
+}
+
+// Operand is an instruction operand used for encoding instructions. Unlike Index, which reads and writes values on a
+// Thread, an Operand is only a kind and a value, so encoders can validate it and return an error instead of
+// panicking on an unknown index type.
+type Operand struct {
+	Kind   OperandKind
+	Value  int64
+	Offset int64 // Register offset of an OperandRelReg
+}
+
+// Reg returns a register operand.
+func Reg(i int) Operand { return Operand{Kind: OperandReg, Value: int64(i)} }
+
+// Stack returns a stack operand. Negative values are relative to the top of the stack; others are relative to ebp.
+func Stack(i int) Operand { return Operand{Kind: OperandStack, Value: int64(i)} }
+
+// Const returns a constant table operand.
+func Const(i int) Operand { return Operand{Kind: OperandConst, Value: int64(i)} }
+
+// Imm returns an immediate integer operand.
+func Imm(i int) Operand { return Operand{Kind: OperandImmediate, Value: int64(i)} }
+
+// RelReg returns a relative register operand for the register at the index held by base, plus offset.
+func RelReg(base, offset int) Operand {
+	return Operand{Kind: OperandRelReg, Value: int64(base), Offset: int64(offset)}
+}
+
+// OperandOf returns the Operand for ix. A nil ix returns the zero Operand. It returns false if ix is not one of the
+// Index types defined by this package.
+func OperandOf(ix Index) (Operand, bool) {
+	switch ix := ix.(type) {
+	case nil:
+		return Operand{}, true
+	case RegisterIndex:
+		return Reg(int(ix)), true
+	case StackIndex:
+		return Stack(int(ix)), true
+	case ConstIndex:
+		return Const(int(ix)), true
+	case ImmediateIndex:
+		return Imm(int(ix)), true
+	case RelRegisterIndex:
+		return RelReg(int(ix.Base), ix.Offset), true
+	default:
+		return Operand{}, false
+	}
+}
+
+// mustOperand returns the Operand for ix, panicking if ix has no Operand form.
+func mustOperand(ix Index) Operand {
+	o, ok := OperandOf(ix)
+	if !ok {
+		panic(fmt.Errorf("invalid index type %T; must be register, stack, const, immediate, or relative register", ix))
+	}
+	return o
+}
+
+// Index returns the runtime Index for the operand. It returns nil for OperandNone and unknown kinds.
+func (o Operand) Index() Index {
+	switch o.Kind {
+	case OperandReg:
+		return RegisterIndex(o.Value)
+	case OperandStack:
+		return StackIndex(o.Value)
+	case OperandConst:
+		return ConstIndex(o.Value)
+	case OperandImmediate:
+		return ImmediateIndex(o.Value)
+	case OperandRelReg:
+		return RelRegisterIndex{Base: RegisterIndex(o.Value), Offset: int(o.Offset)}
+	default:
+		return nil
+	}
+}
+
+func (o Operand) String() string {
+	if ix := o.Index(); ix != nil {
+		return fmt.Sprint(ix)
+	}
+	return o.Kind.String()
+}
+
+// operandKindError returns an error for an operand whose kind is not accepted by an instruction field. want lists the
+// accepted kinds.
+func operandKindError(field string, o Operand, want string) error {
+	return fmt.Errorf("invalid %v operand for %s; must be %s", o.Kind, field, want)
+}
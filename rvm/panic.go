@@ -0,0 +1,75 @@
+package rvm
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// HostPanic is the panic value raised when host code called by the interpreter panics. Currently, the only host code
+// the interpreter calls is the function of a Lazy constant, when the constant is first loaded. The original panic value
+// is kept, along with the stack trace of the goroutine at the time of the panic.
+type HostPanic struct {
+	Value interface{}
+	// Stack is the stack trace of the host code's goroutine when it panicked.
+	Stack []byte
+}
+
+func (p *HostPanic) Error() string {
+	return fmt.Sprint("host panic: ", p.Value)
+}
+
+// Unwrap returns the original panic value if it is an error.
+func (p *HostPanic) Unwrap() error {
+	err, _ := p.Value.(error)
+	return err
+}
+
+// wrapHostPanic wraps a recovered panic from host code in a *HostPanic and re-raises it. It must be deferred directly.
+func wrapHostPanic() {
+	rc := recover()
+	if rc == nil {
+		return
+	} else if _, ok := rc.(*HostPanic); ok {
+		panic(rc)
+	}
+	panic(&HostPanic{Value: rc, Stack: debug.Stack()})
+}
+
+// PanicPolicy controls which panics RunProtected and RunN recover and return as a *RuntimePanic.
+type PanicPolicy int
+
+const (
+	// RecoverAll recovers every panic. This is the default.
+	RecoverAll PanicPolicy = iota
+	// RepanicHost recovers faults raised by the interpreter, such as ErrUnderflow or an InvalidStackIndex, but
+	// re-raises panics from host code as a *HostPanic. Use it to keep bugs in host code from being reported as script
+	// errors.
+	//
+	// The interpreter checks the indices, jump targets, and stack sizes a script supplies, and raises an error of its
+	// own (such as InvalidStackIndex, InvalidPC, or ErrOverflow) for those out of range. A Go runtime error, such as
+	// an index out of range or a nil dereference, is then a bug in the interpreter rather than the script, and is
+	// re-raised as well.
+	RepanicHost
+)
+
+// SetPanicPolicy sets which panics RunProtected and RunN recover.
+func (th *Thread) SetPanicPolicy(policy PanicPolicy) {
+	if auditing {
+		th.audit.enter()
+		defer th.audit.exit()
+	}
+	th.panics = policy
+}
+
+// recoverPanic returns a recovered panic as a *RuntimePanic, or re-raises it if the thread's panic policy does not
+// recover it.
+func (th *Thread) recoverPanic(rc interface{}) *RuntimePanic {
+	if th.panics == RepanicHost {
+		switch rc.(type) {
+		case *HostPanic, runtime.Error:
+			panic(rc)
+		}
+	}
+	return &RuntimePanic{Value: rc, Trace: th.Backtrace()}
+}
@@ -0,0 +1,97 @@
+package rvm
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+var errResumeWithoutPause = errors.New("thread resumed without a matching pause")
+
+// pauseState coordinates Pause and Resume, called from other goroutines, with a running thread. It is the only part of
+// a Thread that is safe for concurrent use.
+type pauseState struct {
+	requested int32 // Nonzero while paused; read atomically by the interpreter at each safe point
+
+	mu      sync.Mutex
+	cond    sync.Cond
+	count   int  // Pause calls not yet matched by Resume
+	running bool // Whether Run or RunN is executing instructions, as opposed to parked or returned
+}
+
+func (p *pauseState) lock() {
+	p.mu.Lock()
+	if p.cond.L == nil {
+		p.cond.L = &p.mu
+	}
+}
+
+func (p *pauseState) setRunning(running bool) {
+	p.lock()
+	p.running = running
+	p.cond.Broadcast()
+	p.mu.Unlock()
+}
+
+// park blocks until every Pause call has been matched by Resume.
+func (p *pauseState) park() {
+	p.lock()
+	p.running = false
+	p.cond.Broadcast()
+	for p.count > 0 {
+		p.cond.Wait()
+	}
+	p.running = true
+	p.mu.Unlock()
+}
+
+// Pause stops the thread at its next safe point and returns once it is stopped. A safe point is reached before each
+// instruction, so a paused thread is never partway through one. If the thread is not running, Pause returns
+// immediately and the next call to Run or RunN stops before its first instruction.
+//
+// While the thread is paused, the goroutine that paused it may use it, e.g. to inspect its registers and stack, but
+// must stop before calling Resume. Pauses nest: the thread continues only once each Pause has been matched by a call
+// to Resume.
+//
+// Pause and Resume may be called from any goroutine, but Pause must not be called by the goroutine running the
+// thread, since it would wait for itself to stop.
+func (th *Thread) Pause() {
+	p := &th.pause
+	p.lock()
+	defer p.mu.Unlock()
+	p.count++
+	atomic.StoreInt32(&p.requested, 1)
+	for p.running {
+		p.cond.Wait()
+	}
+}
+
+// Resume undoes a call to Pause. The thread continues from its safe point once every Pause has been resumed. Resume
+// panics if the thread is not paused.
+func (th *Thread) Resume() {
+	p := &th.pause
+	p.lock()
+	defer p.mu.Unlock()
+	if p.count == 0 {
+		panic(errResumeWithoutPause)
+	}
+	if p.count--; p.count == 0 {
+		atomic.StoreInt32(&p.requested, 0)
+		p.cond.Broadcast()
+	}
+}
+
+// safePoint parks the thread if it has been paused. The thread gives up its audit ownership while parked so that the
+// goroutine that paused it may use it.
+func (th *Thread) safePoint() {
+	if atomic.LoadInt32(&th.pause.requested) == 0 {
+		return
+	}
+	if auditing {
+		th.audit.exit()
+	}
+	th.pause.park()
+	if auditing {
+		th.audit.enter()
+	}
+}
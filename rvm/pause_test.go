@@ -0,0 +1,57 @@
+package rvm
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPauseResume(t *testing.T) {
+	const loops = 1000000
+
+	th := NewThread()
+	th.pushFrame(0, funcData{
+		code: codeTable(nil).
+			load(RegisterIndex(3), ImmediateIndex(0)).
+			binaryOp(OpAdd, RegisterIndex(3), RegisterIndex(3), ImmediateIndex(1)).
+			test(CmpLess, true, RegisterIndex(3), ConstIndex(0)).
+			jump(-3, nil).
+			v(),
+		consts: []Value{Int(loops)},
+	})
+
+	// Pausing before Run stops the thread before its first instruction.
+	th.Pause()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		th.Run()
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	if th.pc != 0 {
+		t.Fatalf("pc = %d; want 0 while paused", th.pc)
+	}
+	th.Resume()
+
+	// Nested pauses: the thread stays stopped until both are resumed.
+	th.Pause()
+	th.Pause()
+	before := th.At(RegisterIndex(3))
+	th.Resume()
+	time.Sleep(10 * time.Millisecond)
+	if after := th.At(RegisterIndex(3)); after != before {
+		t.Fatalf("%%3 changed from %v to %v while paused", before, after)
+	}
+	th.Resume()
+
+	<-done
+	if got := th.At(RegisterIndex(3)); got != Int(loops) {
+		t.Fatalf("%%3 = %v; want %d", got, loops)
+	}
+
+	// Pausing a thread that is not running returns immediately.
+	th.Pause()
+	th.Resume()
+
+	testPanics(t, "Resume without Pause", th.Resume)
+}
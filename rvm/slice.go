@@ -0,0 +1,55 @@
+package rvm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// StackSlice is a Value viewing a contiguous range of a thread's stack, such as a function's variadic arguments. It
+// refers to the stack directly rather than copying its values, so it is only valid while its range remains on the stack.
+// Accessing a StackSlice after its range has been popped panics with ErrStackRange.
+type StackSlice struct {
+	th    *Thread
+	start int // absolute stack index of the first value
+	n     int
+}
+
+// Len returns the number of values in the slice.
+func (s StackSlice) Len() int {
+	return s.n
+}
+
+// Index returns the i-th value of the slice.
+func (s StackSlice) Index(i int) Value {
+	if i < 0 || i >= s.n {
+		panic(InvalidStackIndex(i))
+	}
+	return s.values()[i]
+}
+
+// Values returns a copy of the values in the slice.
+func (s StackSlice) Values() []Value {
+	return append([]Value(nil), s.values()...)
+}
+
+func (s StackSlice) values() []Value {
+	if s.th == nil {
+		return nil
+	} else if s.start+s.n > len(s.th.stack) {
+		panic(ErrStackRange)
+	}
+	return s.th.stack[s.start : s.start+s.n]
+}
+
+func (s StackSlice) String() string {
+	var b strings.Builder
+	b.WriteByte('[')
+	for i, v := range s.values() {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		fmt.Fprint(&b, v)
+	}
+	b.WriteByte(']')
+	return b.String()
+}
@@ -0,0 +1,624 @@
+package spec
+
+import "go.spiff.io/rusalka/rvm"
+
+type (
+	reg = rvm.RegisterIndex
+	stk = rvm.StackIndex
+)
+
+type (
+	vals  = []rvm.Value
+	wants = []Want
+	code  = []rvm.Instruction
+)
+
+// must returns instr, panicking if err is not nil.
+func must(instr rvm.Instruction, err error) rvm.Instruction {
+	if err != nil {
+		panic(err)
+	}
+	return instr
+}
+
+// Cases returns the specification cases. Each call returns a new slice, so callers may modify it.
+//
+// Register %3 is the first call register and %19 the first volatile register. Stack indices 0 and up are relative to
+// the frame's ebp, which is at the first value of Case.Stack; negative stack indices are relative to the top of the
+// stack, such that -1 is the last value.
+func Cases() []Case {
+	var cases []Case
+	for _, group := range [][]Case{
+		arithCases(),
+		vectorCases(),
+		wideCases(),
+		addressingCases(),
+		pushPopCases(),
+		stackOpCases(),
+		controlCases(),
+	} {
+		cases = append(cases, group...)
+	}
+	return cases
+}
+
+func arithCases() []Case {
+	bin := func(name string, op rvm.Opcode, a, b, want rvm.Value) Case {
+		return Case{
+			Name:   name,
+			Consts: vals{a, b},
+			Code: code{
+				must(rvm.EncodeLoad(rvm.Reg(4), rvm.Const(0))),
+				must(rvm.EncodeBinary(op, rvm.Reg(3), rvm.Reg(4), rvm.Const(1))),
+			},
+			Want: wants{{reg(3), want}},
+		}
+	}
+
+	return []Case{
+		bin("add/int", rvm.OpAdd, rvm.Int(2), rvm.Int(3), rvm.Int(5)),
+		bin("add/uint", rvm.OpAdd, rvm.Uint(2), rvm.Uint(3), rvm.Uint(5)),
+		bin("add/float", rvm.OpAdd, rvm.Float(0.5), rvm.Float(0.25), rvm.Float(0.75)),
+		bin("add/int+float", rvm.OpAdd, rvm.Int(1), rvm.Float(0.5), rvm.Float(1.5)),
+		bin("sub/int", rvm.OpSub, rvm.Int(2), rvm.Int(5), rvm.Int(-3)),
+		bin("mul/int", rvm.OpMul, rvm.Int(-4), rvm.Int(6), rvm.Int(-24)),
+		bin("div/int-truncates", rvm.OpDiv, rvm.Int(-7), rvm.Int(2), rvm.Int(-3)),
+		bin("div/float", rvm.OpDiv, rvm.Float(1), rvm.Float(4), rvm.Float(0.25)),
+		bin("mod/int-sign-of-dividend", rvm.OpMod, rvm.Int(-7), rvm.Int(3), rvm.Int(-1)),
+		bin("mod/float", rvm.OpMod, rvm.Float(7.5), rvm.Float(2), rvm.Float(1.5)),
+		bin("or/int", rvm.OpOr, rvm.Int(0x0C), rvm.Int(0x03), rvm.Int(0x0F)),
+		bin("and/int", rvm.OpAnd, rvm.Int(0x0C), rvm.Int(0x06), rvm.Int(0x04)),
+		bin("xor/int", rvm.OpXor, rvm.Int(0x0C), rvm.Int(0x06), rvm.Int(0x0A)),
+		bin("ashift/right", rvm.OpArithshift, rvm.Int(-16), rvm.Int(2), rvm.Int(-4)),
+		bin("ashift/left", rvm.OpArithshift, rvm.Int(3), rvm.Int(-2), rvm.Int(12)),
+		bin("bshift/right-zero-fills", rvm.OpBitshift, rvm.Int(-1), rvm.Int(60), rvm.Int(0xF)),
+		bin("bshift/left", rvm.OpBitshift, rvm.Int(1), rvm.Int(-4), rvm.Int(16)),
+
+		{
+			Name:   "div/int-by-zero",
+			Consts: vals{rvm.Int(1), rvm.Int(0)},
+			Code: code{
+				must(rvm.EncodeLoad(rvm.Reg(4), rvm.Const(0))),
+				must(rvm.EncodeBinary(rvm.OpDiv, rvm.Reg(3), rvm.Reg(4), rvm.Const(1))),
+			},
+			WantErr: true,
+		},
+		{
+			Name:   "add/non-number",
+			Consts: vals{rvm.Int(1)},
+			Code: code{
+				must(rvm.EncodeBinary(rvm.OpAdd, rvm.Reg(3), rvm.Reg(4), rvm.Const(0))),
+			},
+			WantErr: true,
+		},
+		{
+			Name:   "neg",
+			Consts: vals{rvm.Int(5), rvm.Float(-0.5)},
+			Code: code{
+				must(rvm.EncodeUnary(rvm.OpNeg, rvm.Reg(3), rvm.Const(0))),
+				must(rvm.EncodeUnary(rvm.OpNeg, rvm.Reg(4), rvm.Const(1))),
+			},
+			Want: wants{{reg(3), rvm.Int(-5)}, {reg(4), rvm.Float(0.5)}},
+		},
+		{
+			Name:   "not",
+			Consts: vals{rvm.Int(0), rvm.Uint(0xF0)},
+			Code: code{
+				must(rvm.EncodeUnary(rvm.OpNot, rvm.Reg(3), rvm.Const(0))),
+				must(rvm.EncodeUnary(rvm.OpNot, rvm.Reg(4), rvm.Const(1))),
+			},
+			Want: wants{{reg(3), rvm.Int(-1)}, {reg(4), ^rvm.Uint(0xF0)}},
+		},
+		{
+			Name:   "round/int-unchanged",
+			Consts: vals{rvm.Int(-3)},
+			Code: code{
+				must(rvm.EncodeRound(rvm.Reg(3), rvm.Const(0), rvm.RoundFloor)),
+				must(rvm.EncodeRound(rvm.Reg(4), rvm.Const(0), rvm.RoundCeil)),
+			},
+			Want: wants{{reg(3), rvm.Int(-3)}, {reg(4), rvm.Int(-3)}},
+		},
+		{
+			// Rounding a Float yields a Float in every mode.
+			Name:   "round/float",
+			Consts: vals{rvm.Float(-2.5)},
+			Code: code{
+				must(rvm.EncodeRound(rvm.Reg(3), rvm.Const(0), rvm.RoundTruncate)),
+				must(rvm.EncodeRound(rvm.Reg(4), rvm.Const(0), rvm.RoundNearest)),
+				must(rvm.EncodeRound(rvm.Reg(5), rvm.Const(0), rvm.RoundFloor)),
+				must(rvm.EncodeRound(rvm.Reg(6), rvm.Const(0), rvm.RoundCeil)),
+			},
+			Want: wants{
+				{reg(3), rvm.Float(-2)},
+				{reg(4), rvm.Float(-3)},
+				{reg(5), rvm.Float(-3)},
+				{reg(6), rvm.Float(-2)},
+			},
+		},
+		{
+			Name:  "round/stack",
+			Stack: vals{rvm.Float(0.49999999999999994)},
+			Code:  code{must(rvm.EncodeRound(rvm.Stack(0), rvm.Stack(0), rvm.RoundNearest))},
+			// Adding 0.5 before truncating would round this up.
+			WantStack: vals{rvm.Float(0)},
+		},
+	}
+}
+
+func vectorCases() []Case {
+	return []Case{
+		{
+			Name:   "vector/registers",
+			Consts: vals{rvm.Int(1), rvm.Int(2), rvm.Float(0.5), rvm.Float(0.25)},
+			Code: code{
+				must(rvm.EncodePushPop(rvm.OpPush, 4, rvm.Const(0))),
+				must(rvm.EncodePushPop(rvm.OpPop, 4, rvm.Reg(3))),
+				must(rvm.EncodeVector(rvm.OpAdd, 2, rvm.Reg(7), rvm.Reg(3), rvm.Reg(5))),
+			},
+			Want: wants{{reg(7), rvm.Float(1.5)}, {reg(8), rvm.Float(2.25)}},
+		},
+		{
+			Name:      "vector/stack-and-immediate",
+			Stack:     vals{rvm.Int(1), rvm.Int(2), rvm.Int(3)},
+			Code:      code{must(rvm.EncodeVector(rvm.OpMul, 3, rvm.Stack(0), rvm.Stack(0), rvm.Imm(-3)))},
+			WantStack: vals{rvm.Int(-3), rvm.Int(-6), rvm.Int(-9)},
+		},
+		{
+			Name:      "vector/stack-from-top-and-consts",
+			Stack:     vals{rvm.Int(1), rvm.Int(2), rvm.Int(3)},
+			Consts:    vals{rvm.Int(10), rvm.Int(20)},
+			Code:      code{must(rvm.EncodeVector(rvm.OpSub, 2, rvm.Stack(-2), rvm.Stack(-2), rvm.Const(0)))},
+			WantStack: vals{rvm.Int(1), rvm.Int(-8), rvm.Int(-17)},
+		},
+		{
+			// Each element is stored before the next is loaded, so overlapping ranges see earlier results.
+			Name:  "vector/overlapping",
+			Stack: vals{rvm.Int(1), rvm.Int(0), rvm.Int(0), rvm.Int(0)},
+			Code:  code{must(rvm.EncodeVector(rvm.OpAdd, 3, rvm.Stack(1), rvm.Stack(0), rvm.Stack(0)))},
+			// stack[j+1] = stack[j] + stack[j]
+			WantStack: vals{rvm.Int(1), rvm.Int(2), rvm.Int(4), rvm.Int(8)},
+		},
+		{
+			Name:    "vector/stack-out-of-range",
+			Stack:   vals{rvm.Int(1), rvm.Int(2)},
+			Code:    code{must(rvm.EncodeVector(rvm.OpAdd, 3, rvm.Stack(0), rvm.Stack(0), rvm.Imm(1)))},
+			WantErr: true,
+		},
+	}
+}
+
+func wideCases() []Case {
+	// Constant pools too large for the basic binary and test encodings.
+	consts := make(vals, 2000)
+	for i := range consts {
+		consts[i] = rvm.Int(i)
+	}
+
+	return []Case{
+		{
+			Name:   "wide/const",
+			Consts: consts,
+			Code: code{
+				must(rvm.EncodeLoad(rvm.Reg(3), rvm.Imm(1))),
+				must(rvm.EncodeWideBinary(rvm.OpAdd, rvm.Reg(3), rvm.Reg(3), rvm.Const(1999))),
+			},
+			Want: wants{{reg(3), rvm.Int(2000)}},
+		},
+		{
+			Name:      "wide/stack",
+			Stack:     vals{rvm.Int(1), rvm.Int(2)},
+			Code:      code{must(rvm.EncodeWideBinary(rvm.OpSub, rvm.Stack(-1), rvm.Stack(-2), rvm.Imm(-5000)))},
+			WantStack: vals{rvm.Int(1), rvm.Int(5001)},
+		},
+		{
+			Name:   "xtest/pass",
+			Consts: consts,
+			Code: code{
+				must(rvm.EncodeXtest(rvm.CmpEqual, true, rvm.Const(1500), rvm.Imm(1500))),
+				must(rvm.EncodeLoad(rvm.Reg(3), rvm.Imm(1))),
+				must(rvm.EncodeLoad(rvm.Reg(4), rvm.Imm(2))),
+			},
+			Want: wants{{reg(3), rvm.Int(1)}, {reg(4), rvm.Int(2)}},
+		},
+		{
+			// A failed xtest skips the next instruction, even if it is extended.
+			Name:   "xtest/fail",
+			Consts: consts,
+			Code: code{
+				must(rvm.EncodeXtest(rvm.CmpLess, true, rvm.Const(1500), rvm.Const(1499))),
+				must(rvm.EncodeXload(rvm.Reg(3), rvm.Imm(1))),
+				must(rvm.EncodeLoad(rvm.Reg(4), rvm.Imm(2))),
+			},
+			Want: wants{{reg(3), nil}, {reg(4), rvm.Int(2)}},
+		},
+	}
+}
+
+func addressingCases() []Case {
+	return []Case{
+		{
+			Name:   "load/const",
+			Consts: vals{rvm.Float(1.5)},
+			Code:   code{must(rvm.EncodeLoad(rvm.Reg(3), rvm.Const(0)))},
+			Want:   wants{{reg(3), rvm.Float(1.5)}},
+		},
+		{
+			Name: "load/immediate",
+			Code: code{
+				must(rvm.EncodeLoad(rvm.Reg(3), rvm.Imm(-8192))),
+				must(rvm.EncodeLoad(rvm.Reg(4), rvm.Imm(8191))),
+			},
+			Want: wants{{reg(3), rvm.Int(-8192)}, {reg(4), rvm.Int(8191)}},
+		},
+		{
+			Name: "xload/wide-immediate",
+			Code: code{must(rvm.EncodeXload(rvm.Reg(3), rvm.Imm(1<<20)))},
+			Want: wants{{reg(3), rvm.Int(1 << 20)}},
+		},
+		{
+			Name: "xload/relative-register",
+			Code: code{
+				must(rvm.EncodeLoad(rvm.Reg(3), rvm.Imm(30))),
+				must(rvm.EncodeXload(rvm.RelReg(3, -1), rvm.Imm(5))),
+				must(rvm.EncodeXload(rvm.Reg(4), rvm.RelReg(3, -1))),
+			},
+			Want: wants{{reg(29), rvm.Int(5)}, {reg(4), rvm.Int(5)}},
+		},
+		{
+			Name: "xload/relative-register-out-of-range",
+			Code: code{
+				must(rvm.EncodeLoad(rvm.Reg(3), rvm.Imm(63))),
+				must(rvm.EncodeXload(rvm.Reg(4), rvm.RelReg(3, 1))),
+			},
+			WantErr: true,
+		},
+		{
+			Name: "load/volatile-register",
+			Code: code{
+				must(rvm.EncodeLoad(rvm.Reg(19), rvm.Imm(7))),
+				must(rvm.EncodeLoad(rvm.Reg(63), rvm.Reg(19))),
+			},
+			Want: wants{{reg(19), rvm.Int(7)}, {reg(63), rvm.Int(7)}},
+		},
+		{
+			Name:  "load/stack-from-ebp",
+			Stack: vals{rvm.Int(10), rvm.Int(20), rvm.Int(30)},
+			Code: code{
+				must(rvm.EncodeLoad(rvm.Reg(3), rvm.Stack(0))),
+				must(rvm.EncodeLoad(rvm.Reg(4), rvm.Stack(2))),
+			},
+			Want: wants{{reg(3), rvm.Int(10)}, {reg(4), rvm.Int(30)}},
+		},
+		{
+			Name:  "load/stack-from-top",
+			Stack: vals{rvm.Int(10), rvm.Int(20), rvm.Int(30)},
+			Code: code{
+				must(rvm.EncodeLoad(rvm.Reg(3), rvm.Stack(-1))),
+				must(rvm.EncodeLoad(rvm.Reg(4), rvm.Stack(-3))),
+			},
+			Want: wants{{reg(3), rvm.Int(30)}, {reg(4), rvm.Int(10)}},
+		},
+		{
+			Name:      "load/store-to-stack",
+			Stack:     vals{rvm.Int(10), rvm.Int(20), rvm.Int(30)},
+			Code:      code{must(rvm.EncodeLoad(rvm.Stack(0), rvm.Stack(-1))), must(rvm.EncodeLoad(rvm.Stack(-1), rvm.Imm(1)))},
+			WantStack: vals{rvm.Int(30), rvm.Int(20), rvm.Int(1)},
+		},
+		{
+			Name:    "load/stack-out-of-range",
+			Stack:   vals{rvm.Int(10)},
+			Code:    code{must(rvm.EncodeLoad(rvm.Reg(3), rvm.Stack(1)))},
+			WantErr: true,
+		},
+		{
+			Name:    "load/const-out-of-range",
+			Consts:  vals{rvm.Int(1)},
+			Code:    code{must(rvm.EncodeLoad(rvm.Reg(3), rvm.Const(1)))},
+			WantErr: true,
+		},
+		{
+			Name:  "binary/immediate-argB",
+			Stack: vals{rvm.Int(10)},
+			Code: code{
+				must(rvm.EncodeBinary(rvm.OpAdd, rvm.Reg(3), rvm.Stack(0), rvm.Imm(-256))),
+				must(rvm.EncodeBinary(rvm.OpAdd, rvm.Stack(0), rvm.Stack(0), rvm.Imm(255))),
+			},
+			Want:      wants{{reg(3), rvm.Int(-246)}},
+			WantStack: vals{rvm.Int(265)},
+		},
+		{
+			Name:  "esp/load",
+			Stack: vals{rvm.Int(1), rvm.Int(2)},
+			Code:  code{must(rvm.EncodeLoad(rvm.Reg(3), rvm.Reg(2)))},
+			Want:  wants{{reg(3), rvm.Int(2)}},
+		},
+		{
+			Name:      "esp/store-truncates",
+			Stack:     vals{rvm.Int(1), rvm.Int(2), rvm.Int(3)},
+			Code:      code{must(rvm.EncodeLoad(rvm.Reg(2), rvm.Imm(1)))},
+			WantStack: vals{rvm.Int(1)},
+		},
+		{
+			Name:      "esp/store-grows-with-nil",
+			Stack:     vals{rvm.Int(1)},
+			Code:      code{must(rvm.EncodeLoad(rvm.Reg(2), rvm.Imm(3)))},
+			WantStack: vals{rvm.Int(1), nil, nil},
+		},
+		{
+			Name:    "esp/store-below-ebp",
+			Code:    code{must(rvm.EncodeLoad(rvm.Reg(2), rvm.Imm(-1)))},
+			WantErr: true,
+		},
+		{
+			Name:    "ebp/store",
+			Code:    code{must(rvm.EncodeLoad(rvm.Reg(1), rvm.Imm(0)))},
+			WantErr: true,
+		},
+		{
+			Name:  "tls/store-load",
+			Stack: vals{rvm.Int(5)},
+			Code: code{
+				must(rvm.EncodeLoad(rvm.Reg(3), rvm.Imm(9))),
+				must(rvm.EncodeTLSStore(0, rvm.Stack(0))),
+				must(rvm.EncodeTLSStore(255, rvm.Reg(3))),
+				must(rvm.EncodeTLSLoad(rvm.Reg(4), 0)),
+				must(rvm.EncodeTLSLoad(rvm.Stack(-1), 255)),
+			},
+			Want: wants{
+				{rvm.TLSIndex(0), rvm.Int(5)},
+				{rvm.TLSIndex(255), rvm.Int(9)},
+				{reg(4), rvm.Int(5)},
+				{stk(0), rvm.Int(9)},
+			},
+		},
+		{
+			Name: "tls/load-unset",
+			Code: code{
+				must(rvm.EncodeLoad(rvm.Reg(3), rvm.Imm(1))),
+				must(rvm.EncodeTLSLoad(rvm.Reg(3), 7)),
+			},
+			Want: wants{{reg(3), nil}},
+		},
+	}
+}
+
+func pushPopCases() []Case {
+	three := vals{rvm.Int(1), rvm.Int(2), rvm.Int(3)}
+	five := vals{rvm.Int(1), rvm.Int(2), rvm.Int(3), rvm.Int(4), rvm.Int(5)}
+
+	return []Case{
+		{
+			Name: "push/registers",
+			Code: code{
+				must(rvm.EncodeLoad(rvm.Reg(3), rvm.Imm(1))),
+				must(rvm.EncodeLoad(rvm.Reg(4), rvm.Imm(2))),
+				must(rvm.EncodePushPop(rvm.OpPush, 2, rvm.Reg(3))),
+			},
+			WantStack: vals{rvm.Int(1), rvm.Int(2)},
+		},
+		{
+			Name:      "push/consts",
+			Consts:    vals{rvm.Int(7), rvm.Float(8), rvm.Uint(9)},
+			Code:      code{must(rvm.EncodePushPop(rvm.OpPush, 3, rvm.Const(0)))},
+			WantStack: vals{rvm.Int(7), rvm.Float(8), rvm.Uint(9)},
+		},
+		{
+			// An immediate is pushed n times, not incremented.
+			Name:      "push/immediate-repeats",
+			Code:      code{must(rvm.EncodePushPop(rvm.OpPush, 3, rvm.Imm(-1)))},
+			WantStack: vals{rvm.Int(-1), rvm.Int(-1), rvm.Int(-1)},
+		},
+		{
+			Name:      "push/stack-from-ebp",
+			Stack:     three,
+			Code:      code{must(rvm.EncodePushPop(rvm.OpPush, 2, rvm.Stack(1)))},
+			WantStack: vals{rvm.Int(1), rvm.Int(2), rvm.Int(3), rvm.Int(2), rvm.Int(3)},
+		},
+		{
+			// Each push moves the top of the stack, so a range relative to the top copies the values that were at
+			// src..src+n-1 before the push began, in order.
+			Name:      "push/stack-from-top",
+			Stack:     three,
+			Code:      code{must(rvm.EncodePushPop(rvm.OpPush, 2, rvm.Stack(-2)))},
+			WantStack: vals{rvm.Int(1), rvm.Int(2), rvm.Int(3), rvm.Int(2), rvm.Int(3)},
+		},
+		{
+			// A source range must lie within the stack before the push; it cannot read values pushed by the same
+			// instruction.
+			Name:    "push/stack-from-top-past-end",
+			Stack:   vals{rvm.Int(1), rvm.Int(2)},
+			Code:    code{must(rvm.EncodePushPop(rvm.OpPush, 3, rvm.Stack(-1)))},
+			WantErr: true,
+		},
+		{
+			// The first popped register receives the deepest value, so pop undoes push.
+			Name:      "pop/registers-in-stack-order",
+			Stack:     three,
+			Code:      code{must(rvm.EncodePushPop(rvm.OpPop, 2, rvm.Reg(3)))},
+			Want:      wants{{reg(3), rvm.Int(2)}, {reg(4), rvm.Int(3)}},
+			WantStack: vals{rvm.Int(1)},
+		},
+		{
+			Name:      "pop/discard",
+			Stack:     three,
+			Code:      code{must(rvm.EncodePushPop(rvm.OpPop, 2, rvm.Operand{}))},
+			WantStack: vals{rvm.Int(1)},
+		},
+		{
+			// Stack destinations are resolved after the values are popped and are stored in stack order.
+			Name:      "pop/stack-from-ebp",
+			Stack:     five,
+			Code:      code{must(rvm.EncodePushPop(rvm.OpPop, 2, rvm.Stack(0)))},
+			WantStack: vals{rvm.Int(4), rvm.Int(5), rvm.Int(3)},
+		},
+		{
+			Name:      "pop/stack-from-top",
+			Stack:     five,
+			Code:      code{must(rvm.EncodePushPop(rvm.OpPop, 2, rvm.Stack(-2)))},
+			WantStack: vals{rvm.Int(1), rvm.Int(4), rvm.Int(5)},
+		},
+		{
+			Name:    "pop/stack-past-top",
+			Stack:   three,
+			Code:    code{must(rvm.EncodePushPop(rvm.OpPop, 2, rvm.Stack(0)))},
+			WantErr: true,
+		},
+		{
+			Name:    "pop/underflow",
+			Stack:   vals{rvm.Int(1)},
+			Code:    code{must(rvm.EncodePushPop(rvm.OpPop, 2, rvm.Reg(3)))},
+			WantErr: true,
+		},
+	}
+}
+
+func stackOpCases() []Case {
+	four := vals{rvm.Int(1), rvm.Int(2), rvm.Int(3), rvm.Int(4)}
+
+	return []Case{
+		{
+			Name:      "dup/top",
+			Stack:     four,
+			Code:      code{must(rvm.EncodeStackOp(rvm.OpDup, 2, 0))},
+			WantStack: vals{rvm.Int(1), rvm.Int(2), rvm.Int(3), rvm.Int(4), rvm.Int(3), rvm.Int(4)},
+		},
+		{
+			Name:      "dup/depth",
+			Stack:     four,
+			Code:      code{must(rvm.EncodeStackOp(rvm.OpDup, 1, 3))},
+			WantStack: vals{rvm.Int(1), rvm.Int(2), rvm.Int(3), rvm.Int(4), rvm.Int(1)},
+		},
+		{
+			Name:    "dup/underflow",
+			Stack:   four,
+			Code:    code{must(rvm.EncodeStackOp(rvm.OpDup, 2, 3))},
+			WantErr: true,
+		},
+		{
+			Name:      "rot/forward",
+			Stack:     four,
+			Code:      code{must(rvm.EncodeStackOp(rvm.OpRotate, 3, 1))},
+			WantStack: vals{rvm.Int(1), rvm.Int(3), rvm.Int(4), rvm.Int(2)},
+		},
+		{
+			Name:      "rot/backward",
+			Stack:     four,
+			Code:      code{must(rvm.EncodeStackOp(rvm.OpRotate, 3, -1))},
+			WantStack: vals{rvm.Int(1), rvm.Int(4), rvm.Int(2), rvm.Int(3)},
+		},
+		{
+			Name:      "rot/full-turn",
+			Stack:     four,
+			Code:      code{must(rvm.EncodeStackOp(rvm.OpRotate, 4, 4))},
+			WantStack: four,
+		},
+		{
+			Name:      "alloc/grow",
+			Stack:     vals{rvm.Int(1)},
+			Code:      code{must(rvm.EncodeAlloc(rvm.Imm(2)))},
+			WantStack: vals{rvm.Int(1), nil, nil},
+		},
+		{
+			Name:      "alloc/release",
+			Stack:     four,
+			Code:      code{must(rvm.EncodeAlloc(rvm.Imm(-3)))},
+			WantStack: vals{rvm.Int(1)},
+		},
+		{
+			// Immediates too large for argB use the wide size field.
+			Name:      "alloc/wide-immediate",
+			Stack:     vals{rvm.Int(1)},
+			Code:      code{must(rvm.EncodeAlloc(rvm.Imm(100000))), must(rvm.EncodeAlloc(rvm.Imm(-99999)))},
+			WantStack: vals{rvm.Int(1), nil},
+		},
+		{
+			Name:    "alloc/release-below-ebp",
+			Stack:   vals{rvm.Int(1)},
+			Code:    code{must(rvm.EncodeAlloc(rvm.Imm(-2)))},
+			WantErr: true,
+		},
+		{
+			// Reserve changes capacity only.
+			Name:      "reserve",
+			Stack:     vals{rvm.Int(1)},
+			Code:      code{must(rvm.EncodeReserve(rvm.Imm(100)))},
+			WantStack: vals{rvm.Int(1)},
+		},
+	}
+}
+
+func controlCases() []Case {
+	// test lhs rhs; load %3 1; load %4 2 -- %3 is set only if the test passes.
+	test := func(name string, op rvm.CompareOp, want bool, lhs, rhs rvm.Value, pass bool) Case {
+		c := Case{
+			Name:   name,
+			Consts: vals{lhs, rhs},
+			Code: code{
+				must(rvm.EncodeLoad(rvm.Reg(3), rvm.Imm(0))),
+				must(rvm.EncodeLoad(rvm.Reg(5), rvm.Const(0))),
+				must(rvm.EncodeTest(op, want, rvm.Reg(5), rvm.Const(1))),
+				must(rvm.EncodeLoad(rvm.Reg(3), rvm.Imm(1))),
+				must(rvm.EncodeLoad(rvm.Reg(4), rvm.Imm(2))),
+			},
+			Want: wants{{reg(3), rvm.Int(0)}, {reg(4), rvm.Int(2)}},
+		}
+		if pass {
+			c.Want[0].Value = rvm.Int(1)
+		}
+		return c
+	}
+
+	return []Case{
+		test("test/less-pass", rvm.CmpLess, true, rvm.Int(1), rvm.Int(2), true),
+		test("test/less-fail", rvm.CmpLess, true, rvm.Int(2), rvm.Int(2), false),
+		test("test/less-want-false", rvm.CmpLess, false, rvm.Int(2), rvm.Int(2), true),
+		test("test/lequal", rvm.CmpLequal, true, rvm.Int(2), rvm.Int(2), true),
+		test("test/equal-mixed", rvm.CmpEqual, true, rvm.Int(2), rvm.Float(2), true),
+		test("test/equal-sign", rvm.CmpEqual, true, rvm.Int(-1), rvm.Uint(1<<64-1), false),
+		test("test/not-equal", rvm.CmpNotEqual, true, rvm.Int(1), rvm.Int(2), true),
+		test("test/greater", rvm.CmpGreater, true, rvm.Float(2.5), rvm.Int(2), true),
+		test("test/gequal-fail", rvm.CmpGequal, true, rvm.Uint(1), rvm.Int(2), false),
+
+		{
+			Name: "jump/forward",
+			Code: code{
+				must(rvm.EncodeJump(1, rvm.Operand{})),
+				must(rvm.EncodeLoad(rvm.Reg(3), rvm.Imm(1))),
+				must(rvm.EncodeLoad(rvm.Reg(4), rvm.Imm(2))),
+			},
+			Want: wants{{reg(3), nil}, {reg(4), rvm.Int(2)}},
+		},
+		{
+			Name: "jump/register",
+			Code: code{
+				must(rvm.EncodeLoad(rvm.Reg(5), rvm.Imm(1))),
+				must(rvm.EncodeJump(0, rvm.Reg(5))),
+				must(rvm.EncodeLoad(rvm.Reg(3), rvm.Imm(1))),
+				must(rvm.EncodeLoad(rvm.Reg(4), rvm.Imm(2))),
+			},
+			Want: wants{{reg(3), nil}, {reg(4), rvm.Int(2)}},
+		},
+		{
+			// A test that passes executes a following jump immediately; one that fails skips it.
+			Name:   "test/loop",
+			Consts: vals{rvm.Int(5)},
+			Code: code{
+				must(rvm.EncodeLoad(rvm.Reg(3), rvm.Imm(0))),
+				must(rvm.EncodeBinary(rvm.OpAdd, rvm.Reg(3), rvm.Reg(3), rvm.Imm(1))),
+				must(rvm.EncodeTest(rvm.CmpLess, true, rvm.Reg(3), rvm.Const(0))),
+				must(rvm.EncodeJump(-3, rvm.Operand{})),
+				must(rvm.EncodeLoad(rvm.Reg(4), rvm.Reg(3))),
+			},
+			Want: wants{{reg(3), rvm.Int(5)}, {reg(4), rvm.Int(5)}},
+		},
+		{
+			Name: "pc/store",
+			Code: code{
+				must(rvm.EncodeLoad(rvm.Reg(0), rvm.Imm(2))),
+				must(rvm.EncodeLoad(rvm.Reg(3), rvm.Imm(1))),
+				must(rvm.EncodeLoad(rvm.Reg(4), rvm.Imm(2))),
+			},
+			Want: wants{{reg(3), nil}, {reg(4), rvm.Int(2)}},
+		},
+	}
+}
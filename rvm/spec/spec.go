@@ -0,0 +1,123 @@
+// Package spec is an executable specification of the rvm instruction set. Each Case runs a short function on a fresh
+// machine and lists the values its registers and stack must hold afterward. The reference interpreter, rvm.Thread,
+// passes every case; alternate backends (e.g., a JIT or AOT compiler) can implement Machine and call RunTests to check
+// that they agree with it.
+package spec
+
+import (
+	"fmt"
+	"testing"
+
+	"go.spiff.io/rusalka/rvm"
+)
+
+// Machine is a backend under test.
+type Machine interface {
+	// Run pushes the values of stack, enters fn with those values as the start of its frame, and runs fn until it
+	// runs out of code. If fn panics, Run returns the panic as an error.
+	Run(stack []rvm.Value, fn rvm.Function) error
+	// At returns the value at ix after Run returns.
+	At(ix rvm.Index) rvm.Value
+}
+
+// Case is a single specification case.
+type Case struct {
+	Name   string
+	Stack  []rvm.Value // Values on the stack when the function is entered
+	Code   []rvm.Instruction
+	Consts []rvm.Value
+
+	// Want lists values that must be found at each index after the function runs.
+	Want []Want
+	// WantStack, if not nil, is the entire stack after the function runs.
+	WantStack []rvm.Value
+	// WantErr is whether the function must fail with a runtime panic. Want and WantStack are not checked if it does.
+	WantErr bool
+}
+
+// Want is a value expected at an index.
+type Want struct {
+	Index rvm.Index
+	Value rvm.Value
+}
+
+// Function returns the case's code and constants as an rvm.Function, taking the case's stack values as its arguments.
+func (c *Case) Function() rvm.Function {
+	var code []uint32
+	for _, instr := range c.Code {
+		code = instr.AppendTo(code)
+	}
+	return rvm.Function{Info: rvm.FuncInfo{Name: c.Name, Arity: len(c.Stack)}, Code: code, Consts: c.Consts}
+}
+
+// Check runs the case on m and returns an error describing every way its result differs from the case.
+func (c *Case) Check(m Machine) error {
+	err := m.Run(c.Stack, c.Function())
+	switch {
+	case c.WantErr && err == nil:
+		return fmt.Errorf("%s: expected a runtime panic", c.Name)
+	case c.WantErr:
+		return nil
+	case err != nil:
+		return fmt.Errorf("%s: unexpected error: %v", c.Name, err)
+	}
+
+	var errs []string
+	for _, w := range c.Want {
+		if got := m.At(w.Index); got != w.Value {
+			errs = append(errs, fmt.Sprintf("%v = %#v; want %#v", w.Index, got, w.Value))
+		}
+	}
+
+	if c.WantStack != nil {
+		sp := m.At(rvm.RegisterIndex(2))
+		if sp != rvm.Int(len(c.WantStack)) {
+			errs = append(errs, fmt.Sprintf("stack size = %v; want %d", sp, len(c.WantStack)))
+		} else {
+			for i, want := range c.WantStack {
+				if got := m.At(rvm.StackIndex(i)); got != want {
+					errs = append(errs, fmt.Sprintf("stack[%d] = %#v; want %#v", i, got, want))
+				}
+			}
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	msg := c.Name + ":"
+	for _, e := range errs {
+		msg += "\n\t" + e
+	}
+	return fmt.Errorf("%s", msg)
+}
+
+// RunTests runs every case in Cases as a subtest of t, using a new Machine from newMachine for each.
+func RunTests(t *testing.T, newMachine func() Machine) {
+	for _, c := range Cases() {
+		c := c
+		t.Run(c.Name, func(t *testing.T) {
+			if err := c.Check(newMachine()); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+// ThreadMachine is the reference Machine, backed by an rvm.Thread.
+type ThreadMachine struct {
+	*rvm.Thread
+}
+
+// NewThreadMachine returns a ThreadMachine with a new Thread.
+func NewThreadMachine() *ThreadMachine {
+	return &ThreadMachine{rvm.NewThread()}
+}
+
+func (m *ThreadMachine) Run(stack []rvm.Value, fn rvm.Function) error {
+	for _, v := range stack {
+		m.Push(v)
+	}
+	m.Enter(fn, len(stack))
+	return m.RunProtected()
+}
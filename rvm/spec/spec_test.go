@@ -0,0 +1,7 @@
+package spec
+
+import "testing"
+
+func TestThreadMachine(t *testing.T) {
+	RunTests(t, func() Machine { return NewThreadMachine() })
+}
@@ -8,9 +8,14 @@ import (
 
 type RuntimePanic struct {
 	Value interface{}
+	// Trace holds the thread's active frames at the time of the panic, innermost first.
+	Trace []Frame
 }
 
 func (r *RuntimePanic) Error() string {
+	if len(r.Trace) > 0 {
+		return fmt.Sprint("panic: ", r.Value, " (in ", r.Trace[0], ")")
+	}
 	return fmt.Sprint("panic: ", r.Value)
 }
 
@@ -32,6 +37,10 @@ const (
 
 	maxInt = int(^uint(0) >> 1)
 	minInt = -(maxInt - 1)
+
+	// maxStackLen is the greatest number of values the stack may hold. Growing the stack past it panics with
+	// ErrOverflow, rather than letting a script exhaust the host's memory.
+	maxStackLen = 1 << 26
 )
 
 var (
@@ -39,9 +48,11 @@ var (
 	ErrPCRange       = fmt.Errorf("PC outside range 0..%d", maxInt)
 	ErrStackRange    = errors.New("stack index out of range")
 	ErrUnderflow     = errors.New("stack underflow")
+	ErrOverflow      = errors.New("stack overflow")
 
-	errConstStore = errors.New("cannot write to constants table")
-	errEBPStore   = errors.New("cannot write to %ebp")
+	errConstStore     = errors.New("cannot write to constants table")
+	errImmediateStore = errors.New("cannot write to an immediate")
+	errEBPStore       = errors.New("cannot write to %ebp")
 )
 
 type funcData struct {
@@ -50,13 +61,68 @@ type funcData struct {
 	code []uint32
 	// constants that may be referenced by instructions
 	consts []Value
+	// info describes the function for diagnostics and bounds its register usage
+	info FuncInfo
 
 	// NOTE: Consider adding a constant page-shifting instruction to handle constants outside a [0, 2047] range.
 }
 
+// FuncInfo is metadata describing a function.
+type FuncInfo struct {
+	// Name is the function's name. It may be empty for anonymous functions.
+	Name string
+	// Arity is the number of fixed parameters the function takes. Enter checks its argument count against it.
+	Arity int
+	// Variadic is true if the function accepts arguments beyond its Arity.
+	Variadic bool
+	// Registers is the number of call registers (%3 through %18) the function uses. If zero, all call registers are
+	// available. Only the registers in this window are saved when the function is entered and restored when it
+	// returns, so registers outside of it still hold the caller's values and must not be accessed. Builds with the
+	// rvmdebug tag check this for each instruction; other builds do not check it.
+	Registers int
+}
+
+func (f FuncInfo) String() string {
+	name := f.Name
+	if name == "" {
+		name = "<anonymous>"
+	}
+	name += "/" + strconv.Itoa(f.Arity)
+	if f.Variadic {
+		name += "..."
+	}
+	return name
+}
+
+// Function is a function's code and the constants its instructions reference.
+type Function struct {
+	Info   FuncInfo
+	Code   []uint32
+	Consts []Value
+	// ConstBase is the index in Consts of the function's const[0]. Functions linked into a module can share one merged
+	// constant pool as Consts, each with its own ConstBase, so relocating a function's constants within the pool only
+	// changes ConstBase and not its code.
+	ConstBase int
+	// ConstLen is the number of constants from ConstBase that belong to the function. If it is zero, the function's
+	// constants run to the end of Consts. Setting it keeps a function in a shared pool from reading the constants of
+	// the functions after it.
+	ConstLen int
+}
+
+// Frame describes an active call frame.
+type Frame struct {
+	Func FuncInfo
+	PC   int64
+}
+
+func (f Frame) String() string {
+	return f.Func.String() + " at pc " + strconv.FormatInt(f.PC, 10)
+}
+
 type stackFrame struct {
-	ebp   int // starting ebp of this frame
-	local [callRegisters]Value
+	ebp   int // current ebp of this frame
+	base  int // starting ebp of this frame
+	saved int // number of the caller's call registers saved in Thread.saved when this frame was pushed
 	funcData
 }
 
@@ -64,7 +130,119 @@ type Thread struct {
 	stackFrame
 	stack  []Value
 	frames []stackFrame
-	reg    [volatileRegisters]Value
+	local  [callRegisters]Value
+	// saved holds the call registers saved by pushFrame, one block per frame, with the current frame's block last.
+	// See savedLocals.
+	saved []Value
+	// canaries holds the stack canary of each frame, indexed by depth - 1, when built with the rvmcanary tag.
+	canaries []frameCanary
+	reg      [volatileRegisters]Value
+	audit    threadAudit
+	pause    pauseState
+	stats    Stats
+	zero     ZeroPolicy
+	panics   PanicPolicy
+	faults   FaultPolicy
+
+	hostLocals map[interface{}]interface{} // See SetLocal
+	tls        []Value                     // Bytecode-visible thread-local storage; see TLSIndex
+}
+
+// ZeroPolicy controls when stack slots released by pops and frame returns are cleared.
+type ZeroPolicy int
+
+const (
+	// ZeroOnRelease clears stack slots as soon as they are released, so the thread never retains references to values
+	// no longer on its stack. This is the default.
+	ZeroOnRelease ZeroPolicy = iota
+	// ZeroOnReuse leaves released stack slots as-is and only clears them when the stack grows back over them without
+	// writing to them (e.g., through OpAlloc or %esp). This makes pop-heavy code cheaper, but released values remain
+	// reachable by the garbage collector until overwritten.
+	ZeroOnReuse
+)
+
+// SetZeroPolicy sets the thread's stack zeroing policy. Switching to ZeroOnRelease clears any released slots left by
+// ZeroOnReuse.
+func (th *Thread) SetZeroPolicy(policy ZeroPolicy) {
+	if auditing {
+		th.audit.enter()
+		defer th.audit.exit()
+	}
+	if policy == ZeroOnRelease && th.zero != ZeroOnRelease {
+		clearValues(th.stack[len(th.stack):cap(th.stack)])
+	}
+	th.zero = policy
+}
+
+// SetLocal stores value under key in the thread's host-side storage, or deletes key if value is nil. The storage is
+// not visible to bytecode; it lets host code that runs on the thread share state (e.g., per-request data) without
+// globals. As with context.WithValue, key should be of an unexported type to avoid collisions between packages.
+func (th *Thread) SetLocal(key, value interface{}) {
+	if auditing {
+		th.audit.enter()
+		defer th.audit.exit()
+	}
+	if value == nil {
+		delete(th.hostLocals, key)
+		return
+	}
+	if th.hostLocals == nil {
+		th.hostLocals = make(map[interface{}]interface{})
+	}
+	th.hostLocals[key] = value
+}
+
+// Local returns the value stored under key by SetLocal, or nil if there is none.
+func (th *Thread) Local(key interface{}) interface{} {
+	if auditing {
+		th.audit.enter()
+		defer th.audit.exit()
+	}
+	return th.hostLocals[key]
+}
+
+// Stats holds usage statistics collected by a thread since it was created or its statistics were last reset.
+type Stats struct {
+	// MaxStack is the greatest number of values held by the stack.
+	MaxStack int
+	// MaxFrames is the greatest number of active frames, including the thread's initial frame.
+	MaxFrames int
+	// StackGrowths is the number of times the stack's backing array was reallocated.
+	StackGrowths int
+}
+
+// Stats returns the thread's usage statistics.
+func (th *Thread) Stats() Stats {
+	if auditing {
+		th.audit.enter()
+		defer th.audit.exit()
+	}
+	return th.stats
+}
+
+// ResetStats resets the thread's usage statistics to reflect only its current stack and frames.
+func (th *Thread) ResetStats() {
+	if auditing {
+		th.audit.enter()
+		defer th.audit.exit()
+	}
+	th.stats = Stats{}
+	th.trackStack()
+	th.trackFrames()
+}
+
+// trackStack records the current stack length in the thread's statistics. It must be called after the stack grows.
+func (th *Thread) trackStack() {
+	if n := len(th.stack); n > th.stats.MaxStack {
+		th.stats.MaxStack = n
+	}
+}
+
+// trackFrames records the current frame depth in the thread's statistics.
+func (th *Thread) trackFrames() {
+	if n := len(th.frames) + 1; n > th.stats.MaxFrames {
+		th.stats.MaxFrames = n
+	}
 }
 
 // NewThread allocates a new VM thread.
@@ -75,6 +253,7 @@ func NewThread() *Thread {
 	th := &Thread{
 		stack:  make([]Value, 0, defaultStackSize),
 		frames: make([]stackFrame, 0, defaultFrameSize),
+		stats:  Stats{MaxFrames: 1},
 	}
 	return th
 }
@@ -84,19 +263,56 @@ func NewThread() *Thread {
 func (th *Thread) pushFrame(ebpOffset int, fn funcData) {
 	if ebpOffset > 0 {
 		panic(InvalidStackIndex(len(th.stack) + ebpOffset))
-	} else if th.ebp-ebpOffset > len(th.stack)+ebpOffset {
+	} else if len(th.stack)+ebpOffset < th.ebp {
 		panic(ErrUnderflow)
 	}
+	ebp := len(th.stack) + ebpOffset
+	if stackCanaries {
+		th.pushCanary(ebp)
+	}
+
 	th.frames = append(th.frames, th.stackFrame)
+	th.trackFrames()
 
-	// Copy registers (may be used for argument passing)
+	// Save only the registers the function may use. The function sees the caller's values in them, which may be used
+	// for argument passing.
+	n := fn.localRegisters()
+	th.saved = append(th.saved, th.local[:n]...)
 	th.stackFrame = stackFrame{
-		ebp:      len(th.stack) + ebpOffset,
-		local:    th.local,
+		ebp:      ebp,
+		base:     ebp,
+		saved:    n,
 		funcData: fn,
 	}
 }
 
+// restoreLocals restores the call registers saved when the current frame was pushed.
+func (th *Thread) restoreLocals() {
+	base := len(th.saved) - th.stackFrame.saved
+	copy(th.local[:], th.saved[base:])
+	clearValues(th.saved[base:])
+	th.saved = th.saved[:base]
+}
+
+// savedLocals returns the call registers of the frame at depth, 1 or greater, that were saved when the frame above it
+// was pushed. The frame's other call registers were not saved, and hold the same values as the frame above it.
+func (th *Thread) savedLocals(depth int) []Value {
+	end, n := len(th.saved), th.stackFrame.saved
+	for d := 1; d < depth; d++ {
+		end -= n
+		n = th.frames[len(th.frames)-d].saved
+	}
+	return th.saved[end-n : end]
+}
+
+// localRegisters returns the number of call registers available to the function.
+func (fn *funcData) localRegisters() int {
+	if n := fn.info.Registers; n > 0 && n <= callRegisters {
+		return n
+	}
+	return callRegisters
+}
+
 func (th *Thread) step(advance bool) (n int64, i Instruction, ok bool) {
 	pc, codelen := th.pc, int64(len(th.code))
 	if pc >= codelen {
@@ -118,9 +334,29 @@ func (th *Thread) step(advance bool) (n int64, i Instruction, ok bool) {
 	return n, i, true
 }
 
+// jump moves the pc by delta instruction words.
+func (th *Thread) jump(delta int64) {
+	th.setPC(th.pc + delta)
+}
+
+// setPC sets the pc, which may be anywhere in the current function's code or at its end. Other values panic with an
+// InvalidPC.
+func (th *Thread) setPC(pc int64) {
+	if uint64(pc) > uint64(len(th.code)) {
+		panic(InvalidPC(pc))
+	}
+	th.pc = pc
+}
+
 func (th *Thread) replaceFrame(keep int, fn funcData) {
-	th.copyAndResizeStack(th.ebp, keep)
+	th.copyAndResizeStack(th.base, keep)
+	th.ebp = th.base
 	th.funcData = fn
+	// The caller's registers outside the old window are untouched, so save any the new function may use.
+	if n := fn.localRegisters(); n > th.stackFrame.saved {
+		th.saved = append(th.saved, th.local[th.stackFrame.saved:n]...)
+		th.stackFrame.saved = n
+	}
 }
 
 func (th *Thread) popFrame(keep int) {
@@ -131,21 +367,86 @@ func (th *Thread) popFrame(keep int) {
 
 	frame := &th.frames[top]
 	th.frames = th.frames[:top]
-	th.copyAndResizeStack(th.ebp, keep)
+	if stackCanaries {
+		th.checkCanary(top + 1)
+		th.canaries = th.canaries[:top]
+	}
+	th.copyAndResizeStack(th.base, keep)
+	th.restoreLocals()
 
 	th.stackFrame = *frame
 	*frame = stackFrame{}
+	if stackCanaries && top > 0 {
+		th.checkCanary(top)
+	}
 }
 
-// copyAndResizeStack resizes the stack to `newTop` plus `keep` elements from the top of the stack. The new stack top
-// and the elements to keep may not overlap.
-func (th *Thread) copyAndResizeStack(newTop, keep int) {
-	if keep < 0 {
+// allocStack allocates n nil elements on top of the stack for use as frame-local scratch space. If n is negative, -n
+// elements are released from the top of the stack instead. Elements below the frame's ebp cannot be released.
+func (th *Thread) allocStack(n int) {
+	if n > maxStackLen {
+		panic(ErrOverflow)
+	}
+	th.setStackTop(len(th.stack) + n)
+}
+
+// setStackTop grows or truncates the stack so that its length is sp. New elements are nil. The stack cannot be
+// truncated below the frame's ebp.
+func (th *Thread) setStackTop(sp int) {
+	esp := len(th.stack)
+	if sp < th.ebp {
 		panic(ErrUnderflow)
 	}
 
-	if newTop < 0 {
+	switch {
+	case sp < esp:
+		th.resizeStack(sp)
+	case sp > cap(th.stack):
+		th.growStack(sp - esp)
+		fallthrough
+	case sp > esp:
+		th.stack = th.stack[0:sp:cap(th.stack)]
+		if th.zero == ZeroOnReuse {
+			clearValues(th.stack[esp:])
+		}
+		th.trackStack()
+	}
+}
+
+// adjustFrame moves the current frame's ebp by delta elements. The new ebp may not fall below the ebp the frame was
+// entered with or exceed the top of the stack. Any stack space between the frame's starting ebp and its current ebp is
+// released when the frame is popped or replaced.
+func (th *Thread) adjustFrame(delta int) {
+	ebp := th.ebp + delta
+	if ebp < th.base {
 		panic(ErrUnderflow)
+	} else if ebp > len(th.stack) {
+		panic(InvalidStackIndex(ebp))
+	}
+	th.ebp = ebp
+}
+
+// StackTransferError is the panic value raised when values cannot be moved down the stack, such as when a frame returns
+// more values than it holds. The keep values at the top of a stack of length Len are moved down to start at NewTop,
+// and the stack is truncated after them. This requires 0 <= NewTop and 0 <= Keep, and NewTop+Keep <= Len: values may
+// only move down the stack, so the destination range ends at or below the end of the values being moved. The two
+// ranges may overlap.
+type StackTransferError struct {
+	NewTop int
+	Keep   int
+	Len    int
+}
+
+func (e *StackTransferError) Error() string {
+	return fmt.Sprintf("cannot move %d values from the top of a stack of %d values to index %d", e.Keep, e.Len,
+		e.NewTop)
+}
+
+// copyAndResizeStack resizes the stack to `newTop` plus `keep` elements from the top of the stack. The elements to
+// keep may only move down, so `newTop+keep` may not be greater than the current stack length. See StackTransferError.
+func (th *Thread) copyAndResizeStack(newTop, keep int) {
+	if keep < 0 || newTop < 0 || newTop > len(th.stack)-keep {
+		panic(&StackTransferError{NewTop: newTop, Keep: keep, Len: len(th.stack)})
 	} else if newTop+keep == len(th.stack) {
 		return
 	}
@@ -154,42 +455,197 @@ func (th *Thread) copyAndResizeStack(newTop, keep int) {
 	// We want to be able to use the stack to transfer values from a child frame to a parent frame, such as multiple
 	// return values or other data that might be useful to a tailcall.
 	if keep > 0 {
-		oldTop := len(th.stack) - keep
-		if newTop > oldTop {
-			panic(ErrUnderflow)
-		}
-
-		copy(th.stack[newTop:], th.stack[oldTop:])
+		copy(th.stack[newTop:], th.stack[len(th.stack)-keep:])
 	}
 
 	th.resizeStack(newTop + keep)
 }
 
+// Return pops the current frame, as entered by Enter, and resumes the frame below it. The top keep values of the
+// frame's stack are moved to where the frame's stack began, which is where its arguments were before Enter, and the
+// rest of the frame's stack is released. The call registers are restored to the values they had before Enter.
+//
+// Return panics with ErrUnderflow if no frame has been entered, and with a *StackTransferError if keep is negative or
+// greater than the number of values the frame holds, counted from where its stack began.
+func (th *Thread) Return(keep int) {
+	if auditing {
+		th.audit.enter()
+		defer th.audit.exit()
+	}
+	if len(th.frames) == 0 {
+		panic(ErrUnderflow)
+	} else if keep < 0 || keep > len(th.stack)-th.base {
+		panic(&StackTransferError{NewTop: th.base, Keep: keep, Len: len(th.stack)})
+	}
+	th.popFrame(keep)
+}
+
+// Enter pushes a new frame running fn, starting at its first instruction. The top args values of the stack become the
+// first values of the new frame (stack[0] onward), and the current call registers are copied into it. Use Run or RunN
+// to execute the frame.
+//
+// Enter panics if args is not fn.Info.Arity, or is less than it if fn.Info.Variadic is true.
+func (th *Thread) Enter(fn Function, args int) {
+	if auditing {
+		th.audit.enter()
+		defer th.audit.exit()
+	}
+	if args < 0 {
+		panic(fmt.Errorf("negative argument count: %d", args))
+	} else if args < fn.Info.Arity || args > fn.Info.Arity && !fn.Info.Variadic {
+		panic(fmt.Errorf("%v called with %d arguments", fn.Info, args))
+	} else if fn.ConstBase < 0 || fn.ConstBase > len(fn.Consts) {
+		panic(InvalidConstIndex(fn.ConstBase))
+	}
+	end := len(fn.Consts)
+	if fn.ConstLen != 0 {
+		end = fn.ConstBase + fn.ConstLen
+		if fn.ConstLen < 0 || end > len(fn.Consts) {
+			panic(InvalidConstIndex(end))
+		}
+	}
+	th.pushFrame(-args, funcData{code: fn.Code, consts: fn.Consts[fn.ConstBase:end:end], info: fn.Info})
+}
+
+// RunProtected runs the thread as Run does, but recovers a panic and returns it as a *RuntimePanic, unless the thread's
+// PanicPolicy says otherwise.
 func (th *Thread) RunProtected() (err error) {
+	if auditing {
+		th.audit.enter()
+		defer th.audit.exit()
+	}
 	defer func() {
 		if rc := recover(); rc != nil {
-			err = &RuntimePanic{rc}
+			err = th.recoverPanic(rc)
 		}
 	}()
 	th.Run()
 	return nil
 }
 
+// Backtrace returns the thread's active frames, innermost first. The PC of each frame is the index of the next
+// instruction it will execute.
+func (th *Thread) Backtrace() []Frame {
+	if auditing {
+		th.audit.enter()
+		defer th.audit.exit()
+	}
+	trace := make([]Frame, 0, len(th.frames)+1)
+	trace = append(trace, Frame{Func: th.info, PC: th.pc})
+	for i := len(th.frames) - 1; i >= 0; i-- {
+		frame := &th.frames[i]
+		trace = append(trace, Frame{Func: frame.info, PC: frame.pc})
+	}
+	return trace
+}
+
 func (th *Thread) Run() {
-	for codelen := int64(len(th.code)); th.pc < codelen; {
+	if auditing {
+		th.audit.enter()
+		defer th.audit.exit()
+	}
+	th.pause.setRunning(true)
+	defer th.pause.setRunning(false)
+	var executed int
+	if th.faults != (FaultPolicy{}) {
+		th.runTrapped(-1, &executed)
+		return
+	}
+	th.runLoop(-1, &executed)
+}
+
+// runLoop executes instructions until *executed reaches limit, or until the thread runs out of code if limit is
+// negative. It counts each instruction in *executed once it completes, so the count is kept if an instruction panics.
+// It is the interpreter loop behind Run and RunN.
+func (th *Thread) runLoop(limit int, executed *int) {
+	for codelen := int64(len(th.code)); *executed != limit && th.pc < codelen; *executed++ {
+		th.safePoint()
 		_, instr, ok := th.step(true)
 		if !ok {
 			panic(fmt.Sprint("invalid instruction at code index ", th.pc))
 		}
+		if instrAssertions {
+			th.assertInstr(instr)
+		}
 		instr.execer()(instr, th)
 	}
 }
 
+// runTrapped is runLoop for threads with a fault policy. It runs one instruction at a time, so that if an instruction
+// faults and the policy handles the fault, the thread continues after that instruction, counting it as executed. Only
+// threads with a fault policy use it, so the plain loop does not pay for recovering panics.
+func (th *Thread) runTrapped(limit int, executed *int) {
+	for *executed != limit && th.pc < int64(len(th.code)) {
+		*executed += th.runTrappedStep()
+	}
+}
+
+// runTrappedStep executes the next instruction, returning 1 if it executed or its fault was handled.
+func (th *Thread) runTrappedStep() (executed int) {
+	code, pc := th.code, th.pc
+	defer func() {
+		if rc := recover(); rc != nil {
+			instr := Instruction(code[pc])
+			if instr.isExt() && pc+1 < int64(len(code)) {
+				instr |= Instruction(code[pc+1]) << 32
+			}
+			if !th.trapFault(rc, instr) {
+				panic(rc)
+			}
+			executed = 1
+		}
+	}()
+	th.runLoop(1, &executed)
+	return executed
+}
+
+// RunN executes at most n instructions, returning the number executed and whether the thread has run out of code. If
+// the thread panics, RunN recovers and returns the panic as a *RuntimePanic with done set to true, unless the thread's
+// PanicPolicy says otherwise. The instructions that completed before the panic are still counted in executed; the one
+// that panicked is not.
+func (th *Thread) RunN(n int) (executed int, done bool, err error) {
+	if auditing {
+		th.audit.enter()
+		defer th.audit.exit()
+	}
+	defer func() {
+		if rc := recover(); rc != nil {
+			done, err = true, th.recoverPanic(rc)
+		}
+	}()
+	th.pause.setRunning(true)
+	defer th.pause.setRunning(false)
+
+	if n < 0 {
+		n = 0
+	}
+	if th.faults != (FaultPolicy{}) {
+		th.runTrapped(n, &executed)
+	} else {
+		th.runLoop(n, &executed)
+	}
+	return executed, th.pc >= int64(len(th.code)), nil
+}
+
 func (th *Thread) Push(v Value) {
+	if auditing {
+		th.audit.enter()
+	}
+	if len(th.stack) == cap(th.stack) {
+		th.growStack(1)
+	}
 	th.stack = append(th.stack, v)
+	th.trackStack()
+	if auditing {
+		th.audit.exit()
+	}
 }
 
 func (th *Thread) Pop() (v Value) {
+	if auditing {
+		th.audit.enter()
+		defer th.audit.exit()
+	}
 	top := len(th.stack) - 1
 	if top < 0 {
 		panic(ErrUnderflow)
@@ -199,30 +655,186 @@ func (th *Thread) Pop() (v Value) {
 	return v
 }
 
+// dup pushes copies of the n values found depth values below the top of the stack, preserving their order. A depth of
+// zero duplicates the top n values; dup(1, 1) copies the second value from the top (i.e., "over").
+func (th *Thread) dup(n, depth int) {
+	from := len(th.stack) - (n + depth)
+	if n < 0 || depth < 0 || from < 0 {
+		panic(ErrUnderflow)
+	}
+	th.growStack(n)
+	th.stack = append(th.stack, th.stack[from:from+n]...)
+	th.trackStack()
+}
+
+// rotate rotates the top n values of the stack by shift positions. A positive shift moves the deepest of the n values
+// to the top of the stack and the rest down, such that rotate(3, 1) turns [a, b, c] into [b, c, a]. A negative shift
+// rotates in the other direction.
+func (th *Thread) rotate(n, shift int) {
+	top := len(th.stack)
+	if n < 0 || n > top {
+		panic(ErrUnderflow)
+	} else if n < 2 {
+		return
+	}
+
+	if shift %= n; shift < 0 {
+		shift += n
+	}
+	if shift == 0 {
+		return
+	}
+
+	s := th.stack[top-n:]
+	reverseValues(s[:shift])
+	reverseValues(s[shift:])
+	reverseValues(s)
+}
+
+func reverseValues(s []Value) {
+	for i, j := 0, len(s)-1; i < j; i, j = i+1, j-1 {
+		s[i], s[j] = s[j], s[i]
+	}
+}
+
+// Slice returns a view of n stack values starting at the ebp-relative index start. The view does not copy the values.
+func (th *Thread) Slice(start, n int) StackSlice {
+	if auditing {
+		th.audit.enter()
+		defer th.audit.exit()
+	}
+	if start < 0 || n < 0 || th.ebp+start+n > len(th.stack) {
+		panic(ErrStackRange)
+	}
+	return StackSlice{th: th, start: th.ebp + start, n: n}
+}
+
+// pushList pushes the values of each source in list, which must be a PushList. All sources are loaded before any
+// values are pushed, so top-relative stack indices refer to the stack as it was before the push.
+func (th *Thread) pushList(list Value) {
+	srcs, ok := list.(PushList)
+	if !ok {
+		panic(fmt.Errorf("invalid push list type %T; must be PushList", list))
+	}
+
+	top := len(th.stack)
+	th.growStack(len(srcs))
+	vals := th.stack[top : top+len(srcs)]
+	for i, src := range srcs {
+		vals[i] = src.load(th)
+	}
+	th.stack = th.stack[:top+len(srcs)]
+	th.trackStack()
+}
+
+// drop removes n values from the top of the stack.
+func (th *Thread) drop(n int) {
+	top := len(th.stack) - n
+	if top < 0 {
+		panic(ErrUnderflow)
+	}
+	th.resizeStack(top)
+}
+
 func (th *Thread) At(i Index) Value {
 	if i == nil {
 		panic("nil index")
 	}
+	if auditing {
+		th.audit.enter()
+		defer th.audit.exit()
+	}
 	return i.load(th)
 }
 
-// growStack grows the stack's capacity by at least elems entries. This does not resize the stack.
+// Roots calls fn for each non-nil Value the thread holds a reference to: the stack, the volatile registers, the TLS
+// slots, the values stored with SetLocal (but not their keys), and the locals and constants of the current frame and
+// every saved frame. These are the same roots that DumpHeap writes. Iteration stops early if fn returns false.
+//
+// Values are visited in no particular order and may be visited more than once (e.g., a constant shared by multiple
+// frames).
+func (th *Thread) Roots(fn func(Value) bool) {
+	if auditing {
+		th.audit.enter()
+		defer th.audit.exit()
+	}
+	visit := func(vs []Value) bool {
+		for _, v := range vs {
+			if v != nil && !fn(v) {
+				return false
+			}
+		}
+		return true
+	}
+
+	if !visit(th.stack) || !visit(th.reg[:]) || !visit(th.tls) {
+		return
+	}
+	for _, v := range th.hostLocals {
+		if !fn(v) {
+			return
+		}
+	}
+	if !visit(th.local[:]) || !visit(th.consts) {
+		return
+	}
+
+	if !visit(th.saved) {
+		return
+	}
+	for i := len(th.frames) - 1; i >= 0; i-- {
+		if !visit(th.frames[i].consts) {
+			return
+		}
+	}
+}
+
+// Reserve ensures the stack has capacity for at least n more values than it currently holds, so that pushing them
+// does not reallocate the stack. Reserving space past the stack's size limit panics with ErrOverflow.
+func (th *Thread) Reserve(n int) {
+	if auditing {
+		th.audit.enter()
+		defer th.audit.exit()
+	}
+	if n < 0 {
+		panic(ErrStackRange)
+	}
+	th.growStack(n)
+}
+
+// growStack ensures the stack's capacity can hold at least elems more values than its current length. This does not
+// resize the stack. All stack growth goes through growStack: when it reallocates, the new capacity is at least double
+// the old capacity, up to maxStackLen, so repeated growth is amortized. Growing the stack past maxStackLen panics with
+// ErrOverflow.
+//
+// Under ZeroOnRelease, the old backing array is cleared after copying so that no stale references to stack values
+// survive in it.
 func (th *Thread) growStack(elems int) {
 	var (
 		pred = th.stack
 		slen = len(pred)
 		next = slen + elems
 	)
-	if next <= cap(th.stack) {
+	if next <= cap(pred) {
 		return
+	} else if elems > maxStackLen-slen {
+		panic(ErrOverflow)
 	}
 
-	dup := make([]Value, len(pred), next)
-	copy(dup, th.stack)
+	if double := 2 * cap(pred); next < double {
+		next = double
+	}
+	if next > maxStackLen {
+		next = maxStackLen
+	}
+
+	dup := make([]Value, slen, next)
+	copy(dup, pred)
 	th.stack = dup
+	th.stats.StackGrowths++
 
-	for i := range pred {
-		pred[i] = nil
+	if th.zero == ZeroOnRelease {
+		clearValues(pred)
 	}
 }
 
@@ -233,15 +845,21 @@ func (th *Thread) resizeStack(top int) {
 	if curLen <= top {
 		return
 	}
-	// Zero stack tail (optimized to mem zero)
-	tail := th.stack[top:]
-	for i := range tail {
-		tail[i] = nil
+	if th.zero == ZeroOnRelease {
+		clearValues(th.stack[top:])
 	}
 	// Truncate stack
 	th.stack = th.stack[:top]
 }
 
+// clearValues sets all elements of vs to nil.
+func clearValues(vs []Value) {
+	// Optimized to mem zero
+	for i := range vs {
+		vs[i] = nil
+	}
+}
+
 // Indices for accessing thread storage (registers, stack, constants, the PC)
 
 type (
@@ -250,13 +868,21 @@ type (
 		store(th *Thread, v Value)
 	}
 
-	StackIndex    int
-	RegisterIndex int
-	constIndex    int
+	StackIndex     int
+	RegisterIndex  int
+	ConstIndex     int
+	ImmediateIndex int
+	TLSIndex       int // Slot in the thread's TLS area, in TLSRange
+
+	// PushList is a constant describing a list of sources, each pushed in order by an xpush instruction.
+	PushList []Index
 
 	InvalidRegister   int
+	InvalidPC         int64
 	InvalidStackIndex int
 	InvalidConstIndex int
+	InvalidTLSIndex   int
+	InvalidFrameDepth int
 )
 
 const (
@@ -269,6 +895,10 @@ func (i InvalidRegister) Error() string {
 	return fmt.Sprintf("register %d out of range 0..%d", i, registerCount-1)
 }
 
+func (i InvalidPC) Error() string {
+	return fmt.Sprintf("pc %d out of range", i)
+}
+
 func (i InvalidStackIndex) Error() string {
 	return fmt.Sprintf("stack index %d out of range", i)
 }
@@ -277,18 +907,158 @@ func (i InvalidConstIndex) Error() string {
 	return fmt.Sprintf("constant index %d out of range", i)
 }
 
-func (i constIndex) String() string {
+func (i InvalidTLSIndex) Error() string {
+	return fmt.Sprintf("TLS index %d out of range %v", i, TLSRange)
+}
+
+func (i InvalidFrameDepth) Error() string {
+	return fmt.Sprintf("frame depth %d out of range", i)
+}
+
+func (i ConstIndex) String() string {
 	return "const[" + strconv.Itoa(int(i)) + "]"
 }
 
-func (i constIndex) load(th *Thread) Value {
-	return th.consts[int(i)]
+func (i ConstIndex) load(th *Thread) Value {
+	if uint(i) >= uint(len(th.consts)) {
+		panic(InvalidConstIndex(i))
+	}
+	v := th.consts[int(i)]
+	if lazy, ok := v.(*Lazy); ok {
+		return lazy.load()
+	}
+	return v
 }
 
-func (constIndex) store(*Thread, Value) {
+func (ConstIndex) store(*Thread, Value) {
 	panic(errConstStore)
 }
 
+func (i TLSIndex) String() string {
+	return "tls[" + strconv.Itoa(int(i)) + "]"
+}
+
+// load returns the value in the TLS slot. Slots that have never been stored to are nil.
+func (i TLSIndex) load(th *Thread) Value {
+	if !TLSRange.Contains(int64(i)) {
+		panic(InvalidTLSIndex(i))
+	} else if int(i) >= len(th.tls) {
+		return nil
+	}
+	return th.tls[i]
+}
+
+// store stores v in the TLS slot, growing the TLS area to hold it if needed.
+func (i TLSIndex) store(th *Thread, v Value) {
+	if !TLSRange.Contains(int64(i)) {
+		panic(InvalidTLSIndex(i))
+	}
+	if n := int(i) + 1; n > len(th.tls) {
+		if v == nil {
+			return
+		}
+		th.tls = append(th.tls, make([]Value, n-len(th.tls))...)
+	}
+	th.tls[i] = v
+}
+
+// FrameIndex is a stack index in a caller's frame. Depth 1 is the frame that entered the current frame, 2 the frame
+// that entered that one, and so on, up to the thread's root frame. Index is relative to the caller frame's ebp if it is
+// 0 or greater, and relative to the top of the caller's frame (the start of the frame above it) if it is negative.
+//
+// A FrameIndex may only address values in the caller's own frame. Loads and stores outside of it panic with an
+// InvalidStackIndex holding the absolute stack index, and a depth greater than the number of frames panics with an
+// InvalidFrameDepth.
+type FrameIndex struct {
+	Depth int
+	Index StackIndex
+}
+
+func (i FrameIndex) String() string {
+	return "frame[" + strconv.Itoa(i.Depth) + "]." + i.Index.String()
+}
+
+// abs returns the absolute stack index of i.
+func (i FrameIndex) abs(th *Thread) int {
+	n := len(th.frames)
+	if i.Depth < 1 || i.Depth > n {
+		panic(InvalidFrameDepth(i.Depth))
+	}
+
+	frame, top := &th.frames[n-i.Depth], th.base
+	if i.Depth > 1 {
+		top = th.frames[n-i.Depth+1].base
+	}
+
+	abs := frame.ebp + int(i.Index)
+	if i.Index < 0 {
+		abs = top + int(i.Index)
+	}
+	if abs < frame.ebp || abs >= top {
+		panic(InvalidStackIndex(abs))
+	}
+	return abs
+}
+
+func (i FrameIndex) load(th *Thread) Value {
+	return th.stack[i.abs(th)]
+}
+
+func (i FrameIndex) store(th *Thread, v Value) {
+	abs := i.abs(th)
+	th.stack[abs] = v
+	if stackCanaries {
+		th.updateCanaries(abs)
+	}
+}
+
+// RelRegisterIndex is a register whose index is computed when it is used: the Int value of the Base register plus
+// Offset. The computed register must be a call or volatile register (%3 through %63); other indices, including the
+// special registers, panic with an InvalidRegister. This lets code select a register by value, such as an interpreter
+// keeping its virtual registers in a window of the register file, without a jump table.
+//
+// Relative registers can only be encoded by extended loads. See EncodeXload.
+type RelRegisterIndex struct {
+	Base   RegisterIndex
+	Offset int
+}
+
+func (i RelRegisterIndex) String() string {
+	if i.Offset < 0 {
+		return "%[" + i.Base.String() + strconv.Itoa(i.Offset) + "]"
+	}
+	return "%[" + i.Base.String() + "+" + strconv.Itoa(i.Offset) + "]"
+}
+
+// Register returns the register that i refers to in th's current state.
+func (i RelRegisterIndex) Register(th *Thread) RegisterIndex {
+	r := int64(toint(i.Base.load(th))) + int64(i.Offset)
+	if r < specialRegisters || r >= registerCount {
+		panic(InvalidRegister(r))
+	}
+	return RegisterIndex(r)
+}
+
+func (i RelRegisterIndex) load(th *Thread) Value {
+	return i.Register(th).load(th)
+}
+
+func (i RelRegisterIndex) store(th *Thread, v Value) {
+	i.Register(th).store(th, v)
+}
+
+func (i ImmediateIndex) String() string {
+	return "$" + strconv.Itoa(int(i))
+}
+
+func (i ImmediateIndex) load(*Thread) Value {
+	return Int(i)
+}
+
+func (ImmediateIndex) store(*Thread, Value) {
+	panic(errImmediateStore)
+}
+
 func (i StackIndex) String() string {
 	return "stack[" + strconv.Itoa(int(i)) + "]"
 }
@@ -309,11 +1079,19 @@ func (i StackIndex) Abs(th *Thread) StackIndex {
 }
 
 func (i StackIndex) load(th *Thread) Value {
-	return th.stack[i.abs(th)]
+	return th.stack[th.checkStack(i.abs(th))]
 }
 
 func (i StackIndex) store(th *Thread, v Value) {
-	th.stack[i.abs(th)] = v
+	th.stack[th.checkStack(i.abs(th))] = v
+}
+
+// checkStack returns abs if it is an index into the stack, and panics with an InvalidStackIndex otherwise.
+func (th *Thread) checkStack(abs int) int {
+	if uint(abs) >= uint(len(th.stack)) {
+		panic(InvalidStackIndex(abs))
+	}
+	return abs
 }
 
 func (i RegisterIndex) String() string {
@@ -342,6 +1120,8 @@ func (i RegisterIndex) load(th *Thread) Value {
 	ri := int(i - specialRegisters)
 	if ri >= 0 && ri < callRegisters {
 		return th.local[ri]
+	} else if uint(ri-callRegisters) >= volatileRegisters {
+		panic(InvalidRegister(i))
 	}
 	return th.reg[ri-callRegisters]
 }
@@ -370,40 +1150,21 @@ func (i RegisterIndex) store(th *Thread, v Value) {
 		default:
 			panic(fmt.Errorf("invalid pc type: %T: %v", v, v))
 		}
-		if pc < 0 || pc > int64(len(th.code)) {
-			panic(fmt.Errorf("invalid pc: %d", pc))
-		}
-		th.pc = pc
+		th.setPC(pc)
 
 	case 1:
 		panic(errEBPStore)
 
 	case 2:
-		var (
-			sp  = int(toint(v))
-			esp = len(th.stack)
-			ebp = th.ebp
-		)
-
-		if sp < ebp {
-			panic(ErrUnderflow)
-		}
-
-		switch {
-		case sp < esp:
-			th.resizeStack(sp)
-		case sp > cap(th.stack):
-			th.growStack(sp - cap(th.stack))
-			fallthrough
-		case sp > esp:
-			th.stack = th.stack[0:sp:cap(th.stack)]
-		}
+		th.setStackTop(int(toint(v)))
 
 	default:
 		ri := int(i - specialRegisters)
 		if ri >= 0 && ri < callRegisters {
 			th.local[ri] = v
 			return
+		} else if uint(ri-callRegisters) >= volatileRegisters {
+			panic(InvalidRegister(i))
 		}
 		th.reg[ri-callRegisters] = v
 	}
@@ -1,7 +1,10 @@
 package rvm
 
 import (
+	"errors"
 	"fmt"
+	"math"
+	"runtime"
 	"testing"
 )
 
@@ -13,20 +16,20 @@ func TestInstructionCoding(t *testing.T) {
 	}
 
 	tests := []test{
-		{"test", Instruction(mkTestInstr(cmpLess, true, RegisterIndex(5), constIndex(1023))), "test (%5 < const[1023]) == true"},
-		{"test", Instruction(mkTestInstr(cmpLequal, true, RegisterIndex(5), constIndex(10))), "test (%5 <= const[10]) == true"},
-		{"test", Instruction(mkTestInstr(cmpEqual, true, RegisterIndex(5), constIndex(10))), "test (%5 == const[10]) == true"},
-		{"test", Instruction(mkTestInstr(cmpNotEqual, true, RegisterIndex(5), constIndex(10))), "test (%5 <> const[10]) == true"},
-		{"test", Instruction(mkTestInstr(cmpGreater, true, RegisterIndex(5), constIndex(10))), "test (%5 > const[10]) == true"},
-		{"test", Instruction(mkTestInstr(cmpGequal, true, StackIndex(233), StackIndex(-233))), "test (stack[233] >= stack[-233]) == true"},
-		{"test", Instruction(mkTestInstr(cmpIncludes, true, StackIndex(255), StackIndex(-254))), "test (stack[255] includes stack[-254]) == true"},
-		{"test", Instruction(mkTestInstr(cmpExcludes, true, RegisterIndex(5), constIndex(10))), "test (%5 excludes const[10]) == true"},
-
-		{"load", Instruction(mkLoadInstr(StackIndex(-64), constIndex(65535))), "load stack[-64] const[65535]"},
+		{"test", Instruction(mkTestInstr(CmpLess, true, RegisterIndex(5), ConstIndex(1023))), "test (%5 < const[1023]) == true"},
+		{"test", Instruction(mkTestInstr(CmpLequal, true, RegisterIndex(5), ConstIndex(10))), "test (%5 <= const[10]) == true"},
+		{"test", Instruction(mkTestInstr(CmpEqual, true, RegisterIndex(5), ConstIndex(10))), "test (%5 == const[10]) == true"},
+		{"test", Instruction(mkTestInstr(CmpNotEqual, true, RegisterIndex(5), ConstIndex(10))), "test (%5 <> const[10]) == true"},
+		{"test", Instruction(mkTestInstr(CmpGreater, true, RegisterIndex(5), ConstIndex(10))), "test (%5 > const[10]) == true"},
+		{"test", Instruction(mkTestInstr(CmpGequal, true, StackIndex(233), StackIndex(-233))), "test (stack[233] >= stack[-233]) == true"},
+		{"test", Instruction(mkTestInstr(CmpIncludes, true, StackIndex(255), StackIndex(-254))), "test (stack[255] includes stack[-254]) == true"},
+		{"test", Instruction(mkTestInstr(CmpExcludes, true, RegisterIndex(5), ConstIndex(10))), "test (%5 excludes const[10]) == true"},
+
+		{"load", Instruction(mkLoadInstr(StackIndex(-64), ConstIndex(65535))), "load stack[-64] const[65535]"},
 		{"load", Instruction(mkLoadInstr(RegisterIndex(63), StackIndex(-32768))), "load %63 stack[-32768]"},
 		{"load", Instruction(mkLoadInstr(RegisterIndex(2), RegisterIndex(1))), "load %esp %ebp"},
 
-		{"xload", Instruction(mkXloadInstr(StackIndex(-32768), constIndex(4294967295))), "xload stack[-32768] const[4294967295]"},
+		{"xload", Instruction(mkXloadInstr(StackIndex(-32768), ConstIndex(4294967295))), "xload stack[-32768] const[4294967295]"},
 		{"xload", Instruction(mkXloadInstr(RegisterIndex(63), StackIndex(-2147483648))), "xload %63 stack[-2147483648]"},
 		{"xload", Instruction(mkXloadInstr(RegisterIndex(2), RegisterIndex(1))), "xload %esp %ebp"},
 
@@ -36,7 +39,7 @@ func TestInstructionCoding(t *testing.T) {
 		{"jump", Instruction(mkJumpInstr(0, RegisterIndex(63))), "jump %63"},
 		{"jump", Instruction(mkJumpInstr(0, RegisterIndex(0))), "jump %pc"},
 		{"jump", Instruction(mkJumpInstr(0, StackIndex(-4194304))), "jump stack[-4194304]"},
-		{"jump", Instruction(mkJumpInstr(0, constIndex(16777215))), "jump const[16777215]"},
+		{"jump", Instruction(mkJumpInstr(0, ConstIndex(16777215))), "jump const[16777215]"},
 
 		{"add", Instruction(mkBinaryInstr(OpAdd, StackIndex(-32), StackIndex(-32), StackIndex(-512))), "add stack[-32] stack[-32] stack[-512]"},
 		{"sub", Instruction(mkBinaryInstr(OpSub, StackIndex(-32), StackIndex(-32), StackIndex(-512))), "sub stack[-32] stack[-32] stack[-512]"},
@@ -46,13 +49,13 @@ func TestInstructionCoding(t *testing.T) {
 
 		{"push", Instruction(mkPushPop(OpPush, 1, RegisterIndex(32))), "push 1 %32"},
 		{"push", Instruction(mkPushPop(OpPush, 1, StackIndex(-131072))), "push 1 stack[-131072]"},
-		{"push", Instruction(mkPushPop(OpPush, 1, constIndex(262143))), "push 1 const[262143]"},
+		{"push", Instruction(mkPushPop(OpPush, 1, ConstIndex(262143))), "push 1 const[262143]"},
 		{"push", Instruction(mkPushPop(OpPush, 33, RegisterIndex(31))), "push 33 %31"},
 		{"push", Instruction(mkPushPop(OpPush, 33, StackIndex(-131072))), "push 33 stack[-131072]"},
-		{"push", Instruction(mkPushPop(OpPush, 33, constIndex(262143))), "push 33 const[262143]"},
+		{"push", Instruction(mkPushPop(OpPush, 33, ConstIndex(262143))), "push 33 const[262143]"},
 		{"push", Instruction(mkPushPop(OpPush, 64, RegisterIndex(0))), "push 64 %pc"},
 		{"push", Instruction(mkPushPop(OpPush, 64, StackIndex(-131072))), "push 64 stack[-131072]"},
-		{"push", Instruction(mkPushPop(OpPush, 64, constIndex(262143))), "push 64 const[262143]"},
+		{"push", Instruction(mkPushPop(OpPush, 64, ConstIndex(262143))), "push 64 const[262143]"},
 
 		{"pop", Instruction(mkPushPop(OpPop, 1, RegisterIndex(32))), "pop 1 %32"},
 		{"pop", Instruction(mkPushPop(OpPop, 1, StackIndex(-131072))), "pop 1 stack[-131072]"},
@@ -60,14 +63,37 @@ func TestInstructionCoding(t *testing.T) {
 		{"pop", Instruction(mkPushPop(OpPop, 33, StackIndex(-131072))), "pop 33 stack[-131072]"},
 		{"pop", Instruction(mkPushPop(OpPop, 64, RegisterIndex(0))), "pop 64 %pc"},
 		{"pop", Instruction(mkPushPop(OpPop, 64, StackIndex(-131072))), "pop 64 stack[-131072]"},
+		{"push", Instruction(mkPushPop(OpPush, 1, ImmediateIndex(-131072))), "push 1 $-131072"},
+		{"push", Instruction(mkPushPop(OpPush, 64, ImmediateIndex(131071))), "push 64 $131071"},
+		{"xpush", Instruction(mkXpushInstr(ConstIndex(4294967295))), "xpush const[4294967295]"},
+
+		{"pop", Instruction(mkPushPop(OpPop, 1, nil)), "pop 1"},
+		{"pop", Instruction(mkPushPop(OpPop, 64, nil)), "pop 64"},
 
-		{"load", Instruction(mkLoadInstr(RegisterIndex(31), constIndex(1))), "load %31 const[1]"},
+		{"dup", Instruction(mkStackOp(OpDup, 1, 0)), "dup 1 0"},
+		{"dup", Instruction(mkStackOp(OpDup, 64, 131071)), "dup 64 131071"},
+		{"rot", Instruction(mkStackOp(OpRotate, 3, 1)), "rot 3 1"},
+		{"rot", Instruction(mkStackOp(OpRotate, 3, -131072)), "rot 3 -131072"},
+
+		{"load", Instruction(mkLoadInstr(RegisterIndex(31), ConstIndex(1))), "load %31 const[1]"},
 		{"load", Instruction(mkLoadInstr(RegisterIndex(11), StackIndex(-3))), "load %11 stack[-3]"},
-		{"add", Instruction(mkBinaryInstr(OpAdd, RegisterIndex(11), RegisterIndex(11), constIndex(2))), "add %11 %11 const[2]"},
+		{"add", Instruction(mkBinaryInstr(OpAdd, RegisterIndex(11), RegisterIndex(11), ConstIndex(2))), "add %11 %11 const[2]"},
 		{"add", Instruction(mkBinaryInstr(OpAdd, RegisterIndex(11), RegisterIndex(11), StackIndex(3))), "add %11 %11 stack[3]"},
 		{"add", Instruction(mkBinaryInstr(OpAdd, RegisterIndex(11), RegisterIndex(11), RegisterIndex(31))), "add %11 %11 %31"},
-		{"add", Instruction(mkBinaryInstr(OpAdd, RegisterIndex(11), RegisterIndex(11), constIndex(2))), "add %11 %11 const[2]"},
-		{"add", Instruction(mkBinaryInstr(OpSub, RegisterIndex(4), RegisterIndex(11), constIndex(1))), "sub %4 %11 const[1]"},
+		{"add", Instruction(mkBinaryInstr(OpAdd, RegisterIndex(11), RegisterIndex(11), ConstIndex(2))), "add %11 %11 const[2]"},
+		{"add", Instruction(mkBinaryInstr(OpSub, RegisterIndex(4), RegisterIndex(11), ConstIndex(1))), "sub %4 %11 const[1]"},
+
+		{"neg", newUnary(OpNeg, RegisterIndex(4), ConstIndex(2047)), "neg %4 const[2047]"},
+		{"neg", newUnary(OpNeg, StackIndex(-32), StackIndex(-512)), "neg stack[-32] stack[-512]"},
+		{"not", newUnary(OpNot, RegisterIndex(63), RegisterIndex(3)), "not %63 %3"},
+		{"round", newRound(RegisterIndex(4), ConstIndex(2047), RoundTruncate), "round %4 const[2047] trunc"},
+		{"round", newRound(RegisterIndex(4), StackIndex(-1), RoundNearest), "round %4 stack[-1] nearest"},
+		{"round", newRound(StackIndex(31), RegisterIndex(5), RoundFloor), "round stack[31] %5 floor"},
+		{"round", newRound(RegisterIndex(4), RegisterIndex(5), RoundCeil), "round %4 %5 ceil"},
+		{"reserve", newReserve(ConstIndex(3)), "reserve const[3]"},
+		{"reserve", newReserve(RegisterIndex(3)), "reserve %3"},
+		{"alloc", newAlloc(StackIndex(-1)), "alloc stack[-1]"},
+		{"frameadj", newFrameAdjust(ConstIndex(0)), "frameadj const[0]"},
 	}
 
 	for i, tr := range tests {
@@ -88,19 +114,19 @@ func TestOpAdd(t *testing.T) {
 	fn := funcData{
 		code: []uint32{
 			// r[3] = 4
-			mkLoadInstr(RegisterIndex(31), constIndex(1)),
+			mkLoadInstr(RegisterIndex(31), ConstIndex(1)),
 			// r[3] = 4
 			mkLoadInstr(RegisterIndex(11), StackIndex(-3)),
 			// r[2] = s[-3] + 10.3
-			mkBinaryInstr(OpAdd, RegisterIndex(11), RegisterIndex(11), constIndex(2)),
+			mkBinaryInstr(OpAdd, RegisterIndex(11), RegisterIndex(11), ConstIndex(2)),
 			// r[2] += s[3]
 			mkBinaryInstr(OpAdd, RegisterIndex(11), RegisterIndex(11), StackIndex(3)),
 			// r[2] += r[3]
 			mkBinaryInstr(OpAdd, RegisterIndex(11), RegisterIndex(11), RegisterIndex(31)),
 			// r[2] += 10.3
-			mkBinaryInstr(OpAdd, RegisterIndex(11), RegisterIndex(11), constIndex(2)),
+			mkBinaryInstr(OpAdd, RegisterIndex(11), RegisterIndex(11), ConstIndex(2)),
 			// r[0] = r[2] - 4
-			mkBinaryInstr(OpSub, RegisterIndex(4), RegisterIndex(11), constIndex(1)),
+			mkBinaryInstr(OpSub, RegisterIndex(4), RegisterIndex(11), ConstIndex(1)),
 		},
 		consts: []Value{Float(0), Float(4), Float(10.3), Int(-1)},
 	}
@@ -117,13 +143,238 @@ func TestOpAdd(t *testing.T) {
 	})
 }
 
+func TestOpUnary(t *testing.T) {
+	th := NewThread()
+
+	var code []uint32
+	for _, instr := range []Instruction{
+		newLoad(RegisterIndex(3), ConstIndex(0)),
+		newUnary(OpNeg, RegisterIndex(4), ConstIndex(0)),
+		newUnary(OpNot, StackIndex(0), RegisterIndex(3)),
+		newRound(RegisterIndex(5), StackIndex(0), RoundCeil),
+		newXload(RegisterIndex(6), ConstIndex(1)),
+	} {
+		code = instr.AppendTo(code)
+	}
+
+	th.pushFrame(0, funcData{code: code, consts: []Value{Int(5), Uint(7)}})
+	th.Push(nil)
+
+	testRunThread(t, th)
+	testThreadState(t, th, []threadStateTest{
+		{RegisterIndex(4), Int(-5)},
+		{StackIndex(0), Int(-6)},
+		{RegisterIndex(5), Int(-6)},
+		{RegisterIndex(6), Uint(7)},
+	})
+}
+
+func TestOpTestLoadsOperands(t *testing.T) {
+	th := NewThread()
+
+	fn := funcData{
+		code: codeTable(nil).
+			load(RegisterIndex(20), ConstIndex(0)).
+			test(CmpEqual, true, RegisterIndex(20), ConstIndex(1)). // 7 == 7
+			load(RegisterIndex(21), ImmediateIndex(1)).
+			test(CmpEqual, true, RegisterIndex(20), ConstIndex(2)). // 7 == 8
+			load(RegisterIndex(22), ImmediateIndex(1)).
+			v(),
+		consts: []Value{Int(7), Int(7), Int(8)},
+	}
+
+	th.pushFrame(0, fn)
+
+	testRunThread(t, th)
+	testThreadState(t, th, []threadStateTest{
+		{RegisterIndex(21), Int(1)},
+		{RegisterIndex(22), nil},
+	})
+}
+
+func TestOpTestNumeric(t *testing.T) {
+	type test struct {
+		lhs, rhs Value
+		op       CompareOp
+		want     bool
+	}
+
+	tests := []test{
+		{Int(1), Int(2), CmpLess, true},
+		{Int(2), Int(2), CmpLequal, true},
+		{Int(-1), Uint(0), CmpLess, true},
+		{Uint(0), Int(-1), CmpGreater, true},
+		{Uint(3), Float(3), CmpEqual, true},
+		{Float(2.5), Int(2), CmpGreater, true},
+		{Float(math.NaN()), Float(math.NaN()), CmpEqual, false},
+		{Int(1), Int(2), CmpEqual, false},
+	}
+
+	for _, c := range tests {
+		th := NewThread()
+		th.pushFrame(0, funcData{
+			code: codeTable(nil).
+				load(RegisterIndex(20), ConstIndex(0)).
+				test(c.op, true, RegisterIndex(20), ConstIndex(1)).
+				load(RegisterIndex(21), ImmediateIndex(1)).
+				v(),
+			consts: []Value{c.lhs, c.rhs},
+		})
+
+		testRunThread(t, th)
+		if got := th.At(RegisterIndex(21)) != nil; got != c.want {
+			t.Errorf("%v %v %v = %t; want %t", c.lhs, c.op, c.rhs, got, c.want)
+		}
+	}
+}
+
+func TestImmediateOperands(t *testing.T) {
+	th := NewThread()
+
+	var code []uint32
+	for _, instr := range []Instruction{
+		newLoad(RegisterIndex(3), ImmediateIndex(-1000)),
+		newBinary(OpAdd, RegisterIndex(4), RegisterIndex(3), ImmediateIndex(255)),
+		newUnary(OpNeg, RegisterIndex(5), ImmediateIndex(-256)),
+		newXload(RegisterIndex(6), ImmediateIndex(1<<31-1)),
+		newAlloc(ImmediateIndex(2)),
+	} {
+		code = instr.AppendTo(code)
+	}
+
+	th.pushFrame(0, funcData{code: code})
+
+	testRunThread(t, th)
+	testThreadState(t, th, []threadStateTest{
+		{RegisterIndex(3), Int(-1000)},
+		{RegisterIndex(4), Int(-745)},
+		{RegisterIndex(5), Int(256)},
+		{RegisterIndex(6), Int(1<<31 - 1)},
+	})
+	if len(th.stack) != 2 {
+		t.Errorf("len(stack) = %d; want 2", len(th.stack))
+	}
+}
+
+func TestLoadToReg(t *testing.T) {
+	srcs := []Index{
+		RegisterIndex(0), RegisterIndex(2), RegisterIndex(4), RegisterIndex(40),
+		ConstIndex(0), ConstIndex(1),
+		StackIndex(0), StackIndex(2), StackIndex(-1), StackIndex(-3),
+		ImmediateIndex(0), ImmediateIndex(-8192), ImmediateIndex(8191),
+	}
+	dsts := []RegisterIndex{3, 4, 40}
+
+	newThread := func() *Thread {
+		th := NewThread()
+		th.pushFrame(0, funcData{
+			code:   make([]uint32, 4),
+			consts: []Value{Int(100), NewLazy(func() Value { return Float(0.5) })},
+		})
+		th.pc = 1
+		for i := 0; i < 3; i++ {
+			th.Push(Int(10 + i))
+		}
+		th.local[RegisterIndex(4)-specialRegisters] = Uint(4)
+		th.reg[RegisterIndex(40)-specialRegisters-callRegisters] = Int(40)
+		return th
+	}
+
+	for _, src := range srcs {
+		for _, dst := range dsts {
+			instr := Instruction(mkLoadInstr(dst, src))
+			fast, generic := newThread(), newThread()
+			if !loadToReg(instr, fast) {
+				t.Errorf("%v: not specialized", instr)
+				continue
+			}
+			instr.loadDst().store(generic, instr.loadSrc().load(generic))
+
+			if got, want := fast.At(dst), generic.At(dst); got != want {
+				t.Errorf("%v: %v = %#v; want %#v", instr, dst, got, want)
+			}
+		}
+	}
+
+	for _, instr := range []Instruction{
+		Instruction(mkLoadInstr(StackIndex(0), RegisterIndex(3))),
+		Instruction(mkXloadInstr(RegisterIndex(3), ImmediateIndex(1<<20))),
+	} {
+		if loadToReg(instr, newThread()) {
+			t.Errorf("%v: specialized; want generic", instr)
+		}
+	}
+}
+
+func TestOpVector(t *testing.T) {
+	th := NewThread()
+	for i := 1; i <= 4; i++ {
+		th.Push(Int(i))
+	}
+
+	fn := funcData{
+		code: codeTable(nil).
+			push(3, ConstIndex(0)).                                                         // [1 2 3 4 10 20 30]
+			pop(3, RegisterIndex(3)).                                                       // %3..%5 = 10 20 30
+			vector(OpAdd, 3, RegisterIndex(6), RegisterIndex(3), StackIndex(1)).            // %6..%8 = 12 23 34
+			vector(OpMul, 4, StackIndex(-4), StackIndex(0), ImmediateIndex(-2)).            // [-2 -4 -6 -8]
+			vector(OpSub, 2, RegisterIndex(20), RegisterIndex(6), ConstIndex(3)).           // %20..%21 = 11.5 21.5
+			vector(OpAdd, 3, RegisterIndex(4), RegisterIndex(3), ImmediateIndex(1)).        // %4..%6 = 11 12 13
+			vector(OpBitshift, 2, RegisterIndex(22), RegisterIndex(7), ImmediateIndex(-1)). // %22..%23 = 46 68
+			v(),
+		consts: []Value{Int(10), Int(20), Int(30), Float(0.5), Float(1.5)},
+	}
+	th.pushFrame(-4, fn)
+
+	testRunThread(t, th)
+	testThreadState(t, th, []threadStateTest{
+		{RegisterIndex(3), Int(10)},
+		{RegisterIndex(4), Int(11)},
+		{RegisterIndex(5), Int(12)},
+		{RegisterIndex(6), Int(13)},
+		{RegisterIndex(7), Int(23)},
+		{RegisterIndex(8), Int(34)},
+		{RegisterIndex(20), Float(11.5)},
+		{RegisterIndex(21), Float(21.5)},
+		{RegisterIndex(22), Int(46)},
+		{RegisterIndex(23), Int(68)},
+		{StackIndex(0), Int(-2)},
+		{StackIndex(1), Int(-4)},
+		{StackIndex(2), Int(-6)},
+		{StackIndex(3), Int(-8)},
+	})
+}
+
+func TestVecOperandStore(t *testing.T) {
+	th := NewThread()
+	tests := []struct {
+		ix   Index
+		want error
+	}{
+		{ConstIndex(0), errConstStore},
+		{ImmediateIndex(1), errImmediateStore},
+	}
+
+	for _, c := range tests {
+		func() {
+			defer func() {
+				if rc := recover(); rc != c.want {
+					t.Errorf("store to %v: panic = %v; want %v", c.ix, rc, c.want)
+				}
+			}()
+			o := th.vecOperand(c.ix)
+			o.store(th, 0, Int(1))
+		}()
+	}
+}
+
 func TestOpPushPop(t *testing.T) {
 	th := NewThread()
 
 	fn := funcData{
 		code: codeTable(nil).
-			push(2, constIndex(2)).                   // [3, 4]
-			push(2, constIndex(0)).                   // [3, 4, 1, 2]
+			push(2, ConstIndex(2)).                   // [3, 4]
+			push(2, ConstIndex(0)).                   // [3, 4, 1, 2]
 			pop(4, RegisterIndex(4)).                 // r[4...] = [3, 4, 1, 2]
 			load(RegisterIndex(3), RegisterIndex(5)). // t = r[5]
 			load(RegisterIndex(5), RegisterIndex(6)). // r[5] = r[6] -> [3, 1, 1, 2]
@@ -145,18 +396,192 @@ func TestOpPushPop(t *testing.T) {
 	})
 }
 
+func TestOpPushStackRange(t *testing.T) {
+	th := NewThread()
+
+	fn := funcData{
+		code: codeTable(nil).
+			push(3, ConstIndex(0)).  // [1, 2, 3]
+			push(2, StackIndex(-2)). // [1, 2, 3, 2, 3]
+			push(2, StackIndex(0)).  // [1, 2, 3, 2, 3, 1, 2]
+			v(),
+		consts: []Value{Int(1), Int(2), Int(3)},
+	}
+
+	th.pushFrame(0, fn)
+
+	testRunThread(t, th)
+
+	want := []Value{Int(1), Int(2), Int(3), Int(2), Int(3), Int(1), Int(2)}
+	if got := fmt.Sprint(th.stack); got != fmt.Sprint(want) {
+		t.Errorf("stack = %v; want %v", got, want)
+	}
+}
+
+func TestOpPopStackRange(t *testing.T) {
+	th := NewThread()
+
+	fn := funcData{
+		code: codeTable(nil).
+			push(3, ImmediateIndex(0)). // [0, 0, 0]
+			push(2, ConstIndex(0)).     // [0, 0, 0, 1, 2]
+			pop(2, StackIndex(-2)).     // [0, 1, 2]
+			push(2, ConstIndex(2)).     // [0, 1, 2, 3, 4]
+			pop(2, StackIndex(0)).      // [3, 4, 2]
+			v(),
+		consts: []Value{Int(1), Int(2), Int(3), Int(4)},
+	}
+
+	th.pushFrame(0, fn)
+
+	testRunThread(t, th)
+
+	want := []Value{Int(3), Int(4), Int(2)}
+	if got := fmt.Sprint(th.stack); got != fmt.Sprint(want) {
+		t.Errorf("stack = %v; want %v", got, want)
+	}
+}
+
+func TestOpPushMixed(t *testing.T) {
+	th := NewThread()
+
+	fn := funcData{
+		code: codeTable(nil).
+			push(2, ImmediateIndex(-7)).           // [-7, -7]
+			load(RegisterIndex(3), ConstIndex(0)). // r[3] = 1
+			xpush(ConstIndex(1)).                  // [-7, -7, 1, -7, 2]
+			v(),
+		consts: []Value{
+			Int(1),
+			PushList{RegisterIndex(3), StackIndex(-1), ConstIndex(2)},
+			Int(2),
+		},
+	}
+
+	th.pushFrame(0, fn)
+
+	testRunThread(t, th)
+
+	want := []Value{Int(-7), Int(-7), Int(1), Int(-7), Int(2)}
+	if len(th.stack) != len(want) {
+		t.Fatalf("len(stack) = %d; want %d", len(th.stack), len(want))
+	}
+	for i, v := range want {
+		if got := th.stack[i]; got != v {
+			t.Errorf("stack[%d] = %v; want %v", i, got, v)
+		}
+	}
+}
+
+func TestOpSlice(t *testing.T) {
+	th := NewThread()
+
+	fn := funcData{
+		code: codeTable(nil).
+			binaryOp(OpSlice, RegisterIndex(3), RegisterIndex(4), ConstIndex(0)). // r[3] = stack[1:3]
+			v(),
+		consts: []Value{Int(2)},
+	}
+
+	th.Push(Int(0))
+	th.pushFrame(0, fn)
+	th.Push(Int(1))
+	th.Push(Int(2))
+	th.Push(Int(3))
+	RegisterIndex(4).store(th, Int(1))
+
+	testRunThread(t, th)
+
+	s, ok := th.At(RegisterIndex(3)).(StackSlice)
+	if !ok {
+		t.Fatalf("r[3] = %#v; want StackSlice", th.At(RegisterIndex(3)))
+	}
+	if s.Len() != 2 || s.Index(0) != Int(2) || s.Index(1) != Int(3) {
+		t.Errorf("slice = %v; want [2 3]", s)
+	}
+
+	// Writes to the stack are visible through the view
+	StackIndex(2).store(th, Int(4))
+	if got := s.Index(1); got != Int(4) {
+		t.Errorf("slice[1] = %v; want 4", got)
+	}
+
+	th.drop(2)
+	defer func() {
+		if rc := recover(); rc != ErrStackRange {
+			t.Errorf("slice[0] after drop: panic = %v; want %v", rc, ErrStackRange)
+		}
+	}()
+	s.Index(0)
+}
+
+func TestOpPopDiscard(t *testing.T) {
+	th := NewThread()
+
+	fn := funcData{
+		code: codeTable(nil).
+			push(4, ConstIndex(0)).   // [1, 2, 3, 4]
+			drop(2).                  // [1, 2]
+			pop(1, RegisterIndex(3)). // r[3] = 2 -> [1]
+			v(),
+		consts: []Value{Int(1), Int(2), Int(3), Int(4)},
+	}
+
+	th.pushFrame(0, fn)
+
+	testRunThread(t, th)
+	testThreadState(t, th, []threadStateTest{
+		{RegisterIndex(3), Int(2)},
+		{StackIndex(-1), Int(1)},
+	})
+	if len(th.stack) != 1 {
+		t.Errorf("len(stack) = %d; want 1", len(th.stack))
+	}
+}
+
+func TestOpDupRotate(t *testing.T) {
+	th := NewThread()
+
+	fn := funcData{
+		code: codeTable(nil).
+			push(3, ConstIndex(0)). // [1, 2, 3]
+			dup(1, 0).              // [1, 2, 3, 3]
+			dup(1, 2).              // [1, 2, 3, 3, 2]
+			dup(2, 3).              // [1, 2, 3, 3, 2, 1, 2]
+			rotate(3, 1).           // [1, 2, 3, 3, 1, 2, 2]
+			rotate(4, -1).          // [1, 2, 3, 2, 3, 1, 2]
+			rotate(7, 9).           // [3, 2, 3, 1, 2, 1, 2]
+			v(),
+		consts: []Value{Int(1), Int(2), Int(3)},
+	}
+
+	th.pushFrame(0, fn)
+
+	testRunThread(t, th)
+
+	want := []Value{Int(3), Int(2), Int(3), Int(1), Int(2), Int(1), Int(2)}
+	if len(th.stack) != len(want) {
+		t.Fatalf("len(stack) = %d; want %d", len(th.stack), len(want))
+	}
+	for i, v := range want {
+		if got := th.stack[i]; got != v {
+			t.Errorf("stack[%d] = %v; want %v", i, got, v)
+		}
+	}
+}
+
 func TestOpBitwiseShift(t *testing.T) {
 	th := NewThread()
 
 	fn := funcData{
 		code: []uint32{
 			// r[3], r[6] = 1003, -1003
-			mkLoadInstr(RegisterIndex(3), constIndex(0)),
-			mkLoadInstr(RegisterIndex(6), constIndex(1)),
-			mkBinaryInstr(OpBitshift, RegisterIndex(4), RegisterIndex(3), constIndex(2)),
-			mkBinaryInstr(OpBitshift, RegisterIndex(5), RegisterIndex(3), constIndex(3)),
-			mkBinaryInstr(OpBitshift, RegisterIndex(7), RegisterIndex(6), constIndex(2)),
-			mkBinaryInstr(OpBitshift, RegisterIndex(8), RegisterIndex(6), constIndex(3)),
+			mkLoadInstr(RegisterIndex(3), ConstIndex(0)),
+			mkLoadInstr(RegisterIndex(6), ConstIndex(1)),
+			mkBinaryInstr(OpBitshift, RegisterIndex(4), RegisterIndex(3), ConstIndex(2)),
+			mkBinaryInstr(OpBitshift, RegisterIndex(5), RegisterIndex(3), ConstIndex(3)),
+			mkBinaryInstr(OpBitshift, RegisterIndex(7), RegisterIndex(6), ConstIndex(2)),
+			mkBinaryInstr(OpBitshift, RegisterIndex(8), RegisterIndex(6), ConstIndex(3)),
 		},
 		consts: []Value{Uint(1003), Float(-1003), Float(4), Float(-4)},
 	}
@@ -180,12 +605,12 @@ func TestOpArithShift(t *testing.T) {
 	fn := funcData{
 		code: []uint32{
 			// r[3], r[6] = 1003, -1003
-			mkLoadInstr(RegisterIndex(3), constIndex(0)),
-			mkLoadInstr(RegisterIndex(6), constIndex(1)),
-			mkBinaryInstr(OpArithshift, RegisterIndex(4), RegisterIndex(3), constIndex(2)),
-			mkBinaryInstr(OpArithshift, RegisterIndex(5), RegisterIndex(3), constIndex(3)),
-			mkBinaryInstr(OpArithshift, RegisterIndex(7), RegisterIndex(6), constIndex(2)),
-			mkBinaryInstr(OpArithshift, RegisterIndex(8), RegisterIndex(6), constIndex(3)),
+			mkLoadInstr(RegisterIndex(3), ConstIndex(0)),
+			mkLoadInstr(RegisterIndex(6), ConstIndex(1)),
+			mkBinaryInstr(OpArithshift, RegisterIndex(4), RegisterIndex(3), ConstIndex(2)),
+			mkBinaryInstr(OpArithshift, RegisterIndex(5), RegisterIndex(3), ConstIndex(3)),
+			mkBinaryInstr(OpArithshift, RegisterIndex(7), RegisterIndex(6), ConstIndex(2)),
+			mkBinaryInstr(OpArithshift, RegisterIndex(8), RegisterIndex(6), ConstIndex(3)),
 		},
 		// Test with float64 for negative side just to ensure conversion works
 		consts: []Value{Uint(1003), Float(-1003), Float(4), Float(-4)},
@@ -204,6 +629,822 @@ func TestOpArithShift(t *testing.T) {
 	})
 }
 
+func TestThreadRoots(t *testing.T) {
+	th := NewThread()
+	th.pushFrame(0, funcData{consts: []Value{Int(1)}})
+	th.Push(Int(2))
+	RegisterIndex(3).store(th, Int(3))
+	th.pushFrame(0, funcData{consts: []Value{Int(4)}})
+	th.Push(Int(5))
+	RegisterIndex(32).store(th, Int(6))
+	th.SetLocal("key", Int(7))
+
+	seen := map[Value]int{}
+	th.Roots(func(v Value) bool {
+		seen[v]++
+		return true
+	})
+
+	// Int(3) is seen twice: once in the current frame's locals and once in the saved frame's locals.
+	want := map[Value]int{Int(1): 1, Int(2): 1, Int(3): 2, Int(4): 1, Int(5): 1, Int(6): 1, Int(7): 1}
+	for v, n := range want {
+		if seen[v] != n {
+			t.Errorf("Roots visited %v %d times; want %d", v, seen[v], n)
+		}
+	}
+	if len(seen) != len(want) {
+		t.Errorf("Roots visited %d distinct values; want %d: %v", len(seen), len(want), seen)
+	}
+
+	n := 0
+	th.Roots(func(Value) bool {
+		n++
+		return false
+	})
+	if n != 1 {
+		t.Errorf("Roots visited %d values after stop; want 1", n)
+	}
+}
+
+func TestRegisterWindow(t *testing.T) {
+	th := NewThread()
+	RegisterIndex(3).store(th, Int(1))
+	RegisterIndex(4).store(th, Int(2))
+	RegisterIndex(5).store(th, Int(3))
+
+	// Only the function's two registers are saved; %5 is left as the caller's.
+	th.pushFrame(0, funcData{info: FuncInfo{Registers: 2}})
+	testThreadState(t, th, []threadStateTest{
+		{RegisterIndex(3), Int(1)},
+		{RegisterIndex(4), Int(2)},
+	})
+	if got, want := fmt.Sprint(th.savedLocals(1)), fmt.Sprint([]Value{Int(1), Int(2)}); got != want {
+		t.Errorf("saved registers = %v; want %v", got, want)
+	}
+
+	RegisterIndex(3).store(th, Int(-1))
+	RegisterIndex(4).store(th, Int(-2))
+	th.pushFrame(0, funcData{info: FuncInfo{Registers: 1}})
+	RegisterIndex(3).store(th, Int(-3))
+	if got := fmt.Sprint(th.savedLocals(1), th.savedLocals(2)); got != "[-1] [1 2]" {
+		t.Errorf("saved registers = %v; want [-1] [1 2]", got)
+	}
+
+	th.popFrame(0)
+	testThreadState(t, th, []threadStateTest{
+		{RegisterIndex(3), Int(-1)},
+		{RegisterIndex(4), Int(-2)},
+	})
+	th.popFrame(0)
+	testThreadState(t, th, []threadStateTest{
+		{RegisterIndex(3), Int(1)},
+		{RegisterIndex(4), Int(2)},
+		{RegisterIndex(5), Int(3)},
+	})
+	if len(th.saved) != 0 {
+		t.Errorf("saved registers = %v after returning; want none", th.saved)
+	}
+}
+
+func TestOpFrameAdjust(t *testing.T) {
+	th := NewThread()
+
+	fn := funcData{
+		code: codeTable(nil).
+			binaryOp(OpFrameAdjust, RegisterIndex(0), RegisterIndex(0), ConstIndex(0)). // ebp += 2
+			load(RegisterIndex(3), StackIndex(0)).                                      // r[3] = s[2]
+			load(RegisterIndex(4), RegisterIndex(1)).                                   // r[4] = ebp
+			binaryOp(OpFrameAdjust, RegisterIndex(0), RegisterIndex(0), ConstIndex(1)). // ebp -= 1
+			load(RegisterIndex(5), StackIndex(0)).                                      // r[5] = s[1]
+			v(),
+		consts: []Value{Int(2), Int(-1)},
+	}
+
+	th.Push(Int(0))
+	th.pushFrame(0, fn)
+	th.Push(Int(1))
+	th.Push(Int(2))
+	th.Push(Int(3))
+
+	testRunThread(t, th)
+	testThreadState(t, th, []threadStateTest{
+		{RegisterIndex(3), Int(3)},
+		{RegisterIndex(4), Int(3)},
+		{RegisterIndex(5), Int(2)},
+	})
+
+	// Popping the frame releases the adjusted region as well
+	th.popFrame(0)
+	if len(th.stack) != 1 {
+		t.Errorf("len(stack) = %d; want 1", len(th.stack))
+	}
+}
+
+func TestOpFrameAdjustBounds(t *testing.T) {
+	tests := []struct {
+		delta Value
+		want  interface{}
+	}{
+		{Int(-1), ErrUnderflow},
+		{Int(2), InvalidStackIndex(3)},
+	}
+
+	for _, tc := range tests {
+		th := NewThread()
+		th.Push(Int(0))
+		th.pushFrame(0, funcData{
+			code:   codeTable(nil).binaryOp(OpFrameAdjust, RegisterIndex(0), RegisterIndex(0), ConstIndex(0)).v(),
+			consts: []Value{tc.delta},
+		})
+		th.Push(Int(1))
+
+		err := th.RunProtected()
+		if rp, ok := err.(*RuntimePanic); !ok || rp.Value != tc.want {
+			t.Errorf("frameadj %v: err = %v; want panic %v", tc.delta, err, tc.want)
+		}
+	}
+}
+
+func TestOpAlloc(t *testing.T) {
+	th := NewThread()
+
+	fn := funcData{
+		code: codeTable(nil).
+			binaryOp(OpAlloc, RegisterIndex(0), RegisterIndex(0), ConstIndex(0)). // alloc 3
+			load(StackIndex(1), ConstIndex(2)).                                   // s[1] = 7
+			load(RegisterIndex(3), RegisterIndex(2)).                             // r[3] = esp
+			binaryOp(OpAlloc, RegisterIndex(0), RegisterIndex(0), ConstIndex(1)). // alloc -2
+			load(RegisterIndex(4), RegisterIndex(2)).                             // r[4] = esp
+			load(RegisterIndex(5), StackIndex(-1)).                               // r[5] = s[1]
+			v(),
+		consts: []Value{Int(3), Int(-2), Int(7)},
+	}
+
+	th.Push(Int(0))
+	th.pushFrame(0, fn)
+
+	testRunThread(t, th)
+	testThreadState(t, th, []threadStateTest{
+		{RegisterIndex(3), Int(4)},
+		{RegisterIndex(4), Int(2)},
+		{RegisterIndex(5), nil},
+	})
+
+	th.pushFrame(0, funcData{
+		code:   codeTable(nil).binaryOp(OpAlloc, RegisterIndex(0), RegisterIndex(0), ConstIndex(0)).v(),
+		consts: []Value{Int(-1)},
+	})
+	if err := th.RunProtected(); err == nil || err.(*RuntimePanic).Value != ErrUnderflow {
+		t.Errorf("alloc -1 at ebp: err = %v; want panic %v", err, ErrUnderflow)
+	}
+}
+
+func TestStoreESPBeyondCapacity(t *testing.T) {
+	th := NewThread()
+	th.Push(Int(1))
+	sp := cap(th.stack) + 10
+	RegisterIndex(2).store(th, Int(sp))
+	if len(th.stack) != sp {
+		t.Fatalf("len(stack) = %d; want %d", len(th.stack), sp)
+	}
+	testThreadState(t, th, []threadStateTest{
+		{StackIndex(0), Int(1)},
+		{StackIndex(-1), nil},
+	})
+}
+
+func TestRuntimePanicTrace(t *testing.T) {
+	th := NewThread()
+	th.pushFrame(0, funcData{info: FuncInfo{Name: "outer", Arity: 1}})
+	th.pushFrame(0, funcData{
+		info: FuncInfo{Name: "inner", Arity: 2, Variadic: true},
+		code: codeTable(nil).drop(1).v(),
+	})
+
+	err := th.RunProtected()
+	rp, ok := err.(*RuntimePanic)
+	if !ok {
+		t.Fatalf("err = %v; want *RuntimePanic", err)
+	}
+
+	want := []string{"inner/2... at pc 1", "outer/1 at pc 0", "<anonymous>/0 at pc 0"}
+	if len(rp.Trace) != len(want) {
+		t.Fatalf("trace = %v; want %v", rp.Trace, want)
+	}
+	for i, w := range want {
+		if got := rp.Trace[i].String(); got != w {
+			t.Errorf("trace[%d] = %q; want %q", i, got, w)
+		}
+	}
+
+	if got, want := err.Error(), "panic: stack underflow (in inner/2... at pc 1)"; got != want {
+		t.Errorf("err.Error() = %q; want %q", got, want)
+	}
+}
+
+func TestLazyConst(t *testing.T) {
+	calls := 0
+	lazy := NewLazy(func() Value {
+		if calls++; calls == 1 {
+			panic("first call fails")
+		}
+		return Int(calls)
+	})
+
+	th := NewThread()
+	th.pushFrame(0, funcData{
+		code: codeTable(nil).
+			load(RegisterIndex(3), ConstIndex(0)).
+			load(RegisterIndex(4), ConstIndex(0)).
+			v(),
+		consts: []Value{lazy},
+	})
+
+	if err := th.RunProtected(); err == nil {
+		t.Fatal("RunProtected() = nil; want panic from first call")
+	}
+
+	th.pc = 0
+	testRunThread(t, th)
+	testThreadState(t, th, []threadStateTest{
+		{RegisterIndex(3), Int(2)},
+		{RegisterIndex(4), Int(2)},
+	})
+	if calls != 2 {
+		t.Errorf("calls = %d; want 2", calls)
+	}
+}
+
+func TestPanicPolicy(t *testing.T) {
+	hostErr := errors.New("host bug")
+	newThread := func(policy PanicPolicy, code codeTable) *Thread {
+		th := NewThread()
+		th.SetPanicPolicy(policy)
+		th.pushFrame(0, funcData{
+			code:   code,
+			consts: []Value{NewLazy(func() Value { panic(hostErr) })},
+		})
+		return th
+	}
+	hostCode := codeTable(nil).load(RegisterIndex(3), ConstIndex(0))
+	faultCode := codeTable(nil).load(RegisterIndex(2), ImmediateIndex(-1))
+
+	// By default, host panics are recovered and wrapped.
+	err := newThread(RecoverAll, hostCode).RunProtected()
+	if rp, ok := err.(*RuntimePanic); !ok {
+		t.Fatalf("RunProtected() = %v; want *RuntimePanic", err)
+	} else if hp, ok := rp.Value.(*HostPanic); !ok || hp.Value != hostErr || len(hp.Stack) == 0 {
+		t.Fatalf("panic value = %#v; want *HostPanic of %v with a stack", rp.Value, hostErr)
+	} else if !errors.Is(rp.Err(), hostErr) {
+		t.Errorf("panic error %v does not wrap %v", rp.Err(), hostErr)
+	}
+
+	// RepanicHost re-raises host panics from both RunProtected and RunN.
+	func() {
+		defer func() {
+			if hp, ok := recover().(*HostPanic); !ok || hp.Value != hostErr {
+				t.Errorf("RunProtected panicked with %v; want *HostPanic of %v", hp, hostErr)
+			}
+		}()
+		newThread(RepanicHost, hostCode).RunProtected()
+	}()
+	testPanics(t, "RunN host panic", func() { newThread(RepanicHost, hostCode).RunN(1) })
+
+	// Interpreter faults are still recovered.
+	if err := newThread(RepanicHost, faultCode).RunProtected(); err == nil || err.(*RuntimePanic).Value != ErrUnderflow {
+		t.Errorf("RunProtected() = %v; want panic %v", err, ErrUnderflow)
+	}
+	if _, _, err := newThread(RepanicHost, faultCode).RunN(1); err == nil {
+		t.Error("RunN() = nil; want underflow")
+	}
+	// rvmdebug builds raise an assertion failure for the index before the load does.
+	stackCode := codeTable(nil).load(RegisterIndex(3), StackIndex(4))
+	if err := newThread(RepanicHost, stackCode).RunProtected(); err == nil || !instrAssertions && err.(*RuntimePanic).Value != InvalidStackIndex(4) {
+		t.Errorf("RunProtected() = %v; want panic %v", err, InvalidStackIndex(4))
+	}
+
+	// Go runtime errors are interpreter bugs and are re-raised.
+	var rtErr runtime.Error
+	func() {
+		defer func() { rtErr, _ = recover().(runtime.Error) }()
+		_ = []Value{}[len(hostCode)]
+	}()
+	if rtErr == nil {
+		t.Fatal("indexing an empty slice did not raise a runtime.Error")
+	}
+	testPanics(t, "RepanicHost runtime error", func() { newThread(RepanicHost, nil).recoverPanic(rtErr) })
+	if rp := newThread(RecoverAll, nil).recoverPanic(rtErr); rp.Value != rtErr {
+		t.Errorf("recoverPanic(%v) = %v; want %[1]v", rtErr, rp.Value)
+	}
+}
+
+// TestRepanicHostScriptFaults checks that out-of-range jumps and stack sizes in a script are faults, not Go runtime
+// errors re-raised by RepanicHost.
+func TestRepanicHostScriptFaults(t *testing.T) {
+	huge := Int(1 << 40)
+	tests := []struct {
+		name string
+		code codeTable
+		want Value
+	}{
+		{"jump before start", codeTable(nil).jump(-100, nil), InvalidPC(-99)},
+		{"jump past end", codeTable(nil).jump(100, nil), InvalidPC(101)},
+		{"jump by const", codeTable(nil).jump(0, ConstIndex(1)), InvalidPC(-99)},
+		{"fused jump", codeTable(nil).test(CmpEqual, true, ConstIndex(0), ConstIndex(0)).jump(-100, nil), InvalidPC(-98)},
+		{"reserve", codeTable(nil).size(OpReserve, ConstIndex(0)), ErrOverflow},
+		{"alloc", codeTable(nil).size(OpAlloc, ConstIndex(0)), ErrOverflow},
+		{"load %esp", codeTable(nil).load(RegisterIndex(2), ConstIndex(0)), ErrOverflow},
+	}
+
+	for _, tc := range tests {
+		th := NewThread()
+		th.SetPanicPolicy(RepanicHost)
+		th.pushFrame(0, funcData{code: tc.code, consts: []Value{huge, Int(-100)}})
+		func() {
+			defer func() {
+				if rc := recover(); rc != nil {
+					t.Errorf("%s: RunProtected panicked: %v", tc.name, rc)
+				}
+			}()
+			err := th.RunProtected()
+			if rp, ok := err.(*RuntimePanic); !ok {
+				t.Errorf("%s: RunProtected() = %v; want *RuntimePanic", tc.name, err)
+			} else if !instrAssertions && rp.Value != tc.want {
+				t.Errorf("%s: panic = %v; want %v", tc.name, rp.Value, tc.want)
+			}
+		}()
+	}
+}
+
+func TestRunN(t *testing.T) {
+	th := NewThread()
+	th.pushFrame(0, funcData{
+		code: codeTable(nil).
+			push(1, ImmediateIndex(1)).
+			push(1, ImmediateIndex(2)).
+			push(1, ImmediateIndex(3)).
+			drop(4).
+			v(),
+	})
+
+	type step struct {
+		n        int
+		executed int
+		done     bool
+		err      bool
+	}
+	steps := []step{
+		{0, 0, false, false},
+		{2, 2, false, false},
+		{1, 1, false, false},
+		{5, 0, true, true}, // drop 4 underflows before it completes
+	}
+
+	for i, s := range steps {
+		executed, done, err := th.RunN(s.n)
+		if executed != s.executed || done != s.done || (err != nil) != s.err {
+			t.Errorf("(%d) RunN(%d) = %d, %t, %v; want %d, %t, err? %t",
+				i+1, s.n, executed, done, err, s.executed, s.done, s.err)
+		}
+	}
+
+	th = NewThread()
+	th.pushFrame(0, funcData{code: codeTable(nil).push(1, ImmediateIndex(1)).v()})
+	if executed, done, err := th.RunN(5); executed != 1 || !done || err != nil {
+		t.Errorf("RunN(5) = %d, %t, %v; want 1, true, <nil>", executed, done, err)
+	}
+
+	// Instructions that complete before a fault are counted; the faulting instruction is not.
+	th = NewThread()
+	th.pushFrame(0, funcData{code: codeTable(nil).
+		load(RegisterIndex(3), ImmediateIndex(1)).
+		load(RegisterIndex(4), ImmediateIndex(0)).
+		binaryOp(OpDiv, RegisterIndex(5), RegisterIndex(3), RegisterIndex(4)).
+		v(),
+	})
+	if executed, done, err := th.RunN(5); executed != 2 || !done || err == nil {
+		t.Errorf("RunN(5) = %d, %t, %v; want 2, true, divide by zero", executed, done, err)
+	}
+}
+
+func TestThreadStats(t *testing.T) {
+	th := NewThread()
+	if got, want := th.Stats(), (Stats{MaxFrames: 1}); got != want {
+		t.Errorf("Stats() = %+v; want %+v", got, want)
+	}
+
+	th.pushFrame(0, funcData{
+		code: codeTable(nil).
+			push(3, ImmediateIndex(1)).
+			dup(3, 0).
+			drop(5).
+			v(),
+	})
+	th.pushFrame(0, funcData{})
+	th.popFrame(0)
+
+	testRunThread(t, th)
+	if got, want := th.Stats(), (Stats{MaxStack: 6, MaxFrames: 3}); got != want {
+		t.Errorf("Stats() = %+v; want %+v", got, want)
+	}
+
+	th.ResetStats()
+	if got, want := th.Stats(), (Stats{MaxStack: 1, MaxFrames: 2}); got != want {
+		t.Errorf("Stats() after reset = %+v; want %+v", got, want)
+	}
+
+	for i := cap(th.stack); i >= 0; i-- {
+		th.Push(nil)
+	}
+	if got := th.Stats().StackGrowths; got != 1 {
+		t.Errorf("StackGrowths = %d; want 1", got)
+	}
+}
+
+func TestZeroPolicy(t *testing.T) {
+	th := NewThread()
+	th.SetZeroPolicy(ZeroOnReuse)
+	th.Push(Int(1))
+	th.Push(Int(2))
+	th.drop(2)
+	if got := th.stack[:2]; got[0] != Int(1) || got[1] != Int(2) {
+		t.Errorf("released slots = %v; want [1 2] retained", got)
+	}
+
+	// Growing over released slots without writing them clears them
+	th.allocStack(1)
+	if got := th.stack[0]; got != nil {
+		t.Errorf("stack[0] after alloc = %v; want nil", got)
+	}
+
+	th.SetZeroPolicy(ZeroOnRelease)
+	if got := th.stack[:2][1]; got != nil {
+		t.Errorf("released slot after ZeroOnRelease = %v; want nil", got)
+	}
+
+	th.Push(Int(3))
+	th.drop(1)
+	if got := th.stack[:2][1]; got != nil {
+		t.Errorf("released slot = %v; want nil", got)
+	}
+}
+
+func BenchmarkPopZeroPolicy(b *testing.B) {
+	policies := []struct {
+		name   string
+		policy ZeroPolicy
+	}{
+		{"ZeroOnRelease", ZeroOnRelease},
+		{"ZeroOnReuse", ZeroOnReuse},
+	}
+
+	for _, p := range policies {
+		b.Run(p.name, func(b *testing.B) {
+			th := NewThread()
+			th.SetZeroPolicy(p.policy)
+			th.pushFrame(0, funcData{
+				code: codeTable(nil).
+					push(64, ImmediateIndex(1)).
+					drop(64).
+					v(),
+			})
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				th.pc = 0
+				th.Run()
+			}
+		})
+	}
+}
+
+func TestThreadLocals(t *testing.T) {
+	type key int
+	th := NewThread()
+
+	if got := th.Local(key(1)); got != nil {
+		t.Errorf("Local(1) = %v; want nil", got)
+	}
+
+	th.SetLocal(key(1), "one")
+	th.SetLocal(1, "int one")
+	if got := th.Local(key(1)); got != "one" {
+		t.Errorf("Local(key(1)) = %v; want one", got)
+	}
+	if got := th.Local(1); got != "int one" {
+		t.Errorf("Local(1) = %v; want int one", got)
+	}
+
+	th.SetLocal(key(1), nil)
+	if got := th.Local(key(1)); got != nil {
+		t.Errorf("Local(key(1)) = %v after delete; want nil", got)
+	}
+}
+
+func TestOpTLS(t *testing.T) {
+	th := NewThread()
+	th.pushFrame(0, funcData{
+		code: codeTable(nil).
+			load(RegisterIndex(3), ImmediateIndex(42)).
+			tls(true, 3, RegisterIndex(3)).
+			v(),
+	})
+	th.Run()
+
+	// TLS is per thread, not per frame, so a new frame sees what the last one stored.
+	th.pushFrame(0, funcData{
+		code: codeTable(nil).
+			tls(false, 3, RegisterIndex(3)).
+			tls(false, 4, RegisterIndex(4)).
+			v(),
+	})
+	th.Run()
+
+	if got := th.At(RegisterIndex(3)); got != Int(42) {
+		t.Errorf("%%3 = %v; want 42", got)
+	}
+	if got := th.At(RegisterIndex(4)); got != nil {
+		t.Errorf("%%4 = %v; want nil", got)
+	}
+	if got := len(th.tls); got != 4 {
+		t.Errorf("len(tls) = %d; want 4", got)
+	}
+
+	testPanics(t, "slot out of range", func() { th.At(TLSIndex(TLSRange.Max + 1)) })
+}
+
+func TestCopyAndResizeStack(t *testing.T) {
+	tests := []struct {
+		newTop, keep int
+		want         []Value // nil if the transfer must panic
+	}{
+		{0, 0, []Value{}},
+		{1, 2, []Value{Int(0), Int(3), Int(4)}},
+		{2, 2, []Value{Int(0), Int(1), Int(3), Int(4)}}, // Overlapping
+		{3, 2, []Value{Int(0), Int(1), Int(2), Int(3), Int(4)}},
+		{5, 0, []Value{Int(0), Int(1), Int(2), Int(3), Int(4)}},
+		{0, 5, []Value{Int(0), Int(1), Int(2), Int(3), Int(4)}},
+		{4, 2, nil},
+		{6, 0, nil},
+		{0, 6, nil},
+		{-1, 1, nil},
+		{1, -1, nil},
+	}
+
+	for _, tc := range tests {
+		th := NewThread()
+		for i := 0; i < 5; i++ {
+			th.Push(Int(i))
+		}
+
+		if tc.want == nil {
+			want := &StackTransferError{NewTop: tc.newTop, Keep: tc.keep, Len: 5}
+			func() {
+				defer func() {
+					if rc, ok := recover().(*StackTransferError); !ok || *rc != *want {
+						t.Errorf("copyAndResizeStack(%d, %d) panicked with %v; want %v", tc.newTop, tc.keep, rc, want)
+					}
+				}()
+				th.copyAndResizeStack(tc.newTop, tc.keep)
+			}()
+			continue
+		}
+
+		th.copyAndResizeStack(tc.newTop, tc.keep)
+		if got := fmt.Sprint(th.stack); got != fmt.Sprint(tc.want) {
+			t.Errorf("copyAndResizeStack(%d, %d): stack = %v; want %v", tc.newTop, tc.keep, got, tc.want)
+		}
+	}
+}
+
+func TestPushFrameUnderflow(t *testing.T) {
+	th := NewThread()
+	th.Push(Int(1))
+	th.Push(Int(2))
+	th.Push(Int(3))
+
+	// A frame may take any values above the current ebp.
+	th.pushFrame(-2, funcData{})
+	if got := th.At(RegisterIndex(1)); got != Int(1) {
+		t.Fatalf("%%ebp = %v; want 1", got)
+	}
+	if got := th.At(StackIndex(0)); got != Int(2) {
+		t.Errorf("stack[0] = %v; want 2", got)
+	}
+
+	testPanics(t, "ebp below caller's ebp", func() { th.pushFrame(-3, funcData{}) })
+}
+
+func TestThreadReturn(t *testing.T) {
+	th := NewThread()
+	testPanics(t, "Return without Enter", func() { th.Return(0) })
+
+	th.Push(Int(1))
+	th.Push(Int(2))
+	RegisterIndex(3).store(th, Int(3))
+	th.Enter(Function{Info: FuncInfo{Arity: 1}}, 1)
+	RegisterIndex(3).store(th, Int(-3))
+	th.Push(Int(4))
+	th.Push(Int(5))
+
+	testPanics(t, "keep beyond frame", func() { th.Return(4) })
+	testPanics(t, "negative keep", func() { th.Return(-1) })
+
+	th.Return(2)
+	if got, want := fmt.Sprint(th.stack), fmt.Sprint([]Value{Int(1), Int(4), Int(5)}); got != want {
+		t.Errorf("stack = %v; want %v", got, want)
+	}
+	if got := th.At(RegisterIndex(3)); got != Int(3) {
+		t.Errorf("%%3 = %v; want 3", got)
+	}
+}
+
+func TestEnterConstBase(t *testing.T) {
+	pool := []Value{Int(1), Int(2), Int(3)}
+	code := codeTable(nil).load(RegisterIndex(20), ConstIndex(0)).v()
+
+	for base, want := range pool {
+		th := NewThread()
+		th.Enter(Function{Code: code, Consts: pool, ConstBase: base}, 0)
+		testRunThread(t, th)
+		if got := th.At(RegisterIndex(20)); got != want {
+			t.Errorf("ConstBase %d: %%20 = %v; want %v", base, got, want)
+		}
+	}
+
+	th := NewThread()
+	testPanics(t, "negative base", func() { th.Enter(Function{Consts: pool, ConstBase: -1}, 0) })
+	testPanics(t, "base past end", func() { th.Enter(Function{Consts: pool, ConstBase: len(pool) + 1}, 0) })
+	testPanics(t, "negative len", func() { th.Enter(Function{Consts: pool, ConstLen: -1}, 0) })
+	testPanics(t, "len past end", func() { th.Enter(Function{Consts: pool, ConstBase: 1, ConstLen: len(pool)}, 0) })
+}
+
+func TestEnterConstLen(t *testing.T) {
+	pool := []Value{Int(1), Int(2), Int(3)}
+	code := codeTable(nil).load(RegisterIndex(20), ConstIndex(1)).v()
+
+	th := NewThread()
+	th.Enter(Function{Code: code, Consts: pool, ConstBase: 1, ConstLen: 2}, 0)
+	testRunThread(t, th)
+	if got := th.At(RegisterIndex(20)); got != Int(3) {
+		t.Errorf("%%20 = %v; want 3", got)
+	}
+
+	// const[1] belongs to the next function in the pool.
+	th = NewThread()
+	th.Enter(Function{Code: code, Consts: pool, ConstBase: 0, ConstLen: 1}, 0)
+	if err := th.RunProtected(); err == nil {
+		t.Error("load past ConstLen: err = nil; want panic")
+	}
+}
+
+func TestEnterArity(t *testing.T) {
+	fixed := Function{Info: FuncInfo{Arity: 2}}
+	variadic := Function{Info: FuncInfo{Arity: 2, Variadic: true}}
+
+	// Each Return drops the frame's arguments, so push enough for every call.
+	th := NewThread()
+	for i := 0; i < 8; i++ {
+		th.Push(Int(i))
+	}
+	th.Enter(fixed, 2)
+	th.Return(0)
+	th.Enter(variadic, 2)
+	th.Return(0)
+	th.Enter(variadic, 4)
+	th.Return(0)
+
+	testPanics(t, "too few arguments", func() { th.Enter(fixed, 1) })
+	testPanics(t, "too many arguments", func() { th.Enter(fixed, 3) })
+	testPanics(t, "too few variadic arguments", func() { th.Enter(variadic, 1) })
+}
+
+func TestOpFrame(t *testing.T) {
+	th := NewThread()
+	th.Push(Int(1))
+	th.Push(Int(2))
+	th.pushFrame(0, funcData{})
+	th.Push(Int(3))
+	th.Push(Int(4))
+	th.Push(Int(5))
+	th.pushFrame(-1, funcData{
+		code: codeTable(nil).
+			frame(false, FrameIndex{1, 0}, RegisterIndex(3)).
+			frame(false, FrameIndex{1, -1}, RegisterIndex(4)).
+			frame(false, FrameIndex{2, -1}, RegisterIndex(5)).
+			frame(true, FrameIndex{2, 0}, StackIndex(0)).
+			v(),
+	})
+	th.Run()
+
+	want := map[Index]Value{
+		RegisterIndex(3): Int(3),
+		RegisterIndex(4): Int(4), // Top of the caller is below the callee's arguments
+		RegisterIndex(5): Int(2),
+		FrameIndex{2, 0}: Int(5),
+		FrameIndex{1, 1}: Int(4),
+	}
+	for ix, want := range want {
+		if got := th.At(ix); got != want {
+			t.Errorf("%v = %v; want %v", ix, got, want)
+		}
+	}
+
+	// Indices outside of the caller's frame, including the callee's own values, are rejected.
+	for _, fx := range []FrameIndex{{1, 2}, {1, -3}, {2, 2}, {2, -3}, {3, 0}, {0, 0}} {
+		fx := fx
+		testPanics(t, fx.String(), func() { th.At(fx) })
+	}
+}
+
+func TestReservedOpcode(t *testing.T) {
+	for _, op := range []struct {
+		instr Instruction
+		want  Opcode
+	}{
+		{Instruction(opcodeBits(opCount)), opCount},
+		{Instruction(xopcodeBits(opCount)) | instrExtendedBit, opCount},
+		// Extended opcodes past the handler table must not alias the opcodes below them.
+		{Instruction(xopcodeBits(0x40)) | instrExtendedBit, 0x40},
+		{Instruction(xopcodeBits(0x60)) | instrExtendedBit, 0x60},
+	} {
+		th := NewThread()
+		th.pushFrame(0, funcData{code: op.instr.AppendTo(nil)})
+		err := th.RunProtected()
+		if rp, ok := err.(*RuntimePanic); !ok || rp.Value != InvalidOpcode(op.want) {
+			t.Errorf("%016x: err = %v; want panic %v", uint64(op.instr), err, InvalidOpcode(op.want))
+		}
+	}
+}
+
+func TestRelRegisterIndex(t *testing.T) {
+	th := NewThread()
+	th.pushFrame(0, funcData{
+		code: codeTable(nil).
+			load(RegisterIndex(3), ImmediateIndex(20)).
+			xload(RelRegisterIndex{RegisterIndex(3), 2}, ImmediateIndex(7)).
+			xload(RegisterIndex(4), RelRegisterIndex{RegisterIndex(3), 2}).
+			v(),
+	})
+	th.Run()
+
+	if got := th.At(RegisterIndex(22)); got != Int(7) {
+		t.Errorf("%%22 = %v; want 7", got)
+	}
+	if got := th.At(RegisterIndex(4)); got != Int(7) {
+		t.Errorf("%%4 = %v; want 7", got)
+	}
+
+	for _, ix := range []RelRegisterIndex{{RegisterIndex(3), 44}, {RegisterIndex(3), -18}} {
+		ix := ix
+		testPanics(t, ix.String(), func() { th.At(ix) })
+	}
+}
+
+func TestStackGrowth(t *testing.T) {
+	th := NewThread()
+	th.stack = th.stack[:0:2]
+	old := th.stack[:2]
+	th.Push(Int(1))
+	th.Push(Int(2))
+
+	th.Push(Int(3))
+	if got := cap(th.stack); got != 4 {
+		t.Errorf("cap(stack) = %d; want 4", got)
+	}
+	if old[0] != nil || old[1] != nil {
+		t.Errorf("old stack = %v; want cleared", old)
+	}
+
+	th.Reserve(100)
+	if got := cap(th.stack); got != 103 {
+		t.Errorf("cap(stack) = %d; want 103", got)
+	}
+	th.Reserve(1)
+	if got := th.Stats().StackGrowths; got != 2 {
+		t.Errorf("StackGrowths = %d; want 2", got)
+	}
+
+	testThreadState(t, th, []threadStateTest{
+		{StackIndex(0), Int(1)},
+		{StackIndex(1), Int(2)},
+		{StackIndex(2), Int(3)},
+	})
+}
+
+func BenchmarkPush(b *testing.B) {
+	for _, n := range []int{16, 1024, 65536} {
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				th := NewThread()
+				for j := 0; j < n; j++ {
+					th.Push(Int(j))
+				}
+			}
+		})
+	}
+}
+
 type threadStateTest struct {
 	index Index
 	want  Value
@@ -0,0 +1,75 @@
+package rvm
+
+// vectorOp executes a vector instruction, storing fn(argA[j], argB[j]) in out[j] for each j in 0..n-1. Register,
+// stack, and constant operands are ranges starting at their encoded index, and an immediate argB is used for every
+// element. Stack indices are resolved once, before the first element. Each element is computed and stored before the
+// next is loaded, so overlapping ranges see earlier results.
+func (th *Thread) vectorOp(instr Instruction, fn func(lhs, rhs Value) Value) {
+	var (
+		n    = instr.vecCount()
+		args = instr.vecArgs()
+		out  = th.vecOperand(args.regOut())
+		argA = th.vecOperand(args.argA())
+		argB = th.vecOperand(args.argB())
+	)
+	if th.faults.Overflow != FaultDefault {
+		op := instr.Opcode()
+		for j := 0; j < n; j++ {
+			lhs, rhs := argA.load(th, j), argB.load(th, j)
+			v := fn(lhs, rhs)
+			checkOverflow(op, lhs, rhs, v)
+			out.store(th, j, v)
+		}
+		return
+	}
+	for j := 0; j < n; j++ {
+		out.store(th, j, fn(argA.load(th, j), argB.load(th, j)))
+	}
+}
+
+// vecOperand is a vector instruction operand range, decoded once so that each element is loaded or stored without
+// boxing an Index.
+type vecOperand struct {
+	kind OperandKind
+	base int   // First register, constant index, or absolute stack index
+	imm  Value // Immediate value
+}
+
+func (th *Thread) vecOperand(ix Index) vecOperand {
+	switch ix := ix.(type) {
+	case RegisterIndex:
+		return vecOperand{kind: OperandReg, base: int(ix)}
+	case StackIndex:
+		return vecOperand{kind: OperandStack, base: ix.abs(th)}
+	case ConstIndex:
+		return vecOperand{kind: OperandConst, base: int(ix)}
+	default:
+		return vecOperand{kind: OperandImmediate, imm: ix.load(th)}
+	}
+}
+
+func (o *vecOperand) load(th *Thread, j int) Value {
+	switch o.kind {
+	case OperandReg:
+		return RegisterIndex(o.base + j).load(th)
+	case OperandStack:
+		return th.stack[th.checkStack(o.base+j)]
+	case OperandConst:
+		return ConstIndex(o.base + j).load(th)
+	default:
+		return o.imm
+	}
+}
+
+func (o *vecOperand) store(th *Thread, j int, v Value) {
+	switch o.kind {
+	case OperandReg:
+		RegisterIndex(o.base+j).store(th, v)
+	case OperandStack:
+		th.stack[th.checkStack(o.base+j)] = v
+	case OperandImmediate:
+		panic(errImmediateStore)
+	default:
+		panic(errConstStore)
+	}
+}